@@ -0,0 +1,165 @@
+package v1alpha1
+
+import (
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+)
+
+// DynamicNamespaceDefaults are applied to DynamicNamespaces that leave the corresponding fields empty.
+// Changes are picked up without a restart
+type DynamicNamespaceDefaults struct {
+	// Квота окружения, если в спецификации не задан createQuota.
+	// По умолчанию cpu 100m, memory 100Mi, ephemeral-storage 100Mi
+	// +optional
+	Quota v1.ResourceList `json:"quota,omitempty"`
+
+	// ClusterRole для roleBindingSubjects, если не задан spec.role. По умолчанию admin
+	// +optional
+	Role string `json:"role,omitempty"`
+
+	// ClusterRole, которые можно указать в spec.role DynamicNamespace и DynamicNamespacePool.
+	// По умолчанию admin, edit и view. Роли из --approval-restricted-roles разрешены сверх списка,
+	// но требуют подтверждения
+	// +optional
+	AllowedRoles []string `json:"allowedRoles,omitempty"`
+
+	// Шаблон text/template имени целевого namespace с полями .Name и .Namespace
+	// ресурса DynamicNamespace. По умолчанию {{ .Name }}. Применяется только к новым окружениям
+	// +optional
+	NamespaceTemplate string `json:"namespaceTemplate,omitempty"`
+
+	// Политика удаления, если не задан spec.expiration
+	// +optional
+	Expiration *platformv1.ExpirationPolicy `json:"expiration,omitempty"`
+
+	// Наибольший spec.size DynamicNamespacePool. По умолчанию 10
+	// +optional
+	MaxPoolSize int32 `json:"maxPoolSize,omitempty"`
+}
+
+// GarbageCollectionConfig configures the orphaned namespace collector. Applied on start
+type GarbageCollectionConfig struct {
+	// Период между проходами сборщика; 0 отключает сборщик
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Сколько времени namespace должен оставаться сиротой до удаления
+	// +optional
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+
+	// Только сообщать о найденных сиротах, ничего не удаляя
+	// +optional
+	DryRun *bool `json:"dryRun,omitempty"`
+}
+
+// RateLimiterConfig configures the work queues of the controllers: per-item exponential backoff
+// after failures combined with a token bucket shared by all items. Applied on start
+type RateLimiterConfig struct {
+	// Задержка первой повторной обработки ресурса после ошибки; удваивается с каждой ошибкой. По умолчанию 5ms
+	// +optional
+	BaseDelay *metav1.Duration `json:"baseDelay,omitempty"`
+
+	// Предельная задержка повторной обработки ресурса. По умолчанию 5m
+	// +optional
+	MaxDelay *metav1.Duration `json:"maxDelay,omitempty"`
+
+	// Средняя частота постановки ресурсов в очередь контроллера в секунду. По умолчанию 10
+	// +optional
+	QPS *int32 `json:"qps,omitempty"`
+
+	// Допустимый всплеск поверх qps. По умолчанию 100
+	// +optional
+	Burst *int32 `json:"burst,omitempty"`
+}
+
+// NotifierConfig configures lifecycle notifications
+type NotifierConfig struct {
+	// Ссылка на конфигурацию в кластере: Secret/<namespace>/<name> или ConfigMap/<namespace>/<name>.
+	// Если задана, endpoints из файла не используются. Применяется при запуске
+	// +optional
+	ConfigRef string `json:"configRef,omitempty"`
+
+	// Адресаты уведомлений. Изменения применяются без перезапуска
+	// +optional
+	Endpoints []NotifierEndpoint `json:"endpoints,omitempty"`
+
+	// Количество попыток доставки. По умолчанию 5
+	// +optional
+	Retries int `json:"retries,omitempty"`
+
+	// Таймаут одного запроса. По умолчанию 10s
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// NotifierEndpoint is a webhook receiving lifecycle notifications
+type NotifierEndpoint struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// Формат тела запроса: generic (по умолчанию), slack или teams
+	// +optional
+	Format string `json:"format,omitempty"`
+
+	// Типы событий. Пустой список - все события
+	// +optional
+	Events []string `json:"events,omitempty"`
+
+	// Дополнительные заголовки, например Authorization
+	// +optional
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DynamicNamespaceConfig is the configuration file of the dynamicnamespace controller
+type DynamicNamespaceConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// Настройки менеджера: метрики, health-проверки, leader election, webhook и
+	// controller.groupKindConcurrency для числа параллельных обработчиков. Применяются при запуске
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// Значения по умолчанию для DynamicNamespace
+	// +optional
+	Defaults DynamicNamespaceDefaults `json:"defaults,omitempty"`
+
+	// Namespace, в которых обрабатываются DynamicNamespace и DynamicNamespacePool.
+	// Пустой список - все namespace. Ресурсы из других namespace получают статус REJECTED.
+	// Изменения применяются без перезапуска
+	// +optional
+	SourceNamespaces []string `json:"sourceNamespaces,omitempty"`
+
+	// Селектор лейблов namespace, в которых обрабатываются DynamicNamespace и DynamicNamespacePool.
+	// Действует вместе с sourceNamespaces. Изменения применяются без перезапуска
+	// +optional
+	SourceNamespaceSelector *metav1.LabelSelector `json:"sourceNamespaceSelector,omitempty"`
+
+	// Сборщик осиротевших namespace
+	// +optional
+	GarbageCollection GarbageCollectionConfig `json:"garbageCollection,omitempty"`
+
+	// Уведомления о жизненном цикле окружений
+	// +optional
+	Notifier NotifierConfig `json:"notifier,omitempty"`
+
+	// Ограничение частоты обработки ресурсов
+	// +optional
+	RateLimiter RateLimiterConfig `json:"rateLimiter,omitempty"`
+
+	// Язык сообщений статусов и событий: en (по умолчанию) или ru. Коды причин от языка не зависят.
+	// Изменения применяются без перезапуска
+	// +optional
+	Language string `json:"language,omitempty"`
+}
+
+// Complete реализует config.ControllerManagerConfiguration
+func (c *DynamicNamespaceConfig) Complete() (cfg.ControllerManagerConfigurationSpec, error) {
+	return c.ControllerManagerConfigurationSpec, nil
+}
+
+func init() {
+	SchemeBuilder.Register(&DynamicNamespaceConfig{})
+}
@@ -3,32 +3,263 @@ package v1
 import (
 	"k8s.io/api/core/v1"
 	"k8s.io/api/rbac/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // DynamicNamespaceSpec defines the desired state of DynamicNamespace
 type DynamicNamespaceSpec struct {
-	// +kubebuilder:default:={cpu: "100m", ephemeral-storage: "100Mi", memory: "100Mi"}
+	// Квота целевого namespace. Если не задана, используется defaults.quota из конфигурации контроллера
 	// +optional
 	CreateQuota v1.ResourceList `json:"createQuota,omitempty"`
 
+	// Дополнительные квоты целевого namespace, например с scopes BestEffort или
+	// scopeSelector по PriorityClass. Применяются вместе с квотой из createQuota
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Quotas []NamedQuota `json:"quotas,omitempty"`
+
 	// +optional
 	RoleBindingSubjects []v1beta1.Subject `json:"roleBindingSubjects,omitempty"`
+
+	// ClusterRole, которая выдается субъектам RoleBinding в namespace окружения.
+	// По умолчанию defaults.role из конфигурации контроллера (admin)
+	// +optional
+	Role string `json:"role,omitempty"`
+
+	// Имя DynamicNamespacePool в том же namespace, из которого берется заранее подготовленный namespace.
+	// Если в пуле нет свободных namespace, он создается обычным образом
+	// +optional
+	Pool string `json:"pool,omitempty"`
+
+	// Спящий режим: все Deployment и StatefulSet целевого namespace масштабируются до нуля
+	// +optional
+	Suspended bool `json:"suspended,omitempty"`
+
+	// Расписание спящего режима, например с 20:00 до 08:00 по будням
+	// +optional
+	SleepSchedule *SleepSchedule `json:"sleepSchedule,omitempty"`
+
+	// Политика автоматического удаления окружения
+	// +optional
+	Expiration *ExpirationPolicy `json:"expiration,omitempty"`
+
+	// Дополнительные лейблы целевого namespace, наследуются дочерними namespace
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Разрешить входящий трафик только из namespace этого окружения: целевого и дочерних
+	// +optional
+	IsolateNetwork bool `json:"isolateNetwork,omitempty"`
+
+	// Дочерние namespace окружения. Они наследуют лейблы, субъекты RoleBinding и сетевые правила,
+	// а их квоты вычитаются из createQuota
+	// +optional
+	Children []ChildNamespace `json:"children,omitempty"`
+
+	// Автоматическое изменение квоты целевого namespace в пределах max
+	// +optional
+	QuotaAutoscaling *QuotaAutoscaling `json:"quotaAutoscaling,omitempty"`
 }
 
+// QuotaAutoscaling defines how the quota of the target namespace follows its usage
+type QuotaAutoscaling struct {
+	// Верхняя граница лимитов квоты. Обязательна для каждого ресурса createQuota: без нее
+	// окружение отклоняется
+	// +optional
+	Max v1.ResourceList `json:"max,omitempty"`
+
+	// Процент использования, выше которого квота увеличивается. По умолчанию 90
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	ScaleUpThreshold int32 `json:"scaleUpThreshold,omitempty"`
+
+	// Процент использования, ниже которого квота уменьшается, но не ниже createQuota. По умолчанию 50
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	ScaleDownThreshold int32 `json:"scaleDownThreshold,omitempty"`
+
+	// Шаг изменения в процентах от createQuota. По умолчанию 50
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	StepPercent int32 `json:"stepPercent,omitempty"`
+
+	// Сколько использование должно держаться за порогом, прежде чем квота изменится. По умолчанию 30m
+	// +optional
+	Window *metav1.Duration `json:"window,omitempty"`
+}
+
+// QuotaAutoscalingStatus records the limits set by quota autoscaling and their history
+type QuotaAutoscalingStatus struct {
+	// Лимиты, выставленные автомасштабированием поверх createQuota
+	// +optional
+	Current v1.ResourceList `json:"current,omitempty"`
+
+	// С какого момента использование ресурса держится выше порога увеличения
+	// +optional
+	HighSince map[v1.ResourceName]metav1.Time `json:"highSince,omitempty"`
+
+	// С какого момента использование ресурса держится ниже порога уменьшения
+	// +optional
+	LowSince map[v1.ResourceName]metav1.Time `json:"lowSince,omitempty"`
+
+	// Последние изменения квоты
+	// +optional
+	History []QuotaScaleEvent `json:"history,omitempty"`
+}
+
+// QuotaScaleEvent is a single change of a quota limit
+type QuotaScaleEvent struct {
+	Time     metav1.Time       `json:"time"`
+	Resource v1.ResourceName   `json:"resource"`
+	From     resource.Quantity `json:"from"`
+	To       resource.Quantity `json:"to"`
+}
+
+// ChildNamespace defines a namespace created together with the parent environment
+type ChildNamespace struct {
+	// Суффикс имени: дочерний namespace называется <целевой namespace>-<name>
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+
+	// Квота дочернего namespace, выделяемая из квоты родителя
+	// +optional
+	Quota v1.ResourceList `json:"quota,omitempty"`
+}
+
+// NamedQuota defines an additional ResourceQuota of the target namespace
+type NamedQuota struct {
+	// Имя квоты: ResourceQuota называется <имя DynamicNamespace>-<name>
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+
+	// Лимиты квоты. Кроме вычислительных ресурсов поддерживаются количества объектов
+	// (count/deployments.apps) и лимиты по StorageClass (<class>.storageclass.storage.k8s.io/requests.storage)
+	Hard v1.ResourceList `json:"hard"`
+
+	// Области действия квоты, например BestEffort или NotTerminating
+	// +optional
+	Scopes []v1.ResourceQuotaScope `json:"scopes,omitempty"`
+
+	// Селектор областей действия, например по PriorityClass
+	// +optional
+	ScopeSelector *v1.ScopeSelector `json:"scopeSelector,omitempty"`
+}
+
+// QuotaUsage mirrors the hard limits and usage of the generated ResourceQuota
+type QuotaUsage struct {
+	// Лимиты квоты
+	Hard v1.ResourceList `json:"hard,omitempty"`
+
+	// Использованные ресурсы
+	Used v1.ResourceList `json:"used,omitempty"`
+}
+
+// ExpirationPolicy defines when the environment is deleted automatically
+type ExpirationPolicy struct {
+	// Время жизни окружения с момента создания
+	// +optional
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+
+	// Окружение удаляется, если в нем не было активности дольше указанного времени:
+	// перезапусков и создания подов, изменений Deployment, StatefulSet, Service и Ingress,
+	// обновления аннотации platform.cloudnative.space/last-activity. Поды, пересозданные при
+	// пробуждении по расписанию сна, активностью не считаются
+	// +optional
+	IdleTimeout *metav1.Duration `json:"idleTimeout,omitempty"`
+
+	// За сколько до удаления предупреждать событием и условием Expiring. По умолчанию 24h.
+	// Окружение удаляется не раньше, чем через этот период после предупреждения
+	// +optional
+	WarningPeriod *metav1.Duration `json:"warningPeriod,omitempty"`
+}
+
+// SleepSchedule defines a recurring window in which the environment is suspended
+type SleepSchedule struct {
+	// Время засыпания в формате HH:MM
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Start string `json:"start"`
+
+	// Время пробуждения в формате HH:MM. Если оно меньше времени засыпания, окно переходит через полночь
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	End string `json:"end"`
+
+	// Дни недели, в которые начинается окно сна. Пустой список - каждый день
+	// +optional
+	Days []Weekday `json:"days,omitempty"`
+
+	// Часовой пояс в формате IANA, например Europe/Moscow. По умолчанию UTC
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// Weekday is a three-letter English day name
+// +kubebuilder:validation:Enum=Mon;Tue;Wed;Thu;Fri;Sat;Sun
+type Weekday string
+
 // DynamicNamespaceStatus defines the observed state of DynamicNamespace
 type DynamicNamespaceStatus struct {
-	// +kubebuilder:validation:Enum=ACTIVE;ERROR
+	// +kubebuilder:validation:Enum=ACTIVE;SUSPENDED;ERROR;AWAITING_APPROVAL;REJECTED;PLANNED
 	// Код статуса
 	Code string `json:"code"`
 
+	// Машиночитаемый код причины состояния; не зависит от языка сообщений
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
 	// Информация о состоянии ресурса
 	Message string `json:"message"`
+
+	// Имя целевого namespace
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Пользователь, подтвердивший запрос, превышающий политику
+	// +optional
+	ApprovedBy string `json:"approvedBy,omitempty"`
+
+	// Имена дочерних namespace
+	// +optional
+	Children []string `json:"children,omitempty"`
+
+	// Изменения, которые внесла бы обработка ресурса в режиме dry-run
+	// +optional
+	Plan []string `json:"plan,omitempty"`
+
+	// Использование квоты целевого namespace
+	// +optional
+	QuotaUsage *QuotaUsage `json:"quotaUsage,omitempty"`
+
+	// Максимальный процент использования среди ресурсов квоты
+	// +optional
+	QuotaUtilization int32 `json:"quotaUtilization,omitempty"`
+
+	// Состояние автомасштабирования квоты
+	// +optional
+	QuotaAutoscaling *QuotaAutoscalingStatus `json:"quotaAutoscaling,omitempty"`
+
+	// Время автоматического удаления окружения
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// Время последней замеченной активности в окружении
+	// +optional
+	LastActivity *metav1.Time `json:"lastActivity,omitempty"`
+
+	// Условия состояния ресурса
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:printcolumn:name="Status",description="Текущий статус ресурса",type=string,JSONPath=`.status.code`
 // +kubebuilder:printcolumn:name="Message",description="Сообщение о статусе ресурса",type=string,JSONPath=`.status.message`
+// +kubebuilder:printcolumn:name="Quota%",description="Максимальный процент использования квоты",type=integer,JSONPath=`.status.quotaUtilization`
 // +kubebuilder:printcolumn:name="Timestamp",description="Дата создания",type=string,JSONPath=`.metadata.creationTimestamp`
 
 // +kubebuilder:object:root=true
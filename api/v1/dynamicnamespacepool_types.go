@@ -0,0 +1,100 @@
+package v1
+
+import (
+	"k8s.io/api/core/v1"
+	"k8s.io/api/rbac/v1beta1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DynamicNamespacePoolSpec defines the desired state of DynamicNamespacePool
+type DynamicNamespacePoolSpec struct {
+	// Количество готовых свободных namespace в пуле
+	// +kubebuilder:validation:Minimum=0
+	Size int32 `json:"size"`
+
+	// Квота, с которой создаются свободные namespace пула
+	// +kubebuilder:default:={cpu: "100m", ephemeral-storage: "100Mi", memory: "100Mi"}
+	// +optional
+	CreateQuota v1.ResourceList `json:"createQuota,omitempty"`
+
+	// Лейблы свободных namespace пула. Остаются на namespace после его захвата
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Аннотации свободных namespace пула. Остаются на namespace после его захвата
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// ConfigMap и Secret из namespace пула, которые копируются в каждый свободный namespace
+	// +optional
+	Seed []PoolSeed `json:"seed,omitempty"`
+
+	// Субъекты, которым выдается доступ к свободным namespace, например сервисный аккаунт,
+	// прогревающий окружения. При захвате namespace их RoleBinding заменяется RoleBinding окружения
+	// +optional
+	RoleBindingSubjects []v1beta1.Subject `json:"roleBindingSubjects,omitempty"`
+
+	// ClusterRole для roleBindingSubjects. По умолчанию defaults.role из конфигурации контроллера
+	// +optional
+	Role string `json:"role,omitempty"`
+}
+
+// PoolSeed references an object in the pool namespace that is copied into every free namespace
+type PoolSeed struct {
+	// Тип объекта
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	Kind string `json:"kind"`
+
+	// Имя объекта в namespace пула; копия получает то же имя
+	Name string `json:"name"`
+}
+
+// DynamicNamespacePoolStatus defines the observed state of DynamicNamespacePool
+type DynamicNamespacePoolStatus struct {
+	// Количество готовых свободных namespace
+	Ready int32 `json:"ready"`
+
+	// Машиночитаемый код причины состояния пула; не зависит от языка сообщений
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Информация о состоянии пула
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Namespace, которые создал или удалил бы пул в режиме dry-run
+	// +optional
+	Plan []string `json:"plan,omitempty"`
+}
+
+// +kubebuilder:printcolumn:name="Size",description="Желаемый размер пула",type=integer,JSONPath=`.spec.size`
+// +kubebuilder:printcolumn:name="Ready",description="Готовые свободные namespace",type=integer,JSONPath=`.status.ready`
+// +kubebuilder:printcolumn:name="Timestamp",description="Дата создания",type=string,JSONPath=`.metadata.creationTimestamp`
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=dnpool
+// +kubebuilder:k8s:openapi-gen=true
+
+// DynamicNamespacePool is the Schema for the dynamicnamespacepools API
+type DynamicNamespacePool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DynamicNamespacePoolSpec   `json:"spec,omitempty"`
+	Status DynamicNamespacePoolStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DynamicNamespacePoolList contains a list of DynamicNamespacePool
+type DynamicNamespacePoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DynamicNamespacePool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DynamicNamespacePool{}, &DynamicNamespacePoolList{})
+}
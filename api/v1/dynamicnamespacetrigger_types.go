@@ -0,0 +1,95 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DynamicNamespaceTriggerSpec defines how Git events create and delete DynamicNamespaces
+type DynamicNamespaceTriggerSpec struct {
+	// Провайдер, от которого приходят события
+	// +kubebuilder:validation:Enum=GitLab;GitHub
+	Provider string `json:"provider"`
+
+	// Репозиторий: path_with_namespace для GitLab, full_name для GitHub. Пустое значение - любой репозиторий
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// Secret в namespace триггера с общим секретом: токеном GitLab или секретом подписи GitHub
+	SecretRef SecretKeyReference `json:"secretRef"`
+
+	// Правила сопоставления веток и окружений. Применяется первое подходящее правило
+	// +kubebuilder:validation:MinItems=1
+	Rules []TriggerRule `json:"rules"`
+}
+
+// SecretKeyReference points to a key of a Secret in the same namespace
+type SecretKeyReference struct {
+	// Имя Secret
+	Name string `json:"name"`
+
+	// Ключ в Secret. По умолчанию token
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// TriggerRule maps source branches to a DynamicNamespace template
+type TriggerRule struct {
+	// Регулярное выражение для имени исходной ветки. Пустое значение - любая ветка
+	// +optional
+	Branch string `json:"branch,omitempty"`
+
+	// Префикс имени окружения: DynamicNamespace называется <namePrefix><ветка>, приведенной к DNS-метке
+	// +optional
+	NamePrefix string `json:"namePrefix,omitempty"`
+
+	// Спецификация создаваемого DynamicNamespace
+	// +optional
+	Template DynamicNamespaceSpec `json:"template,omitempty"`
+}
+
+// DynamicNamespaceTriggerStatus defines the observed state of DynamicNamespaceTrigger
+type DynamicNamespaceTriggerStatus struct {
+	// Время последнего обработанного события
+	// +optional
+	LastEventTime *metav1.Time `json:"lastEventTime,omitempty"`
+
+	// Машиночитаемый код результата последнего события; не зависит от языка сообщений
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Результат обработки последнего события
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:printcolumn:name="Provider",description="Провайдер Git",type=string,JSONPath=`.spec.provider`
+// +kubebuilder:printcolumn:name="Repository",description="Репозиторий",type=string,JSONPath=`.spec.repository`
+// +kubebuilder:printcolumn:name="Message",description="Результат последнего события",type=string,JSONPath=`.status.message`
+// +kubebuilder:printcolumn:name="Timestamp",description="Дата создания",type=string,JSONPath=`.metadata.creationTimestamp`
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=dntrigger
+// +kubebuilder:k8s:openapi-gen=true
+
+// DynamicNamespaceTrigger is the Schema for the dynamicnamespacetriggers API
+type DynamicNamespaceTrigger struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DynamicNamespaceTriggerSpec   `json:"spec,omitempty"`
+	Status DynamicNamespaceTriggerStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DynamicNamespaceTriggerList contains a list of DynamicNamespaceTrigger
+type DynamicNamespaceTriggerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DynamicNamespaceTrigger `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DynamicNamespaceTrigger{}, &DynamicNamespaceTriggerList{})
+}
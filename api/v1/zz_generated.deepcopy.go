@@ -23,16 +23,39 @@ package v1
 import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/api/rbac/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChildNamespace) DeepCopyInto(out *ChildNamespace) {
+	*out = *in
+	if in.Quota != nil {
+		in, out := &in.Quota, &out.Quota
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChildNamespace.
+func (in *ChildNamespace) DeepCopy() *ChildNamespace {
+	if in == nil {
+		return nil
+	}
+	out := new(ChildNamespace)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DynamicNamespace) DeepCopyInto(out *DynamicNamespace) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicNamespace.
@@ -85,6 +108,131 @@ func (in *DynamicNamespaceList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DynamicNamespacePool) DeepCopyInto(out *DynamicNamespacePool) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicNamespacePool.
+func (in *DynamicNamespacePool) DeepCopy() *DynamicNamespacePool {
+	if in == nil {
+		return nil
+	}
+	out := new(DynamicNamespacePool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DynamicNamespacePool) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DynamicNamespacePoolList) DeepCopyInto(out *DynamicNamespacePoolList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]DynamicNamespacePool, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicNamespacePoolList.
+func (in *DynamicNamespacePoolList) DeepCopy() *DynamicNamespacePoolList {
+	if in == nil {
+		return nil
+	}
+	out := new(DynamicNamespacePoolList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DynamicNamespacePoolList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DynamicNamespacePoolSpec) DeepCopyInto(out *DynamicNamespacePoolSpec) {
+	*out = *in
+	if in.CreateQuota != nil {
+		in, out := &in.CreateQuota, &out.CreateQuota
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Seed != nil {
+		in, out := &in.Seed, &out.Seed
+		*out = make([]PoolSeed, len(*in))
+		copy(*out, *in)
+	}
+	if in.RoleBindingSubjects != nil {
+		in, out := &in.RoleBindingSubjects, &out.RoleBindingSubjects
+		*out = make([]v1beta1.Subject, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicNamespacePoolSpec.
+func (in *DynamicNamespacePoolSpec) DeepCopy() *DynamicNamespacePoolSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DynamicNamespacePoolSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DynamicNamespacePoolStatus) DeepCopyInto(out *DynamicNamespacePoolStatus) {
+	*out = *in
+	if in.Plan != nil {
+		in, out := &in.Plan, &out.Plan
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicNamespacePoolStatus.
+func (in *DynamicNamespacePoolStatus) DeepCopy() *DynamicNamespacePoolStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DynamicNamespacePoolStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DynamicNamespaceSpec) DeepCopyInto(out *DynamicNamespaceSpec) {
 	*out = *in
@@ -95,11 +243,47 @@ func (in *DynamicNamespaceSpec) DeepCopyInto(out *DynamicNamespaceSpec) {
 			(*out)[key] = val.DeepCopy()
 		}
 	}
+	if in.Quotas != nil {
+		in, out := &in.Quotas, &out.Quotas
+		*out = make([]NamedQuota, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.RoleBindingSubjects != nil {
 		in, out := &in.RoleBindingSubjects, &out.RoleBindingSubjects
 		*out = make([]v1beta1.Subject, len(*in))
 		copy(*out, *in)
 	}
+	if in.SleepSchedule != nil {
+		in, out := &in.SleepSchedule, &out.SleepSchedule
+		*out = new(SleepSchedule)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Expiration != nil {
+		in, out := &in.Expiration, &out.Expiration
+		*out = new(ExpirationPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Children != nil {
+		in, out := &in.Children, &out.Children
+		*out = make([]ChildNamespace, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.QuotaAutoscaling != nil {
+		in, out := &in.QuotaAutoscaling, &out.QuotaAutoscaling
+		*out = new(QuotaAutoscaling)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicNamespaceSpec.
@@ -115,6 +299,41 @@ func (in *DynamicNamespaceSpec) DeepCopy() *DynamicNamespaceSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DynamicNamespaceStatus) DeepCopyInto(out *DynamicNamespaceStatus) {
 	*out = *in
+	if in.Children != nil {
+		in, out := &in.Children, &out.Children
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Plan != nil {
+		in, out := &in.Plan, &out.Plan
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.QuotaUsage != nil {
+		in, out := &in.QuotaUsage, &out.QuotaUsage
+		*out = new(QuotaUsage)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.QuotaAutoscaling != nil {
+		in, out := &in.QuotaAutoscaling, &out.QuotaAutoscaling
+		*out = new(QuotaAutoscalingStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExpiresAt != nil {
+		in, out := &in.ExpiresAt, &out.ExpiresAt
+		*out = (*in).DeepCopy()
+	}
+	if in.LastActivity != nil {
+		in, out := &in.LastActivity, &out.LastActivity
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicNamespaceStatus.
@@ -126,3 +345,349 @@ func (in *DynamicNamespaceStatus) DeepCopy() *DynamicNamespaceStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DynamicNamespaceTrigger) DeepCopyInto(out *DynamicNamespaceTrigger) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicNamespaceTrigger.
+func (in *DynamicNamespaceTrigger) DeepCopy() *DynamicNamespaceTrigger {
+	if in == nil {
+		return nil
+	}
+	out := new(DynamicNamespaceTrigger)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DynamicNamespaceTrigger) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DynamicNamespaceTriggerList) DeepCopyInto(out *DynamicNamespaceTriggerList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]DynamicNamespaceTrigger, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicNamespaceTriggerList.
+func (in *DynamicNamespaceTriggerList) DeepCopy() *DynamicNamespaceTriggerList {
+	if in == nil {
+		return nil
+	}
+	out := new(DynamicNamespaceTriggerList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *DynamicNamespaceTriggerList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DynamicNamespaceTriggerSpec) DeepCopyInto(out *DynamicNamespaceTriggerSpec) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]TriggerRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicNamespaceTriggerSpec.
+func (in *DynamicNamespaceTriggerSpec) DeepCopy() *DynamicNamespaceTriggerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DynamicNamespaceTriggerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DynamicNamespaceTriggerStatus) DeepCopyInto(out *DynamicNamespaceTriggerStatus) {
+	*out = *in
+	if in.LastEventTime != nil {
+		in, out := &in.LastEventTime, &out.LastEventTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicNamespaceTriggerStatus.
+func (in *DynamicNamespaceTriggerStatus) DeepCopy() *DynamicNamespaceTriggerStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DynamicNamespaceTriggerStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExpirationPolicy) DeepCopyInto(out *ExpirationPolicy) {
+	*out = *in
+	if in.TTL != nil {
+		in, out := &in.TTL, &out.TTL
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.IdleTimeout != nil {
+		in, out := &in.IdleTimeout, &out.IdleTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.WarningPeriod != nil {
+		in, out := &in.WarningPeriod, &out.WarningPeriod
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExpirationPolicy.
+func (in *ExpirationPolicy) DeepCopy() *ExpirationPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ExpirationPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamedQuota) DeepCopyInto(out *NamedQuota) {
+	*out = *in
+	if in.Hard != nil {
+		in, out := &in.Hard, &out.Hard
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Scopes != nil {
+		in, out := &in.Scopes, &out.Scopes
+		*out = make([]corev1.ResourceQuotaScope, len(*in))
+		copy(*out, *in)
+	}
+	if in.ScopeSelector != nil {
+		in, out := &in.ScopeSelector, &out.ScopeSelector
+		*out = new(corev1.ScopeSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamedQuota.
+func (in *NamedQuota) DeepCopy() *NamedQuota {
+	if in == nil {
+		return nil
+	}
+	out := new(NamedQuota)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PoolSeed) DeepCopyInto(out *PoolSeed) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PoolSeed.
+func (in *PoolSeed) DeepCopy() *PoolSeed {
+	if in == nil {
+		return nil
+	}
+	out := new(PoolSeed)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuotaAutoscaling) DeepCopyInto(out *QuotaAutoscaling) {
+	*out = *in
+	if in.Max != nil {
+		in, out := &in.Max, &out.Max
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Window != nil {
+		in, out := &in.Window, &out.Window
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuotaAutoscaling.
+func (in *QuotaAutoscaling) DeepCopy() *QuotaAutoscaling {
+	if in == nil {
+		return nil
+	}
+	out := new(QuotaAutoscaling)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuotaAutoscalingStatus) DeepCopyInto(out *QuotaAutoscalingStatus) {
+	*out = *in
+	if in.Current != nil {
+		in, out := &in.Current, &out.Current
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.HighSince != nil {
+		in, out := &in.HighSince, &out.HighSince
+		*out = make(map[corev1.ResourceName]metav1.Time, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.LowSince != nil {
+		in, out := &in.LowSince, &out.LowSince
+		*out = make(map[corev1.ResourceName]metav1.Time, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]QuotaScaleEvent, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuotaAutoscalingStatus.
+func (in *QuotaAutoscalingStatus) DeepCopy() *QuotaAutoscalingStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(QuotaAutoscalingStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuotaScaleEvent) DeepCopyInto(out *QuotaScaleEvent) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+	out.From = in.From.DeepCopy()
+	out.To = in.To.DeepCopy()
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuotaScaleEvent.
+func (in *QuotaScaleEvent) DeepCopy() *QuotaScaleEvent {
+	if in == nil {
+		return nil
+	}
+	out := new(QuotaScaleEvent)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuotaUsage) DeepCopyInto(out *QuotaUsage) {
+	*out = *in
+	if in.Hard != nil {
+		in, out := &in.Hard, &out.Hard
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Used != nil {
+		in, out := &in.Used, &out.Used
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuotaUsage.
+func (in *QuotaUsage) DeepCopy() *QuotaUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(QuotaUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretKeyReference) DeepCopyInto(out *SecretKeyReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretKeyReference.
+func (in *SecretKeyReference) DeepCopy() *SecretKeyReference {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretKeyReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SleepSchedule) DeepCopyInto(out *SleepSchedule) {
+	*out = *in
+	if in.Days != nil {
+		in, out := &in.Days, &out.Days
+		*out = make([]Weekday, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SleepSchedule.
+func (in *SleepSchedule) DeepCopy() *SleepSchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(SleepSchedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TriggerRule) DeepCopyInto(out *TriggerRule) {
+	*out = *in
+	in.Template.DeepCopyInto(&out.Template)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TriggerRule.
+func (in *TriggerRule) DeepCopy() *TriggerRule {
+	if in == nil {
+		return nil
+	}
+	out := new(TriggerRule)
+	in.DeepCopyInto(out)
+	return out
+}
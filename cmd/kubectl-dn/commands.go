@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/lists"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/api/rbac/v1beta1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// kubeconfigServiceAccount - ServiceAccount в namespace окружения, для которого выдается kubeconfig
+const kubeconfigServiceAccount = "kubectl-dn"
+
+func runCreate(args []string) error {
+	var flags, opts = newFlagSet("create")
+	var quota = flags.String("quota", "", "Квота через запятую, например cpu=2,memory=2Gi. По умолчанию квота контроллера")
+	var ttl = flags.Duration("ttl", 0, "Время жизни окружения")
+	var idleTimeout = flags.Duration("idle-timeout", 0, "Удалить окружение после простоя")
+	var users = flags.String("users", "", "Пользователи через запятую, получающие роль admin в окружении")
+	var groups = flags.String("groups", "", "Группы через запятую, получающие роль admin в окружении")
+	var pool = flags.String("pool", "", "DynamicNamespacePool, из которого берется namespace")
+	var wait = flags.Duration("wait", 0, "Ждать перехода окружения в ACTIVE не дольше указанного времени. Ожидание прерывается, если окружение отклонено, ждет подтверждения или только планируется")
+	name, err := oneName("create", parse(flags, args))
+	if err != nil {
+		return err
+	}
+	c, namespace, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	var resource = &platformv1.DynamicNamespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: platformv1.DynamicNamespaceSpec{
+			Pool: *pool,
+		},
+	}
+	if *quota != "" {
+		resource.Spec.CreateQuota, err = lists.ResourceList(*quota)
+		if err != nil {
+			return err
+		}
+	}
+	for _, user := range lists.Split(*users) {
+		resource.Spec.RoleBindingSubjects = append(resource.Spec.RoleBindingSubjects, v1beta1.Subject{Kind: v1beta1.UserKind, APIGroup: v1beta1.GroupName, Name: user})
+	}
+	for _, group := range lists.Split(*groups) {
+		resource.Spec.RoleBindingSubjects = append(resource.Spec.RoleBindingSubjects, v1beta1.Subject{Kind: v1beta1.GroupKind, APIGroup: v1beta1.GroupName, Name: group})
+	}
+	if *ttl > 0 || *idleTimeout > 0 {
+		resource.Spec.Expiration = &platformv1.ExpirationPolicy{}
+		if *ttl > 0 {
+			resource.Spec.Expiration.TTL = &metav1.Duration{Duration: *ttl}
+		}
+		if *idleTimeout > 0 {
+			resource.Spec.Expiration.IdleTimeout = &metav1.Duration{Duration: *idleTimeout}
+		}
+	}
+
+	var ctx = context.Background()
+	err = c.Create(ctx, resource)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("dynamicnamespace.platform.cloudnative.space/%v created\n", name)
+
+	if *wait == 0 {
+		return nil
+	}
+	var deadline = time.Now().Add(*wait)
+	for {
+		err = c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, resource)
+		if err != nil {
+			return err
+		}
+		switch resource.Status.Code {
+		case "ACTIVE":
+			fmt.Printf("namespace %v is ready\n", resource.Status.Namespace)
+			return nil
+		case "ERROR", "REJECTED", "AWAITING_APPROVAL", "PLANNED":
+			// Из этих статусов окружение не перейдет в ACTIVE без вмешательства человека
+			return fmt.Errorf("окружение в статусе %v: %v", resource.Status.Code, resource.Status.Message)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("окружение не перешло в ACTIVE за %v", *wait)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func runList(args []string) error {
+	var flags, opts = newFlagSet("list")
+	var allNamespaces = flags.Bool("all-namespaces", false, "Показать окружения во всех namespace")
+	flags.BoolVar(allNamespaces, "A", false, "Сокращение для --all-namespaces")
+	parse(flags, args)
+	c, namespace, err := opts.client()
+	if err != nil {
+		return err
+	}
+
+	var listOptions []client.ListOption
+	if !*allNamespaces {
+		listOptions = append(listOptions, client.InNamespace(namespace))
+	}
+	var resources platformv1.DynamicNamespaceList
+	err = c.List(context.Background(), &resources, listOptions...)
+	if err != nil {
+		return err
+	}
+
+	var w = tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+	if *allNamespaces {
+		fmt.Fprint(w, "NAMESPACE\t")
+	}
+	fmt.Fprintln(w, "NAME\tSTATUS\tTARGET\tEXPIRES\tQUOTA%\tAGE")
+	var now = time.Now()
+	for _, item := range resources.Items {
+		if *allNamespaces {
+			fmt.Fprintf(w, "%v\t", item.Namespace)
+		}
+		var expires = "<none>"
+		if item.Status.ExpiresAt != nil {
+			expires = "in " + duration.HumanDuration(item.Status.ExpiresAt.Sub(now))
+			if item.Status.ExpiresAt.Time.Before(now) {
+				expires = "expired"
+			}
+		}
+		var quota = "<none>"
+		if item.Status.QuotaUsage != nil {
+			quota = fmt.Sprintf("%v%%", item.Status.QuotaUtilization)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n",
+			item.Name,
+			item.Status.Code,
+			item.Status.Namespace,
+			expires,
+			quota,
+			duration.HumanDuration(now.Sub(item.CreationTimestamp.Time)),
+		)
+	}
+	return w.Flush()
+}
+
+func runExtend(args []string) error {
+	var flags, opts = newFlagSet("extend")
+	var by = flags.Duration("by", 24*time.Hour, "На сколько продлить время жизни")
+	name, err := oneName("extend", parse(flags, args))
+	if err != nil {
+		return err
+	}
+	if *by <= 0 {
+		return fmt.Errorf("--by должен быть положительным")
+	}
+
+	return patch(opts, name, func(resource *platformv1.DynamicNamespace) {
+		if resource.Spec.Expiration == nil {
+			resource.Spec.Expiration = &platformv1.ExpirationPolicy{}
+		}
+		// TTL отсчитывается от создания: без TTL окружение продлевается на --by от текущего момента
+		var ttl = time.Since(resource.CreationTimestamp.Time)
+		if resource.Spec.Expiration.TTL != nil {
+			ttl = resource.Spec.Expiration.TTL.Duration
+		}
+		resource.Spec.Expiration.TTL = &metav1.Duration{Duration: (ttl + *by).Round(time.Second)}
+		// Продление сбрасывает и таймер простоя
+		if resource.Spec.Expiration.IdleTimeout != nil {
+			if resource.Annotations == nil {
+				resource.Annotations = map[string]string{}
+			}
+			resource.Annotations[platformv1.GroupVersion.Group+"/last-activity"] = time.Now().UTC().Format(time.RFC3339)
+		}
+	})
+}
+
+func runSuspend(args []string, suspended bool) error {
+	var command = "resume"
+	if suspended {
+		command = "suspend"
+	}
+	var flags, opts = newFlagSet(command)
+	name, err := oneName(command, parse(flags, args))
+	if err != nil {
+		return err
+	}
+	return patch(opts, name, func(resource *platformv1.DynamicNamespace) {
+		resource.Spec.Suspended = suspended
+	})
+}
+
+// runKubeconfig выдает kubeconfig, действующий только в namespace окружения: в нем создается
+// ServiceAccount с RoleBinding на --role, и для него запрашивается токен на --duration
+func runKubeconfig(args []string) error {
+	var flags, opts = newFlagSet("kubeconfig")
+	var role = flags.String("role", "edit", "ClusterRole, которая выдается в namespace окружения")
+	var expiration = flags.Duration("duration", 24*time.Hour, "Время действия токена")
+	name, err := oneName("kubeconfig", parse(flags, args))
+	if err != nil {
+		return err
+	}
+	c, namespace, err := opts.client()
+	if err != nil {
+		return err
+	}
+	var ctx = context.Background()
+	var resource platformv1.DynamicNamespace
+	err = c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &resource)
+	if err != nil {
+		return err
+	}
+	if resource.Status.Namespace == "" {
+		return fmt.Errorf("namespace окружения %v еще не создан", name)
+	}
+	if resource.Status.Code != "ACTIVE" {
+		fmt.Fprintf(os.Stderr, "warning: окружение в статусе %v: %v\n", resource.Status.Code, resource.Status.Message)
+	}
+
+	token, err := environmentToken(ctx, c, opts, resource.Status.Namespace, *role, *expiration)
+	if err != nil {
+		return err
+	}
+
+	// Из текущего контекста берется только адрес кластера и его CA
+	raw, err := opts.clientConfig().RawConfig()
+	if err != nil {
+		return err
+	}
+	if opts.context != "" {
+		raw.CurrentContext = opts.context
+	}
+	err = clientcmdapi.MinifyConfig(&raw)
+	if err != nil {
+		return err
+	}
+	err = clientcmdapi.FlattenConfig(&raw)
+	if err != nil {
+		return err
+	}
+	var config = clientcmdapi.NewConfig()
+	config.Clusters[name] = raw.Clusters[raw.Contexts[raw.CurrentContext].Cluster]
+	config.AuthInfos[name] = &clientcmdapi.AuthInfo{Token: token}
+	config.Contexts[name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name, Namespace: resource.Status.Namespace}
+	config.CurrentContext = name
+
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+func runDelete(args []string) error {
+	var flags, opts = newFlagSet("delete")
+	name, err := oneName("delete", parse(flags, args))
+	if err != nil {
+		return err
+	}
+	c, namespace, err := opts.client()
+	if err != nil {
+		return err
+	}
+	err = c.Delete(context.Background(), &platformv1.DynamicNamespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("dynamicnamespace.platform.cloudnative.space/%v deleted\n", name)
+	return nil
+}
+
+// environmentToken создает в namespace окружения ServiceAccount kubectl-dn с RoleBinding на role
+// и запрашивает для него токен. Выдать можно только роль, которая есть у самого пользователя
+func environmentToken(ctx context.Context, c client.Client, opts *options, namespace, role string, expiration time.Duration) (string, error) {
+	var serviceAccount = &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: kubeconfigServiceAccount, Namespace: namespace}}
+	err := c.Create(ctx, serviceAccount)
+	if err != nil && !kerrors.IsAlreadyExists(err) {
+		return "", err
+	}
+	// roleRef неизменяем, поэтому у каждой роли своя RoleBinding
+	var roleBinding = &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: kubeconfigServiceAccount + "-" + role, Namespace: namespace},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: role},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: kubeconfigServiceAccount, Namespace: namespace}},
+	}
+	err = c.Create(ctx, roleBinding)
+	if err != nil && !kerrors.IsAlreadyExists(err) {
+		return "", err
+	}
+
+	restConfig, err := opts.clientConfig().ClientConfig()
+	if err != nil {
+		return "", err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return "", err
+	}
+	var seconds = int64(expiration.Seconds())
+	request, err := clientset.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, kubeconfigServiceAccount, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &seconds},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+	return request.Status.Token, nil
+}
+
+// patch читает окружение, применяет mutate и отправляет merge patch
+func patch(opts *options, name string, mutate func(resource *platformv1.DynamicNamespace)) error {
+	c, namespace, err := opts.client()
+	if err != nil {
+		return err
+	}
+	var ctx = context.Background()
+	var resource platformv1.DynamicNamespace
+	err = c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &resource)
+	if err != nil {
+		return err
+	}
+	var base = client.MergeFrom(resource.DeepCopy())
+	mutate(&resource)
+	err = c.Patch(ctx, &resource, base)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("dynamicnamespace.platform.cloudnative.space/%v patched\n", name)
+	return nil
+}
@@ -0,0 +1,178 @@
+// kubectl-dn - плагин kubectl для управления динамическими окружениями (DynamicNamespace).
+// Установка: положить бинарный файл kubectl-dn в PATH, после чего он доступен как `kubectl dn`.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// command - подкоманда плагина
+type command struct {
+	usage       string
+	description string
+	run         func(args []string) error
+}
+
+// commands заполняется в init: подкоманды обращаются к нему для вывода справки
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"create": {
+			usage:       "create NAME [--quota cpu=2,memory=2Gi] [--ttl 24h] [--idle-timeout 4h] [--users a,b] [--groups g] [--pool POOL] [--wait 2m]",
+			description: "создать окружение",
+			run:         runCreate,
+		},
+		"list": {
+			usage:       "list [--all-namespaces]",
+			description: "показать окружения со статусом, сроком жизни и использованием квоты",
+			run:         runList,
+		},
+		"extend": {
+			usage:       "extend NAME --by 24h",
+			description: "продлить время жизни окружения",
+			run:         runExtend,
+		},
+		"suspend": {
+			usage:       "suspend NAME",
+			description: "перевести окружение в спящий режим",
+			run:         func(args []string) error { return runSuspend(args, true) },
+		},
+		"resume": {
+			usage:       "resume NAME",
+			description: "разбудить окружение",
+			run:         func(args []string) error { return runSuspend(args, false) },
+		},
+		"kubeconfig": {
+			usage:       "kubeconfig NAME [--role edit] [--duration 24h]",
+			description: "выдать kubeconfig с токеном, действующим только в namespace окружения",
+			run:         runKubeconfig,
+		},
+		"delete": {
+			usage:       "delete NAME",
+			description: "удалить окружение",
+			run:         runDelete,
+		},
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "неизвестная команда %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	err := cmd.run(os.Args[2:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Управление динамическими окружениями DynamicNamespace.\n\nКоманды:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %v\n", name, commands[name].description)
+	}
+	fmt.Fprintln(os.Stderr, "\nОбщие флаги: --kubeconfig, --context, -n/--namespace")
+}
+
+// options - общие флаги подключения к кластеру
+type options struct {
+	kubeconfig string
+	context    string
+	namespace  string
+}
+
+// newFlagSet создает набор флагов подкоманды с общими флагами подключения
+func newFlagSet(name string) (*flag.FlagSet, *options) {
+	var opts options
+	var flags = flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Использование: kubectl dn %v\n\n", commands[name].usage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "Путь к kubeconfig")
+	flags.StringVar(&opts.context, "context", "", "Контекст kubeconfig")
+	flags.StringVar(&opts.namespace, "namespace", "", "Namespace, в котором находятся DynamicNamespace")
+	flags.StringVar(&opts.namespace, "n", "", "Сокращение для --namespace")
+	return flags, &opts
+}
+
+// parse разбирает флаги, допуская их после позиционных аргументов, и возвращает позиционные аргументы
+func parse(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		_ = flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// clientConfig возвращает конфигурацию kubeconfig с учетом флагов
+func (o *options) clientConfig() clientcmd.ClientConfig {
+	var rules = clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	var overrides = &clientcmd.ConfigOverrides{CurrentContext: o.context}
+	overrides.Context.Namespace = o.namespace
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+}
+
+// client создает клиент controller-runtime и определяет namespace из флага или текущего контекста
+func (o *options) client() (client.Client, string, error) {
+	var config = o.clientConfig()
+	namespace, _, err := config.Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+	restConfig, err := config.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var scheme = runtime.NewScheme()
+	err = clientgoscheme.AddToScheme(scheme)
+	if err != nil {
+		return nil, "", err
+	}
+	err = platformv1.AddToScheme(scheme)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, "", err
+	}
+	return c, namespace, nil
+}
+
+// oneName проверяет, что передано ровно одно имя окружения
+func oneName(name string, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("ожидается имя окружения, использование: kubectl dn %v", commands[name].usage)
+	}
+	return args[0], nil
+}
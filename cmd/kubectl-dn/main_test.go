@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		name       string
+		args       []string
+		positional []string
+		namespace  string
+	}{
+		{name: "no arguments"},
+		{name: "flags before name", args: []string{"-n", "team-a", "feature"}, positional: []string{"feature"}, namespace: "team-a"},
+		{name: "flags after name", args: []string{"feature", "--namespace=team-a"}, positional: []string{"feature"}, namespace: "team-a"},
+		{name: "several names", args: []string{"feature", "-n", "team-a", "bugfix"}, positional: []string{"feature", "bugfix"}, namespace: "team-a"},
+		{name: "end of flags", args: []string{"--", "-n"}, positional: []string{"-n"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var flags, opts = newFlagSet("delete")
+			var positional = parse(flags, test.args)
+			if !reflect.DeepEqual(positional, test.positional) {
+				t.Errorf("parse() = %q, ожидалось %q", positional, test.positional)
+			}
+			if opts.namespace != test.namespace {
+				t.Errorf("namespace = %q, ожидалось %q", opts.namespace, test.namespace)
+			}
+		})
+	}
+}
+
+func TestOneName(t *testing.T) {
+	var tests = []struct {
+		args []string
+		ok   bool
+	}{
+		{args: nil},
+		{args: []string{"feature"}, ok: true},
+		{args: []string{"feature", "bugfix"}},
+	}
+	for _, test := range tests {
+		name, err := oneName("delete", test.args)
+		if (err == nil) != test.ok {
+			t.Errorf("oneName(%q) = %q, %v; ожидался успех %v", test.args, name, err, test.ok)
+		}
+	}
+}
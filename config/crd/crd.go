@@ -2,7 +2,18 @@ package crd
 
 import _ "embed"
 
+// Revision - ревизия схем встроенных CRD. Увеличивается при каждом изменении CRD в bases:
+// контроллер не заменяет CRD с большей ревизией, поэтому старые реплики при
+// rolling update не откатывают схему
+const Revision = 8
+
 var (
 	//go:embed bases/platform.cloudnative.space_dynamicnamespaces.yaml
 	DynamicNamespace []byte
+
+	//go:embed bases/platform.cloudnative.space_dynamicnamespacepools.yaml
+	DynamicNamespacePool []byte
+
+	//go:embed bases/platform.cloudnative.space_dynamicnamespacetriggers.yaml
+	DynamicNamespaceTrigger []byte
 )
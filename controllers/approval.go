@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var (
+	// approveAnnotation выставляет подтверждающий; webhook заменяет ее на approvedByAnnotation
+	approveAnnotation = platformv1.GroupVersion.Group + "/approve"
+	// approvedByAnnotation хранит имя подтвердившего пользователя
+	approvedByAnnotation = platformv1.GroupVersion.Group + "/approved-by"
+	// approvedHashAnnotation хранит хэш подтвержденной спецификации: изменение квот или роли
+	// после подтверждения требует нового подтверждения
+	approvedHashAnnotation = platformv1.GroupVersion.Group + "/approved-hash"
+)
+
+const (
+	approvalWebhookPath = "/mutate-platform-cloudnative-space-v1-dynamicnamespace-approval"
+	approvalWebhookName = "approval.dynamicnamespace.platform.cloudnative.space"
+
+	defaultRole = "admin"
+)
+
+// ApprovalPolicy defines which DynamicNamespaces must be approved before provisioning
+type ApprovalPolicy struct {
+	// Квота, превышение которой по любому ресурсу требует подтверждения
+	QuotaThreshold v1.ResourceList
+	// ClusterRole, выдача которых требует подтверждения
+	RestrictedRoles []string
+	// Группы пользователей, которые могут подтверждать запросы
+	ApproverGroups []string
+}
+
+// enabled сообщает, включена ли политика подтверждения
+func (p *ApprovalPolicy) enabled() bool {
+	return len(p.ApproverGroups) > 0 && (len(p.QuotaThreshold) > 0 || len(p.RestrictedRoles) > 0)
+}
+
+// requiresApproval возвращает причину, по которой ресурс требует подтверждения, или nil
+func (p *ApprovalPolicy) requiresApproval(resource *platformv1.DynamicNamespace) *messages.Message {
+	return p.check(resource.Spec.Role, requestedQuota(resource))
+}
+
+// check возвращает причину, по которой роль или квота требуют подтверждения, или nil
+func (p *ApprovalPolicy) check(role string, requested v1.ResourceList) *messages.Message {
+	if !p.enabled() {
+		return nil
+	}
+	for _, restricted := range p.RestrictedRoles {
+		if role == restricted {
+			var reason = messages.New(messages.RestrictedRole, role)
+			return &reason
+		}
+	}
+
+	var names []string
+	for name := range p.QuotaThreshold {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var threshold = p.QuotaThreshold[v1.ResourceName(name)]
+		quantity, ok := requested[v1.ResourceName(name)]
+		if ok && quantity.Cmp(threshold) > 0 {
+			var reason = messages.New(messages.QuotaAboveThreshold, name, quantity.String(), threshold.String())
+			return &reason
+		}
+	}
+	return nil
+}
+
+// approvedBy возвращает подтвердившего пользователя, если подтверждение относится к текущей спецификации
+func approvedBy(resource *platformv1.DynamicNamespace, defaultRole string) string {
+	var annotations = resource.GetAnnotations()
+	if annotations[approvedByAnnotation] == "" || annotations[approvedHashAnnotation] != approvalHash(resource, defaultRole) {
+		return ""
+	}
+	return annotations[approvedByAnnotation]
+}
+
+// requestedQuota суммирует квоту окружения: createQuota (или максимум автомасштабирования) и spec.quotas
+func requestedQuota(resource *platformv1.DynamicNamespace) v1.ResourceList {
+	var total = resource.Spec.CreateQuota.DeepCopy()
+	if total == nil {
+		total = v1.ResourceList{}
+	}
+	if resource.Spec.QuotaAutoscaling != nil {
+		for name, max := range resource.Spec.QuotaAutoscaling.Max {
+			if current, ok := total[name]; !ok || max.Cmp(current) > 0 {
+				total[name] = max.DeepCopy()
+			}
+		}
+	}
+	for _, quota := range resource.Spec.Quotas {
+		for name, quantity := range quota.Hard {
+			var sum = total[name]
+			sum.Add(quantity)
+			total[name] = sum
+		}
+	}
+	return total
+}
+
+// approvalHash вычисляет хэш полей спецификации, на которые распространяется подтверждение.
+// Незаданная роль заменяется ролью по умолчанию из конфигурации: ее смена требует нового подтверждения
+func approvalHash(resource *platformv1.DynamicNamespace, defaultRole string) string {
+	var role = resource.Spec.Role
+	if role == "" {
+		role = defaultRole
+	}
+	data, _ := json.Marshal(struct {
+		Role             string                       `json:"role"`
+		CreateQuota      v1.ResourceList              `json:"createQuota,omitempty"`
+		Quotas           []platformv1.NamedQuota      `json:"quotas,omitempty"`
+		QuotaAutoscaling *platformv1.QuotaAutoscaling `json:"quotaAutoscaling,omitempty"`
+	}{
+		Role:             role,
+		CreateQuota:      resource.Spec.CreateQuota,
+		Quotas:           resource.Spec.Quotas,
+		QuotaAutoscaling: resource.Spec.QuotaAutoscaling,
+	})
+	var sum = sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ApprovalWebhook records who approved a DynamicNamespace: it accepts the approve annotation only from
+// members of the approver groups and replaces it with the approver name and the approved spec hash
+type ApprovalWebhook struct {
+	Policy   *ApprovalPolicy
+	Settings *SettingsStore
+	// Клиент для поиска MutatingWebhookConfiguration до запуска кэша. По умолчанию mgr.GetAPIReader()
+	APIReader client.Reader
+	decoder   *admission.Decoder
+}
+
+// +kubebuilder:webhook:path=/mutate-platform-cloudnative-space-v1-dynamicnamespace-approval,mutating=true,failurePolicy=fail,sideEffects=None,groups=platform.cloudnative.space,resources=dynamicnamespaces,verbs=create;update,versions=v1,name=approval.dynamicnamespace.platform.cloudnative.space,admissionReviewVersions=v1
+
+// +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations,verbs=list
+
+// SetupWithManager registers the webhook whenever its MutatingWebhookConfiguration is installed: with
+// failurePolicy Fail a missing handler would block every DynamicNamespace. Approval is refused without it,
+// since nothing else checks who set the approved-by annotation.
+func (a *ApprovalWebhook) SetupWithManager(mgr ctrl.Manager) error {
+	if a.APIReader == nil {
+		a.APIReader = mgr.GetAPIReader()
+	}
+	installed, err := approvalWebhookInstalled(context.Background(), a.APIReader)
+	if err != nil {
+		return err
+	}
+	if !installed {
+		if a.Policy.enabled() {
+			return fmt.Errorf("подтверждение окружений включено, но webhook %v не зарегистрирован: без него аннотацию %v может выставить любой", approvalWebhookName, approvedByAnnotation)
+		}
+		return nil
+	}
+	mgr.GetWebhookServer().Register(approvalWebhookPath, &webhook.Admission{Handler: a})
+	return nil
+}
+
+// approvalWebhookInstalled ищет webhook подтверждения среди MutatingWebhookConfiguration
+func approvalWebhookInstalled(ctx context.Context, reader client.Reader) (bool, error) {
+	var configurations admissionregistrationv1.MutatingWebhookConfigurationList
+	err := reader.List(ctx, &configurations)
+	if err != nil {
+		return false, err
+	}
+	for _, configuration := range configurations.Items {
+		for _, hook := range configuration.Webhooks {
+			var service = hook.ClientConfig.Service
+			if hook.Name == approvalWebhookName || (service != nil && service.Path != nil && *service.Path == approvalWebhookPath) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// InjectDecoder реализует admission.DecoderInjector
+func (a *ApprovalWebhook) InjectDecoder(decoder *admission.Decoder) error {
+	a.decoder = decoder
+	return nil
+}
+
+// Handle проверяет и проставляет аннотации подтверждения. При выключенной политике пропускает все запросы
+func (a *ApprovalWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if !a.Policy.enabled() {
+		return admission.Allowed("")
+	}
+	var resource platformv1.DynamicNamespace
+	err := a.decoder.Decode(req, &resource)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	var old platformv1.DynamicNamespace
+	if len(req.OldObject.Raw) > 0 {
+		err = a.decoder.DecodeRaw(req.OldObject, &old)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	var settings = a.Settings.Load()
+	var annotations = resource.GetAnnotations()
+	var oldAnnotations = old.GetAnnotations()
+	var approver = a.isApprover(req.UserInfo.Groups)
+
+	if _, ok := annotations[approveAnnotation]; ok {
+		if !approver {
+			return admission.Denied(settings.Language.Text(messages.New(messages.ApproverRequired, req.UserInfo.Username, strings.Join(a.Policy.ApproverGroups, ", "))))
+		}
+		delete(annotations, approveAnnotation)
+		annotations[approvedByAnnotation] = req.UserInfo.Username
+		annotations[approvedHashAnnotation] = approvalHash(&resource, settings.DefaultRole)
+		resource.SetAnnotations(annotations)
+
+		data, err := json.Marshal(&resource)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		return admission.PatchResponseFromRaw(req.Object.Raw, data)
+	}
+
+	// Аннотации подтверждения нельзя подделать в обход approveAnnotation
+	if !approver && (annotations[approvedByAnnotation] != oldAnnotations[approvedByAnnotation] ||
+		annotations[approvedHashAnnotation] != oldAnnotations[approvedHashAnnotation]) {
+		return admission.Denied(settings.Language.Text(messages.New(messages.ApprovalForged, approvedByAnnotation, approvedHashAnnotation, approveAnnotation)))
+	}
+	return admission.Allowed("")
+}
+
+func (a *ApprovalWebhook) isApprover(groups []string) bool {
+	for _, group := range groups {
+		for _, approverGroup := range a.Policy.ApproverGroups {
+			if group == approverGroup {
+				return true
+			}
+		}
+	}
+	return false
+}
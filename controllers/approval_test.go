@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestApprovalHash(t *testing.T) {
+	var base = &platformv1.DynamicNamespace{
+		Spec: platformv1.DynamicNamespaceSpec{
+			CreateQuota: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")},
+		},
+	}
+	var withRole = func(role string) *platformv1.DynamicNamespace {
+		var resource = base.DeepCopy()
+		resource.Spec.Role = role
+		return resource
+	}
+	var withQuota = base.DeepCopy()
+	withQuota.Spec.CreateQuota[v1.ResourceCPU] = resource.MustParse("8")
+	var withNetwork = base.DeepCopy()
+	withNetwork.Spec.IsolateNetwork = true
+
+	var tests = []struct {
+		name        string
+		resource    *platformv1.DynamicNamespace
+		defaultRole string
+		same        bool
+	}{
+		{name: "empty role is the default role", resource: withRole("edit"), defaultRole: "edit", same: true},
+		{name: "default role changed", resource: base, defaultRole: "view", same: false},
+		{name: "role changed", resource: withRole("cluster-admin"), defaultRole: "edit", same: false},
+		{name: "quota changed", resource: withQuota, defaultRole: "edit", same: false},
+		{name: "other fields are not approved", resource: withNetwork, defaultRole: "edit", same: true},
+	}
+	var approved = approvalHash(base, "edit")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got = approvalHash(test.resource, test.defaultRole)
+			if (got == approved) != test.same {
+				t.Errorf("approvalHash() = %v, подтвержден %v; ожидалось совпадение %v", got, approved, test.same)
+			}
+		})
+	}
+}
+
+func TestCheckRole(t *testing.T) {
+	var tests = []struct {
+		name  string
+		role  string
+		extra []string
+		ok    bool
+	}{
+		{name: "allowed", role: "edit", ok: true},
+		{name: "not allowed", role: "cluster-admin"},
+		{name: "restricted with approval", role: "cluster-admin", extra: []string{"cluster-admin"}, ok: true},
+	}
+	var settings = defaultSettings()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := settings.checkRole(test.role, test.extra)
+			if test.ok {
+				if err != nil {
+					t.Errorf("checkRole() = %v, ожидалось без ошибки", err)
+				}
+				return
+			}
+			var coded *messages.Error
+			if !errors.As(err, &coded) || coded.Reason != messages.RoleNotAllowed {
+				t.Errorf("ошибка %v, ожидался код %v", err, messages.RoleNotAllowed)
+			}
+		})
+	}
+}
+
+func TestApprovalWebhookHandle(t *testing.T) {
+	var policy = &ApprovalPolicy{RestrictedRoles: []string{"cluster-admin"}, ApproverGroups: []string{"platform-admins"}}
+	var approver = authenticationv1.UserInfo{Username: "lead", Groups: []string{"platform-admins"}}
+	var developer = authenticationv1.UserInfo{Username: "dev", Groups: []string{"developers"}}
+	var tests = []struct {
+		name        string
+		policy      *ApprovalPolicy
+		user        authenticationv1.UserInfo
+		annotations map[string]string
+		old         map[string]string
+		allowed     bool
+		patched     bool
+		// Начало текста отказа
+		denial string
+	}{
+		{name: "policy off", policy: &ApprovalPolicy{}, user: developer,
+			annotations: map[string]string{approvedByAnnotation: "dev"}, allowed: true},
+		{name: "approver approves", policy: policy, user: approver,
+			annotations: map[string]string{approveAnnotation: ""}, allowed: true, patched: true},
+		{name: "developer approves", policy: policy, user: developer,
+			annotations: map[string]string{approveAnnotation: ""}, denial: "user dev is not in the groups that approve environments"},
+		{name: "developer forges approved-by", policy: policy, user: developer,
+			annotations: map[string]string{approvedByAnnotation: "lead"}, denial: "annotations platform.cloudnative.space/approved-by and"},
+		{name: "developer keeps approval", policy: policy, user: developer,
+			annotations: map[string]string{approvedByAnnotation: "lead", approvedHashAnnotation: "abc"},
+			old:         map[string]string{approvedByAnnotation: "lead", approvedHashAnnotation: "abc"}, allowed: true},
+		{name: "developer without annotations", policy: policy, user: developer, allowed: true},
+	}
+	decoder, err := admission.NewDecoder(testScheme(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var settings = &SettingsStore{}
+	settings.Store(defaultSettings())
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var hook = &ApprovalWebhook{Policy: test.policy, Settings: settings}
+			if err := hook.InjectDecoder(decoder); err != nil {
+				t.Fatal(err)
+			}
+			var request = admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Update,
+				UserInfo:  test.user,
+				Object:    runtime.RawExtension{Raw: dynamicNamespaceJSON(t, test.annotations)},
+				OldObject: runtime.RawExtension{Raw: dynamicNamespaceJSON(t, test.old)},
+			}}
+			var response = hook.Handle(context.Background(), request)
+			if response.Allowed != test.allowed {
+				t.Fatalf("Allowed = %v, ожидалось %v: %v", response.Allowed, test.allowed, response.Result)
+			}
+			if !response.Allowed && !strings.HasPrefix(string(response.Result.Reason), test.denial) {
+				t.Errorf("отказ %q, ожидалось %q", response.Result.Reason, test.denial)
+			}
+			if (len(response.Patches) > 0) != test.patched {
+				t.Errorf("патчи %v, ожидался патч %v", response.Patches, test.patched)
+			}
+		})
+	}
+}
+
+func dynamicNamespaceJSON(t *testing.T, annotations map[string]string) []byte {
+	data, err := json.Marshal(&platformv1.DynamicNamespace{
+		TypeMeta:   metav1.TypeMeta{APIVersion: platformv1.GroupVersion.String(), Kind: "DynamicNamespace"},
+		ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "team-a", Annotations: annotations},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestApprovalWebhookInstalled(t *testing.T) {
+	var path = approvalWebhookPath
+	var tests = []struct {
+		name    string
+		objects []*admissionregistrationv1.MutatingWebhookConfiguration
+		want    bool
+	}{
+		{name: "not installed"},
+		{name: "other webhook", objects: []*admissionregistrationv1.MutatingWebhookConfiguration{{
+			ObjectMeta: metav1.ObjectMeta{Name: "other"},
+			Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "other.example.com"}},
+		}}},
+		{name: "installed by path", want: true, objects: []*admissionregistrationv1.MutatingWebhookConfiguration{{
+			ObjectMeta: metav1.ObjectMeta{Name: "dn-mutating-webhook-configuration"},
+			Webhooks: []admissionregistrationv1.MutatingWebhook{{
+				Name:         "renamed.example.com",
+				ClientConfig: admissionregistrationv1.WebhookClientConfig{Service: &admissionregistrationv1.ServiceReference{Path: &path}},
+			}},
+		}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var builder = fake.NewClientBuilder().WithScheme(testScheme(t))
+			for _, object := range test.objects {
+				builder = builder.WithObjects(object)
+			}
+			got, err := approvalWebhookInstalled(context.Background(), builder.Build())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("approvalWebhookInstalled() = %v, ожидалось %v", got, test.want)
+			}
+		})
+	}
+}
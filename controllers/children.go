@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var (
+	// parentLabelKey помечает дочерний namespace именем целевого namespace родителя
+	parentLabelKey = platformv1.GroupVersion.Group + "/parent"
+)
+
+// namespaceNameLabelKey выставляется Kubernetes (1.21+) на каждый namespace
+const namespaceNameLabelKey = "kubernetes.io/metadata.name"
+
+func childNamespaceName(resource *platformv1.DynamicNamespace, child *platformv1.ChildNamespace) string {
+	return fmt.Sprintf("%s-%s", targetNamespace(resource), child.Name)
+}
+
+// familyNamespaces возвращает целевой namespace и все дочерние
+func familyNamespaces(resource *platformv1.DynamicNamespace) []string {
+	var names = []string{targetNamespace(resource)}
+	for i := range resource.Spec.Children {
+		names = append(names, childNamespaceName(resource, &resource.Spec.Children[i]))
+	}
+	return names
+}
+
+// reconcileChildren применяет дочерние namespace с их квотами, RoleBinding и сетевыми правилами,
+// удаляет исключенные из спецификации и возвращает имена дочерних namespace
+func (r *DynamicNamespaceReconciler) reconcileChildren(ctx context.Context, resource *platformv1.DynamicNamespace) ([]string, error) {
+	var names []string
+	var desired = map[string]bool{}
+	for i := range resource.Spec.Children {
+		var child = &resource.Spec.Children[i]
+
+		namespace, err := generateChildNamespace(resource, child)
+		if err != nil {
+			return names, err
+		}
+		quota, err := generateChildResourceQuota(resource, child)
+		if err != nil {
+			return names, err
+		}
+		roleBinding, err := generateRoleBinding(resource)
+		if err != nil {
+			return names, err
+		}
+		roleBinding.Namespace = namespace.Name
+		err = r.applyNamespace(ctx, resource, namespace)
+		if err != nil {
+			return names, err
+		}
+		err = r.apply(ctx, quota)
+		if err != nil {
+			return names, err
+		}
+		err = applyRoleBinding(ctx, r.Client, roleBinding, fieldManager)
+		if err != nil {
+			return names, err
+		}
+		err = r.createOrUpdateNetworkPolicy(ctx, resource, namespace.Name)
+		if err != nil {
+			return names, err
+		}
+
+		ctrllog.FromContext(ctx).Info("Дочерний Namespace применен", "namespace", namespace.Name)
+		names = append(names, namespace.Name)
+		desired[namespace.Name] = true
+	}
+
+	// Удаление дочерних namespace, исключенных из спецификации
+	existing, err := r.listChildren(ctx, resource)
+	if err != nil {
+		return names, err
+	}
+	for i := range existing {
+		if desired[existing[i].Name] {
+			continue
+		}
+		err = r.Delete(ctx, &existing[i])
+		if err != nil && !kerrors.IsNotFound(err) {
+			return names, err
+		}
+		ctrllog.FromContext(ctx).Info("Удален дочерний Namespace", "namespace", existing[i].Name)
+	}
+	return names, nil
+}
+
+// listChildren возвращает существующие дочерние namespace ресурса
+func (r *DynamicNamespaceReconciler) listChildren(ctx context.Context, resource *platformv1.DynamicNamespace) ([]v1.Namespace, error) {
+	var namespaces v1.NamespaceList
+	err := r.List(ctx, &namespaces, client.MatchingLabels{
+		defaultLabelKey: ownerLabelValue(resource),
+		parentLabelKey:  targetNamespace(resource),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var children []v1.Namespace
+	for _, namespace := range namespaces.Items {
+		if namespace.GetDeletionTimestamp() == nil {
+			children = append(children, namespace)
+		}
+	}
+	return children, nil
+}
+
+// deleteChildren удаляет все дочерние namespace ресурса при его финализации
+func (r *DynamicNamespaceReconciler) deleteChildren(ctx context.Context, resource *platformv1.DynamicNamespace) error {
+	children, err := r.listChildren(ctx, resource)
+	if err != nil {
+		return err
+	}
+	for i := range children {
+		err = r.Delete(ctx, &children[i])
+		if err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+		ctrllog.FromContext(ctx).Info("Успешно удален дочерний Namespace", "namespace", children[i].Name)
+	}
+	return nil
+}
+
+// createOrUpdateNetworkPolicy изолирует namespace окружения или снимает изоляцию, если она выключена
+func (r *DynamicNamespaceReconciler) createOrUpdateNetworkPolicy(ctx context.Context, resource *platformv1.DynamicNamespace, namespace string) error {
+	desiredNetworkPolicy, err := generateNetworkPolicy(resource, namespace)
+	if err != nil {
+		return err
+	}
+
+	if !resource.Spec.IsolateNetwork {
+		var current networkingv1.NetworkPolicy
+		err = r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: desiredNetworkPolicy.Name}, &current)
+		if err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if current.GetLabels()[defaultLabelKey] != ownerLabelValue(resource) {
+			return nil
+		}
+		return client.IgnoreNotFound(r.Delete(ctx, &current))
+	}
+	return r.apply(ctx, desiredNetworkPolicy)
+}
+
+// validateChildren проверяет имена дочерних namespace и то, что их квоты помещаются в квоту родителя
+func validateChildren(resource *platformv1.DynamicNamespace) error {
+	var names = map[string]bool{}
+	for i := range resource.Spec.Children {
+		var child = &resource.Spec.Children[i]
+		if names[child.Name] {
+			return messages.Errorf(messages.ChildDuplicated, child.Name)
+		}
+		names[child.Name] = true
+
+		// Имя <целевой namespace>-<name> может не поместиться в 63 символа
+		var name = childNamespaceName(resource, child)
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+			return messages.Errorf(messages.ChildNamespaceNameInvalid, name, strings.Join(errs, "; "))
+		}
+	}
+	_, err := parentQuota(resource)
+	return err
+}
+
+// parentQuota возвращает квоту целевого namespace: createQuota за вычетом квот дочерних namespace
+func parentQuota(resource *platformv1.DynamicNamespace) (v1.ResourceList, error) {
+	if len(resource.Spec.Children) == 0 {
+		return resource.Spec.CreateQuota, nil
+	}
+
+	var remaining = resource.Spec.CreateQuota.DeepCopy()
+	for _, child := range resource.Spec.Children {
+		for name, quantity := range child.Quota {
+			left, ok := remaining[name]
+			if !ok {
+				return nil, messages.Errorf(messages.ChildResourceNotInParent, name, child.Name)
+			}
+			left.Sub(quantity)
+			if left.Sign() < 0 {
+				var limit = resource.Spec.CreateQuota[name]
+				return nil, messages.Errorf(messages.ChildQuotaExceedsParent, name, limit.String())
+			}
+			remaining[name] = left
+		}
+	}
+	return remaining, nil
+}
+
+func generateChildNamespace(resource *platformv1.DynamicNamespace, child *platformv1.ChildNamespace) (*v1.Namespace, error) {
+	namespace, err := generateNamespace(resource)
+	if err != nil {
+		return nil, err
+	}
+	namespace.Name = childNamespaceName(resource, child)
+	namespace.Labels[parentLabelKey] = targetNamespace(resource)
+	return namespace, nil
+}
+
+func generateChildResourceQuota(resource *platformv1.DynamicNamespace, child *platformv1.ChildNamespace) (*v1.ResourceQuota, error) {
+	return &v1.ResourceQuota{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       "ResourceQuota",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-resourcequota", resource.Name),
+			Namespace: childNamespaceName(resource, child),
+			Labels:    ownerLabels(resource),
+		},
+		Spec: v1.ResourceQuotaSpec{
+			Hard: child.Quota,
+		},
+	}, nil
+}
+
+// generateNetworkPolicy разрешает входящий трафик только из целевого namespace и его дочерних namespace
+func generateNetworkPolicy(resource *platformv1.DynamicNamespace, namespace string) (*networkingv1.NetworkPolicy, error) {
+	return &networkingv1.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: networkingv1.SchemeGroupVersion.String(),
+			Kind:       "NetworkPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-isolation", resource.Name),
+			Namespace: namespace,
+			Labels:    ownerLabels(resource),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From: []networkingv1.NetworkPolicyPeer{
+					{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabelKey: targetNamespace(resource)}}},
+					{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{parentLabelKey: targetNamespace(resource)}}},
+				},
+			}},
+		},
+	}, nil
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateChildren(t *testing.T) {
+	var quota = func(cpu, memory string) v1.ResourceList {
+		var list = v1.ResourceList{}
+		if cpu != "" {
+			list[v1.ResourceCPU] = resource.MustParse(cpu)
+		}
+		if memory != "" {
+			list[v1.ResourceMemory] = resource.MustParse(memory)
+		}
+		return list
+	}
+
+	var tests = []struct {
+		name     string
+		target   string
+		children []platformv1.ChildNamespace
+		parent   v1.ResourceList
+		reason   messages.Reason
+	}{
+		{
+			name:   "без дочерних namespace квота не меняется",
+			parent: quota("4", "8Gi"),
+		},
+		{
+			name:     "квоты дочерних namespace вычитаются из родительской",
+			children: []platformv1.ChildNamespace{{Name: "db", Quota: quota("1", "2Gi")}, {Name: "cache", Quota: quota("1", "")}},
+			parent:   quota("2", "6Gi"),
+		},
+		{
+			name:     "дочерний namespace указан дважды",
+			children: []platformv1.ChildNamespace{{Name: "db"}, {Name: "db"}},
+			reason:   messages.ChildDuplicated,
+		},
+		{
+			name:     "ресурса нет в квоте родителя",
+			children: []platformv1.ChildNamespace{{Name: "db", Quota: v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")}}},
+			reason:   messages.ChildResourceNotInParent,
+		},
+		{
+			name:     "сумма квот дочерних namespace больше родительской",
+			children: []platformv1.ChildNamespace{{Name: "db", Quota: quota("3", "")}, {Name: "cache", Quota: quota("2", "")}},
+			reason:   messages.ChildQuotaExceedsParent,
+		},
+		{
+			name:     "имя дочернего namespace длиннее 63 символов",
+			target:   strings.Repeat("a", 61),
+			children: []platformv1.ChildNamespace{{Name: "db"}},
+			reason:   messages.ChildNamespaceNameInvalid,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var resource = &platformv1.DynamicNamespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "team-a"},
+				Spec:       platformv1.DynamicNamespaceSpec{CreateQuota: quota("4", "8Gi"), Children: test.children},
+				Status:     platformv1.DynamicNamespaceStatus{Namespace: test.target},
+			}
+			err := validateChildren(resource)
+			if test.reason != "" {
+				var coded *messages.Error
+				if !errors.As(err, &coded) || coded.Reason != test.reason {
+					t.Fatalf("ошибка %v, ожидался код %v", err, test.reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parent, err := parentQuota(resource)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parent) != len(test.parent) {
+				t.Fatalf("parentQuota = %v, ожидалось %v", parent, test.parent)
+			}
+			for name, want := range test.parent {
+				if got := parent[name]; got.Cmp(want) != 0 {
+					t.Errorf("%v = %v, ожидалось %v", name, got.String(), want.String())
+				}
+			}
+		})
+	}
+}
@@ -2,24 +2,37 @@ package controllers
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"reflect"
+	"time"
 
 	"k8s.io/api/core/v1"
-	"k8s.io/api/rbac/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 
-	"github.com/sirupsen/logrus"
 	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
 	"github.com/wbe7/dynamicnamespace/config/crd"
+	"github.com/wbe7/dynamicnamespace/internal/health"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"github.com/wbe7/dynamicnamespace/internal/notifier"
 	"github.com/wbe7/dynamicnamespace/internal/platform"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 var (
@@ -27,22 +40,52 @@ var (
 	defaultLabelKey  = platformv1.GroupVersion.Group + "/created-by"
 )
 
+// fieldManager - имя менеджера полей, от которого контроллер применяет объекты через server-side apply
+const fieldManager = "dynamicnamespace-controller"
+
 // DynamicNamespaceReconciler reconciles a DynamicNamespace object
 type DynamicNamespaceReconciler struct {
 	client.Client
 	*platform.PlatformClient
-	log    *logrus.Entry
-	Scheme *runtime.Scheme
+	// APIReader читает напрямую из API-сервера объекты, которые не нужно держать в кэше
+	APIReader client.Reader
+	Recorder  record.EventRecorder
+	// Порог использования квоты в процентах, после которого выставляется условие QuotaPressure
+	QuotaWarningThreshold int32
+	// Notifier отправляет уведомления о жизненном цикле окружений; nil отключает уведомления
+	Notifier *notifier.Notifier
+	// Политика подтверждения окружений с большой квотой или привилегированной ролью
+	Approval ApprovalPolicy
+	// Установка CRD и ожидание его готовности
+	CRDs *platform.CRDInstaller
+	// Watchdog отслеживает зависшие циклы обработки для liveness-проверки
+	Watchdog *health.Watchdog
+	// Настройки из файла конфигурации, которые меняются без перезапуска
+	Settings *SettingsStore
+	// Режим dry-run для всех ресурсов: изменения записываются в status.plan, но не применяются
+	DryRun bool
+	// Число параллельных обработчиков; 0 - controller.groupKindConcurrency из конфигурации или 1
+	MaxConcurrentReconciles int
+	// Ограничитель очереди; nil - ограничитель controller-runtime по умолчанию
+	RateLimiter workqueue.RateLimiter
+	Scheme      *runtime.Scheme
 }
 
 // +kubebuilder:rbac:groups=platform.cloudnative.space,resources=dynamicnamespaces,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;create;update
 // +kubebuilder:rbac:groups=platform.cloudnative.space,resources=dynamicnamespaces/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=platform.cloudnative.space,resources=dynamicnamespaces/finalizers,verbs=update
+// +kubebuilder:rbac:groups=platform.cloudnative.space,resources=dynamicnamespacepools,verbs=get;list;watch
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles,verbs=bind
 // +kubebuilder:rbac:groups=core,resources=resourcequotas,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=namespaces/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;list;patch
+// +kubebuilder:rbac:groups=core,resources=pods;services,verbs=list
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=list
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -54,103 +97,361 @@ type DynamicNamespaceReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.10.0/pkg/reconcile
 func (r *DynamicNamespaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	var log = r.log.WithField("dynamicnamespace", req.NamespacedName)
-	log.Infof("> Начало обработки ресурса: %v", req.NamespacedName)
+	var log = ctrllog.FromContext(ctx).WithValues("dynamicnamespace", req.NamespacedName.String())
+	ctx = ctrllog.IntoContext(ctx, log)
+	log.Info("> Начало обработки ресурса")
+	defer r.Watchdog.Begin()()
+	var settings = r.Settings.Load()
 
 	// Получение данных ресурса из k8s
 	var desiredResource platformv1.DynamicNamespace
 	var err = r.Get(ctx, req.NamespacedName, &desiredResource)
 	if err != nil {
 		if kerrors.IsNotFound(err) {
-			log.Debug("Ресурс был удален ранее")
+			log.V(1).Info("Ресурс был удален ранее")
 			return ctrl.Result{}, nil
 		}
-		log.Errorf("< Ошибка при чтении CR DynamicNamespace: %v", err)
+		log.Error(err, "< Ошибка при чтении CR DynamicNamespace")
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
 	// Проверка удаления
 	var deleted = desiredResource.GetDeletionTimestamp() != nil
+	if deleted && r.dryRun(&desiredResource) {
+		// В режиме dry-run финализатор не снимается: удаление продолжится после выключения режима
+		plan, err := r.planFinalization(ctx, &desiredResource)
+		if err != nil {
+			log.Error(err, "Ошибка при планировании финализации ресурса")
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+		r.reportPlan(ctx, &desiredResource, plan)
+		return ctrl.Result{}, nil
+	}
 	if deleted {
-		err = r.processDefaultFinalization(log, ctx, &desiredResource, r.finalize)
+		err = r.processDefaultFinalization(ctx, &desiredResource, r.finalize)
 		if err != nil {
-			log.Errorf("Ошибка при финализации ресурса %v: %v", desiredResource.GetName(), err)
-			r.updateStatus(log, ctx, &desiredResource, &platformv1.DynamicNamespaceStatus{Code: "ERROR", Message: err.Error()})
+			log.Error(err, "Ошибка при финализации ресурса")
+			r.updateStatus(ctx, &desiredResource, withError(&desiredResource, settings.Language, err))
 			return ctrl.Result{}, client.IgnoreNotFound(err)
 		}
 		return ctrl.Result{}, nil
 	}
 
+	// DynamicNamespace из namespace, не разрешенных конфигурацией, отклоняются без создания ресурсов
+	rejection, err := sourceRejection(ctx, r.APIReader, desiredResource.GetNamespace(), settings)
+	if err != nil {
+		log.Error(err, "Ошибка при проверке namespace ресурса")
+		r.updateStatus(ctx, &desiredResource, withError(&desiredResource, settings.Language, err))
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if rejection != nil {
+		log.Info("< Ресурс отклонен", "reason", rejection.String())
+		var message = settings.Language.Text(messages.New(messages.Rejected, *rejection))
+		if desiredResource.Status.Code != "REJECTED" {
+			r.Recorder.Event(&desiredResource, v1.EventTypeWarning, "Rejected", message)
+		}
+		r.updateStatus(ctx, &desiredResource, withCode(&desiredResource, "REJECTED", rejection.Reason, message))
+		return ctrl.Result{RequeueAfter: rejectedRequeue}, nil
+	}
+
 	// Добавление финализатора в ресурс
 	if !r.hasDefaultFinalizer(&desiredResource) {
 		err = r.InjectDefaultFinalizer(ctx, &desiredResource)
 		if err != nil {
-			log.Errorf("Ошибка при добавлении финализатора в ресурс %v: %v", desiredResource.GetName(), err)
-			r.updateStatus(log, ctx, &desiredResource, &platformv1.DynamicNamespaceStatus{Code: "ERROR", Message: err.Error()})
+			log.Error(err, "Ошибка при добавлении финализатора в ресурс")
+			r.updateStatus(ctx, &desiredResource, withError(&desiredResource, settings.Language, err))
 			return ctrl.Result{}, client.IgnoreNotFound(err)
 		}
 		return ctrl.Result{}, nil
 	}
 
+	// Значения по умолчанию из конфигурации для незаданных полей. Подтверждение проверяется
+	// до их подстановки: webhook считает хэш по спецификации из API-сервера
+	var approver = approvedBy(&desiredResource, settings.DefaultRole)
+	applyDefaults(&desiredResource, settings)
+
+	var dryRun = r.dryRun(&desiredResource)
+
+	// Получение заранее подготовленного namespace из пула
+	if desiredResource.Spec.Pool != "" && desiredResource.Status.Namespace == "" && !dryRun {
+		err = r.claimFromPool(ctx, &desiredResource)
+		if err != nil {
+			log.Error(err, "Ошибка при получении namespace из пула для ресурса")
+			r.updateStatus(ctx, &desiredResource, withError(&desiredResource, settings.Language, err))
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+	}
+
+	// Назначение имени целевого namespace по шаблону из конфигурации, в том числе когда пул пуст.
+	// В режиме dry-run namespace из пула не берется, и имя не назначается, чтобы его можно было взять позже
+	if desiredResource.Status.Namespace == "" && (desiredResource.Spec.Pool == "" || !dryRun) {
+		err = r.assignNamespace(ctx, &desiredResource, settings)
+		if err != nil {
+			log.Error(err, "Ошибка при назначении имени namespace ресурса")
+			r.updateStatus(ctx, &desiredResource, withError(&desiredResource, settings.Language, err))
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+	}
+
 	// Прикладная валидация ресурса
-	err = r.validate(&desiredResource)
+	err = r.validate(ctx, &desiredResource, settings)
 	if err != nil {
-		log.Errorf("Ошибка при валидации ресурса %v: %v", desiredResource.GetName(), err)
-		r.updateStatus(log, ctx, &desiredResource, &platformv1.DynamicNamespaceStatus{Code: "ERROR", Message: err.Error()})
+		log.Error(err, "Ошибка при валидации ресурса")
+		r.updateStatus(ctx, &desiredResource, withError(&desiredResource, settings.Language, err))
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// Окружения сверх порога политики ждут подтверждения и не создаются
+	if reason := r.Approval.requiresApproval(&desiredResource); reason != nil && approver == "" {
+		log.Info("Ресурс ожидает подтверждения", "reason", reason.String())
+		var message = settings.Language.Text(messages.New(messages.AwaitingApproval, *reason))
+		if desiredResource.Status.Code != "AWAITING_APPROVAL" {
+			r.Recorder.Event(&desiredResource, v1.EventTypeWarning, "AwaitingApproval", message)
+		}
+		var status = withCode(&desiredResource, "AWAITING_APPROVAL", reason.Reason, message)
+		status.ApprovedBy = ""
+		r.updateStatus(ctx, &desiredResource, status)
+		return ctrl.Result{}, nil
+	}
+
+	// В режиме dry-run изменения только планируются
+	if dryRun {
+		plan, err := r.planChanges(ctx, &desiredResource, time.Now())
+		if err != nil {
+			log.Error(err, "Ошибка при планировании изменений ресурса")
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+		r.reportPlan(ctx, &desiredResource, plan)
+		return ctrl.Result{}, nil
+	}
+
 	err = r.createOrUpdateNamespace(ctx, &desiredResource)
 	if err != nil {
-		log.Errorf("Ошибка при создании ресурса %v: %v", desiredResource.GetName(), err)
-		r.updateStatus(log, ctx, &desiredResource, &platformv1.DynamicNamespaceStatus{Code: "ERROR", Message: err.Error()})
+		log.Error(err, "Ошибка при создании ресурса")
+		r.updateStatus(ctx, &desiredResource, withError(&desiredResource, settings.Language, err))
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
 	err = r.createOrUpdateResourceQuota(ctx, &desiredResource)
 	if err != nil {
-		log.Errorf("Ошибка при создании ресурса %v: %v", desiredResource.GetName(), err)
-		r.updateStatus(log, ctx, &desiredResource, &platformv1.DynamicNamespaceStatus{Code: "ERROR", Message: err.Error()})
+		log.Error(err, "Ошибка при создании ресурса")
+		r.updateStatus(ctx, &desiredResource, withError(&desiredResource, settings.Language, err))
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
 	err = r.createOrUpdateRoleBinding(ctx, &desiredResource)
 	if err != nil {
-		log.Errorf("Ошибка при создании ресурса %v: %v", desiredResource.GetName(), err)
-		r.updateStatus(log, ctx, &desiredResource, &platformv1.DynamicNamespaceStatus{Code: "ERROR", Message: err.Error()})
+		log.Error(err, "Ошибка при создании ресурса")
+		r.updateStatus(ctx, &desiredResource, withError(&desiredResource, settings.Language, err))
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	err = r.createOrUpdateNetworkPolicy(ctx, &desiredResource, targetNamespace(&desiredResource))
+	if err != nil {
+		log.Error(err, "Ошибка при создании ресурса")
+		r.updateStatus(ctx, &desiredResource, withError(&desiredResource, settings.Language, err))
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	children, err := r.reconcileChildren(ctx, &desiredResource)
+	if err != nil {
+		log.Error(err, "Ошибка при создании дочерних namespace ресурса")
+		r.updateStatus(ctx, &desiredResource, withError(&desiredResource, settings.Language, err))
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	suspended, requeueAfter, err := r.reconcileHibernation(ctx, &desiredResource)
+	if err != nil {
+		log.Error(err, "Ошибка при переключении спящего режима ресурса")
+		r.updateStatus(ctx, &desiredResource, withError(&desiredResource, settings.Language, err))
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	var now = time.Now()
+	expiration, err := r.evaluateExpiration(ctx, &desiredResource, now)
+	if err != nil {
+		log.Error(err, "Ошибка при вычислении срока жизни ресурса")
+		r.updateStatus(ctx, &desiredResource, withError(&desiredResource, settings.Language, err))
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	r.updateStatus(log, ctx, &desiredResource, &platformv1.DynamicNamespaceStatus{Code: "ACTIVE", Message: "Все хорошо"})
+	usage, err := r.quotaUsage(ctx, &desiredResource)
+	if err != nil {
+		log.Error(err, "Ошибка при чтении использования квоты ресурса")
+		r.updateStatus(ctx, &desiredResource, withError(&desiredResource, settings.Language, err))
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	scaleRequeue, err := r.reconcileQuotaAutoscaling(ctx, &desiredResource, usage, now)
+	if err != nil {
+		log.Error(err, "Ошибка при автомасштабировании квоты ресурса")
+		r.updateStatus(ctx, &desiredResource, withError(&desiredResource, settings.Language, err))
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	requeueAfter = minRequeue(requeueAfter, scaleRequeue)
 
-	// Выход из цикла
-	return ctrl.Result{}, nil
+	if expiration != nil && expiration.expired(now) {
+		log.Info("Срок жизни ресурса истек, удаляю", "reason", expiration.reason)
+		r.Recorder.Event(&desiredResource, v1.EventTypeNormal, "Expired", settings.Language.Text(messages.New(messages.Expired, expiration.reason)))
+		err = r.Delete(ctx, &desiredResource)
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	var status *platformv1.DynamicNamespaceStatus
+	if suspended {
+		status = withCode(&desiredResource, "SUSPENDED", messages.Suspended, settings.Language.Text(messages.New(messages.Suspended)))
+	} else {
+		status = withCode(&desiredResource, "ACTIVE", messages.Ready, settings.Language.Text(messages.New(messages.Ready)))
+	}
+	status.Namespace = targetNamespace(&desiredResource)
+	status.ApprovedBy = approver
+	status.Children = children
+	clearPlan(status)
+	setExpirationStatus(status, expiration, now, settings.Language)
+	if expiration != nil && expiration.warning(now) && !meta.IsStatusConditionTrue(desiredResource.Status.Conditions, conditionExpiring) {
+		var message = settings.Language.Text(messages.New(messages.ExpiringSoon, expiration.expiresAt.Format(time.RFC3339), expiration.reason))
+		r.Recorder.Event(&desiredResource, v1.EventTypeWarning, "ExpiringSoon", message)
+		r.notify(&desiredResource, notifier.EventExpiringSoon, message)
+	}
+	if setQuotaUsageStatus(status, usage, r.QuotaWarningThreshold, settings.Language) {
+		r.Recorder.Event(&desiredResource, v1.EventTypeWarning, "QuotaPressure", meta.FindStatusCondition(status.Conditions, conditionQuotaPressure).Message)
+	}
+	r.updateStatus(ctx, &desiredResource, status)
+
+	// Выход из цикла; ждем ближайшего перехода по расписанию сна, сроку жизни или окну автомасштабирования квоты
+	if expiration != nil {
+		requeueAfter = minRequeue(requeueAfter, expiration.requeueAfter(now))
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// minRequeue возвращает меньший из двух ненулевых интервалов
+func minRequeue(a, b time.Duration) time.Duration {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *DynamicNamespaceReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	r.log = logrus.WithField("controller", "dynamicnamespace")
 	r.PlatformClient = platform.NewPlatformClient(mgr.GetConfig(), r.Client)
+	if r.APIReader == nil {
+		r.APIReader = mgr.GetAPIReader()
+	}
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor(fieldManager)
+	}
+	if r.QuotaWarningThreshold == 0 {
+		r.QuotaWarningThreshold = defaultQuotaWarningThreshold
+	}
 
-	var ctx = context.WithValue(context.Background(), "log", r.log)
+	var ctx = ctrllog.IntoContext(context.Background(), mgr.GetLogger().WithName("dynamicnamespace"))
+
+	// Создание или обновление CRD ресурса; информеры запускаются только после его готовности
+	err := r.CRDs.Setup(ctx, r.PlatformClient, crd.DynamicNamespace, crd.Revision)
+	if err != nil {
+		return err
+	}
 
-	// Создание или обновление CRD ресурса
-	r.DeployCRD(ctx, crd.DynamicNamespace)
+	// Namespace нельзя сделать владельцем-ссылкой на namespaced ресурс, поэтому сгенерированные
+	// объекты отслеживаются по лейблу defaultLabelKey, а не через Owns()
+	var ownedPredicate = builder.WithPredicates(predicate.NewPredicateFuncs(hasOwnerLabel))
+	var ownerHandler = handler.EnqueueRequestsFromMapFunc(mapOwnerLabel)
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&platformv1.DynamicNamespace{}).
+		Watches(&source.Kind{Type: &v1.Namespace{}}, ownerHandler, ownedPredicate).
+		Watches(&source.Kind{Type: &v1.ResourceQuota{}}, ownerHandler, ownedPredicate).
+		Watches(&source.Kind{Type: &rbacv1.RoleBinding{}}, ownerHandler, ownedPredicate).
+		Watches(&source.Kind{Type: &networkingv1.NetworkPolicy{}}, ownerHandler, ownedPredicate).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+			RateLimiter:             r.RateLimiter,
+		}).
 		Complete(r)
 }
 
-func (r *DynamicNamespaceReconciler) updateStatus(log *logrus.Entry, ctx context.Context, resource *platformv1.DynamicNamespace, status *platformv1.DynamicNamespaceStatus) {
-	if !reflect.DeepEqual(status, resource.Status) {
+// hasOwnerLabel отбирает объекты, созданные контроллером
+func hasOwnerLabel(obj client.Object) bool {
+	_, ok := obj.GetLabels()[defaultLabelKey]
+	return ok
+}
+
+// mapOwnerLabel переводит изменение сгенерированного объекта в запрос на reconcile его DynamicNamespace
+func mapOwnerLabel(obj client.Object) []reconcile.Request {
+	owner, ok := parseOwnerLabel(obj.GetLabels()[defaultLabelKey])
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: owner}}
+}
+
+// withCode возвращает копию текущего статуса ресурса с новым кодом и сообщением,
+// сохраняя остальные поля статуса
+func withCode(resource *platformv1.DynamicNamespace, code string, reason messages.Reason, message string) *platformv1.DynamicNamespaceStatus {
+	var status = resource.Status.DeepCopy()
+	status.Code = code
+	status.Reason = string(reason)
+	status.Message = message
+	return status
+}
+
+// withError переводит ошибку обработки в статус ERROR; код причины и текст берутся из каталога сообщений
+func withError(resource *platformv1.DynamicNamespace, language messages.Language, err error) *platformv1.DynamicNamespaceStatus {
+	var message = messages.FromError(err)
+	return withCode(resource, "ERROR", message.Reason, language.Text(message))
+}
+
+func (r *DynamicNamespaceReconciler) updateStatus(ctx context.Context, resource *platformv1.DynamicNamespace, status *platformv1.DynamicNamespaceStatus) {
+	if !reflect.DeepEqual(*status, resource.Status) {
+		var previousCode = resource.Status.Code
 		resource.Status = *status
-		var err = r.Client.Status().Update(ctx, resource)
+		var err = r.saveStatus(ctx, resource)
 		if err != nil {
-			log.Errorf("  Ошибка при обновлении статуса ресурса [%v.%v]: %v", resource.GetName(), resource.GetNamespace(), err)
+			ctrllog.FromContext(ctx).Error(err, "  Ошибка при обновлении статуса ресурса")
+			return
+		}
+		// Уведомления отправляются только при смене кода статуса
+		if status.Code != previousCode {
+			switch status.Code {
+			case "ACTIVE":
+				r.notify(resource, notifier.EventActive, status.Message)
+			case "ERROR":
+				r.notify(resource, notifier.EventError, status.Message)
+			}
 		}
 	}
 }
 
+// saveStatus сохраняет статус ресурса. Ответ API-сервера записывается в копию, чтобы не
+// затереть значения по умолчанию, подставленные в спецификацию в памяти
+func (r *DynamicNamespaceReconciler) saveStatus(ctx context.Context, resource *platformv1.DynamicNamespace) error {
+	var stored = resource.DeepCopy()
+	err := r.Client.Status().Update(ctx, stored)
+	if err != nil {
+		return err
+	}
+	resource.Status = stored.Status
+	resource.ResourceVersion = stored.ResourceVersion
+	return nil
+}
+
+// notify отправляет уведомление о событии жизненного цикла ресурса
+func (r *DynamicNamespaceReconciler) notify(resource *platformv1.DynamicNamespace, eventType string, message string) {
+	var event = notifier.Event{
+		Type:            eventType,
+		Name:            resource.GetName(),
+		Namespace:       resource.GetNamespace(),
+		TargetNamespace: targetNamespace(resource),
+		Message:         message,
+	}
+	if resource.Status.ExpiresAt != nil {
+		var expiresAt = resource.Status.ExpiresAt.Time
+		event.ExpiresAt = &expiresAt
+	}
+	r.Notifier.Notify(event)
+}
+
 func (r *DynamicNamespaceReconciler) hasDefaultFinalizer(resource *platformv1.DynamicNamespace) bool {
 	return controllerutil.ContainsFinalizer(resource, defaultFinalizer)
 }
@@ -161,14 +462,14 @@ func (r *DynamicNamespaceReconciler) InjectDefaultFinalizer(ctx context.Context,
 }
 
 func (r *DynamicNamespaceReconciler) processDefaultFinalization(
-	log *logrus.Entry,
 	ctx context.Context,
 	resource *platformv1.DynamicNamespace,
-	finalizer func(resource *platformv1.DynamicNamespace) error,
+	finalizer func(ctx context.Context, resource *platformv1.DynamicNamespace) error,
 ) error {
+	var log = ctrllog.FromContext(ctx)
 	if controllerutil.ContainsFinalizer(resource, defaultFinalizer) {
 		// Запуск логики финализации ресурса
-		if err := finalizer(resource); err != nil {
+		if err := finalizer(ctx, resource); err != nil {
 			return err
 		}
 
@@ -178,21 +479,29 @@ func (r *DynamicNamespaceReconciler) processDefaultFinalization(
 		if err != nil {
 			return err
 		}
-		log.Infof("Успешно удалён финалайзер ресурса [%v.%v]", resource.GetName(), resource.GetNamespace())
-		log.Infof("Успешно удалён ресурс [%v.%v]", resource.GetName(), resource.GetNamespace())
+		log.Info("Успешно удалён финалайзер ресурса")
+		log.Info("Успешно удалён ресурс")
+		r.notify(resource, notifier.EventDeleted, "")
 	} else {
-		log.Infof("Успешно удалён ресурс [%v.%v]", resource.GetName(), resource.GetNamespace())
+		log.Info("Успешно удалён ресурс")
 	}
 
 	return nil
 }
 
-func (r *DynamicNamespaceReconciler) finalize(resource *platformv1.DynamicNamespace) error {
-	r.log.Infof("Финализация ресурса: %v", resource.Name)
+func (r *DynamicNamespaceReconciler) finalize(ctx context.Context, resource *platformv1.DynamicNamespace) error {
+	var log = ctrllog.FromContext(ctx)
+	log.Info("Финализация ресурса")
 	//Проверка на основании лейбла или аннотации
 	//Если есть нужная метка, подтверждающая, что этот ресурс наш, то удаляем
 	// TODO: реализовать проверку метки
 
+	// Дочерние namespace удаляются каскадно вместе с родителем
+	err := r.deleteChildren(ctx, resource)
+	if err != nil {
+		return err
+	}
+
 	desiredNamespace, err := generateNamespace(resource)
 	if err != nil {
 		return err
@@ -200,159 +509,327 @@ func (r *DynamicNamespaceReconciler) finalize(resource *platformv1.DynamicNamesp
 
 	//Проверка есть ли у созданного ns нужный label
 	namespace := &v1.Namespace{}
-	err = r.Get(context.TODO(), types.NamespacedName{Name: resource.Name}, namespace)
+	err = r.Get(ctx, types.NamespacedName{Name: targetNamespace(resource)}, namespace)
 	if err != nil && kerrors.IsNotFound(err) {
-		r.log.Infof("Целевой ресурс Application [%v.%v] уже удален", resource.GetName(), resource.GetNamespace())
+		log.Info("Целевой Namespace уже удален", "namespace", targetNamespace(resource))
 		return nil
 	}
 	namespaceLabels := namespace.GetLabels()
 
-	if namespaceLabels[defaultLabelKey] == fmt.Sprintf("%s.%s", resource.Namespace, resource.Name) {
-		err = r.Delete(context.TODO(), desiredNamespace)
+	if namespaceLabels[defaultLabelKey] == ownerLabelValue(resource) {
+		err = r.Delete(ctx, desiredNamespace)
 		if err != nil {
 			return err
 		}
-		r.log.Infof("Успешно удален целевой Namespace [%v.%v]", resource.GetName(), resource.GetNamespace())
+		log.Info("Успешно удален целевой Namespace", "namespace", targetNamespace(resource))
 	} else {
-		r.log.Infof("Целевой Namespace [%v.%v] не содержит нужного лейбла", resource.GetName(), resource.GetNamespace())
+		log.Info("Целевой Namespace не содержит нужного лейбла", "namespace", targetNamespace(resource))
 	}
 
 	return nil
 }
 
-func (r *DynamicNamespaceReconciler) validate(resource *platformv1.DynamicNamespace) error {
-	r.log.Infof("Валидация ресурса: %v", resource.Name)
+func (r *DynamicNamespaceReconciler) validate(ctx context.Context, resource *platformv1.DynamicNamespace, settings *Settings) error {
+	ctrllog.FromContext(ctx).Info("Валидация ресурса")
 
-	//Проверка есть ли у созданного ns нужный label
-	namespace := &v1.Namespace{}
-	err := r.Get(context.TODO(), types.NamespacedName{Name: resource.Name}, namespace)
-	if err != nil && kerrors.IsNotFound(err) {
-		return nil
-	} else {
+	// Роли, требующие подтверждения, разрешены только при включенной политике подтверждения
+	var restrictedRoles []string
+	if r.Approval.enabled() {
+		restrictedRoles = r.Approval.RestrictedRoles
+	}
+	err := settings.checkRole(resource.Spec.Role, restrictedRoles)
+	if err != nil {
+		return err
+	}
+	err = validateChildren(resource)
+	if err != nil {
+		return err
+	}
+	err = validateQuotas(resource)
+	if err != nil {
+		return err
+	}
+	err = validateQuotaAutoscaling(resource)
+	if err != nil {
+		return err
+	}
+
+	// Проверка есть ли у созданных ns нужный label. Namespace читаются из API-сервера:
+	// кэш шарда не содержит namespace без лейбла шарда, в том числе чужие с тем же именем
+	for _, name := range familyNamespaces(resource) {
+		namespace := &v1.Namespace{}
+		err = r.APIReader.Get(ctx, types.NamespacedName{Name: name}, namespace)
+		if kerrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
 		namespaceLabels := namespace.GetLabels()
 		// Если лейбл есть, то ресурс обновляется
-		if namespaceLabels[defaultLabelKey] == fmt.Sprintf("%s.%s", resource.Namespace, resource.Name) {
-			return nil
+		if namespaceLabels[defaultLabelKey] != ownerLabelValue(resource) {
+			return messages.Errorf(messages.NamespaceConflict, name)
 		}
-		return errors.New("namespace с таким именем уже существует")
 	}
+	return nil
 }
 
 func (r *DynamicNamespaceReconciler) createOrUpdateNamespace(ctx context.Context, resource *platformv1.DynamicNamespace) error {
-	r.log.Infof("Прикладная логика над ресурсом: %v", resource.Name)
+	var log = ctrllog.FromContext(ctx)
+	log.Info("Прикладная логика над ресурсом")
 	desiredNamespace, err := generateNamespace(resource)
 	if err != nil {
 		return err
 	}
 
-	namespace := &v1.Namespace{}
-
-	err = r.Get(ctx, types.NamespacedName{Name: resource.Name}, namespace)
-
-	if err != nil && kerrors.IsNotFound(err) {
-		r.log.Infof("Целевой Namespace [%v] не создан, создаю...", desiredNamespace.GetName())
-		err = r.Create(ctx, desiredNamespace)
-		if err != nil {
-			return err
-		}
-		r.log.Infof("Целевой Namespace [%v] успешно создан", desiredNamespace.GetName())
-		//Создаем Quota и SA
-		//TODO: Create SA
-		//TODO: Create secret with SA token in initial namespace
-		//TODO: Create default ResourceQuota
-	} else {
-		//Если NS уже создан (что делаем?)
-		//Проверка на базе версии ресурса?
-		//Проверка на основании лейбла или аннотации
-		//Создаем/удаляем SA или Quota
-		//TODO: реализовать создание удаление SA/Quota при обновлении DN, если уже создан NS
+	err = r.applyNamespace(ctx, resource, desiredNamespace)
+	if err != nil {
+		return err
 	}
+	log.Info("Целевой Namespace применен", "namespace", desiredNamespace.GetName())
+	//TODO: Create SA
+	//TODO: Create secret with SA token in initial namespace
 	return nil
 }
 
 func (r *DynamicNamespaceReconciler) createOrUpdateResourceQuota(ctx context.Context, resource *platformv1.DynamicNamespace) error {
-	r.log.Infof("Создаем квоту для неймспейса: %v", resource.Name)
-	desiredResourceQuota, err := generateResourceQuota(resource)
+	var log = ctrllog.FromContext(ctx)
+	log.Info("Применяем квоты для неймспейса", "namespace", targetNamespace(resource))
+	desiredResourceQuotas, err := generateResourceQuotas(resource)
 	if err != nil {
 		return err
 	}
 
-	quota := &v1.ResourceQuota{}
-	err = r.Get(ctx, types.NamespacedName{
-		Namespace: desiredResourceQuota.GetNamespace(),
-		Name:      desiredResourceQuota.GetName(),
-	}, quota)
-
-	if err != nil && kerrors.IsNotFound(err) {
-		r.log.Infof("Целевая ResourceQuota [%v] не создана, создаю...", desiredResourceQuota.GetName())
-		err = r.Create(ctx, desiredResourceQuota)
+	var desired = map[string]bool{}
+	for _, desiredResourceQuota := range desiredResourceQuotas {
+		err = r.apply(ctx, desiredResourceQuota)
 		if err != nil {
 			return err
 		}
-		r.log.Infof("Целевая ResourceQuota [%v] успешно создана", desiredResourceQuota.GetName())
+		desired[desiredResourceQuota.GetName()] = true
+		log.Info("Целевая ResourceQuota применена", "resourcequota", desiredResourceQuota.GetName())
+	}
+
+	// Удаление квот, исключенных из спецификации
+	var quotas v1.ResourceQuotaList
+	err = r.List(ctx, &quotas, client.InNamespace(targetNamespace(resource)), client.MatchingLabels(ownerLabels(resource)))
+	if err != nil {
+		return err
+	}
+	for i := range quotas.Items {
+		if desired[quotas.Items[i].Name] {
+			continue
+		}
+		err = r.Delete(ctx, &quotas.Items[i])
+		if err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+		log.Info("Удалена ResourceQuota", "resourcequota", quotas.Items[i].Name)
+	}
+	return nil
+}
+
+// validateQuotas проверяет, что имена дополнительных квот уникальны и не совпадают с квотой из createQuota
+func validateQuotas(resource *platformv1.DynamicNamespace) error {
+	var names = map[string]bool{}
+	for _, quota := range resource.Spec.Quotas {
+		if quota.Name == "resourcequota" {
+			return messages.Errorf(messages.QuotaNameReserved, quota.Name)
+		}
+		if names[quota.Name] {
+			return messages.Errorf(messages.QuotaDuplicated, quota.Name)
+		}
+		names[quota.Name] = true
+		if len(quota.Hard) == 0 {
+			return messages.Errorf(messages.QuotaLimitsMissing, quota.Name)
+		}
 	}
 	return nil
 }
 
 func (r *DynamicNamespaceReconciler) createOrUpdateRoleBinding(ctx context.Context, resource *platformv1.DynamicNamespace) error {
-	r.log.Infof("Создаем RoleBinding для неймспейса: %v", resource.Name)
+	var log = ctrllog.FromContext(ctx)
+	log.Info("Применяем RoleBinding для неймспейса", "namespace", targetNamespace(resource))
 	desiredRoleBinding, err := generateRoleBinding(resource)
 	if err != nil {
 		return err
 	}
 
-	quota := &v1beta1.RoleBinding{}
-	err = r.Get(ctx, types.NamespacedName{
-		Namespace: desiredRoleBinding.GetNamespace(),
-		Name:      desiredRoleBinding.GetName(),
-	}, quota)
+	err = applyRoleBinding(ctx, r.Client, desiredRoleBinding, fieldManager)
+	if err != nil {
+		return err
+	}
+	log.Info("Целевая RoleBinding применена", "rolebinding", desiredRoleBinding.GetName())
+	return nil
+}
 
-	if err != nil && kerrors.IsNotFound(err) {
-		r.log.Infof("Целевая RoleBinding [%v] не создана, создаю...", desiredRoleBinding.GetName())
-		err = r.Create(ctx, desiredRoleBinding)
-		if err != nil {
+// applyRoleBinding применяет RoleBinding от имени manager. roleRef неизменяем, поэтому при смене роли
+// существующая RoleBinding удаляется и создается заново
+func applyRoleBinding(ctx context.Context, c client.Client, roleBinding *rbacv1.RoleBinding, manager string) error {
+	var existing rbacv1.RoleBinding
+	err := c.Get(ctx, client.ObjectKeyFromObject(roleBinding), &existing)
+	if err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && existing.RoleRef != roleBinding.RoleRef {
+		ctrllog.FromContext(ctx).Info("Роль RoleBinding изменилась, пересоздаем", "rolebinding", roleBinding.GetName(), "namespace", roleBinding.GetNamespace(), "from", existing.RoleRef.Name, "to", roleBinding.RoleRef.Name)
+		err = c.Delete(ctx, &existing)
+		if err != nil && !kerrors.IsNotFound(err) {
 			return err
 		}
-		r.log.Infof("Целевая RoleBinding [%v] успешно создана", desiredRoleBinding.GetName())
 	}
-	return nil
+	return c.Patch(ctx, roleBinding, client.Apply, client.FieldOwner(manager), client.ForceOwnership)
+}
+
+// applyNamespace применяет namespace окружения. Новый namespace сначала создается: Create атомарен,
+// поэтому при параллельной обработке двух DynamicNamespace с одинаковым именем целевого namespace
+// его получит только один, а server-side apply второго не перехватит лейблы владельца
+func (r *DynamicNamespaceReconciler) applyNamespace(ctx context.Context, resource *platformv1.DynamicNamespace, namespace *v1.Namespace) error {
+	var existing v1.Namespace
+	err := r.Get(ctx, client.ObjectKeyFromObject(namespace), &existing)
+	if kerrors.IsNotFound(err) {
+		err = r.Create(ctx, namespace.DeepCopy(), client.FieldOwner(fieldManager))
+		if kerrors.IsAlreadyExists(err) {
+			// Кэш мог отстать от собственного namespace; владелец проверяется по API-серверу
+			err = r.APIReader.Get(ctx, client.ObjectKeyFromObject(namespace), &existing)
+			if err == nil && existing.GetLabels()[defaultLabelKey] != ownerLabelValue(resource) {
+				return messages.Errorf(messages.NamespaceConflict, namespace.GetName())
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return r.apply(ctx, namespace)
+}
+
+// apply применяет объект через server-side apply от имени fieldManager.
+// Контроллер владеет только теми полями, которые задает генератор, поэтому
+// лейблы и аннотации, добавленные другими контроллерами (например, Istio), сохраняются.
+func (r *DynamicNamespaceReconciler) apply(ctx context.Context, obj client.Object) error {
+	return r.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+}
+
+// targetNamespace возвращает имя целевого namespace: взятого из пула или совпадающего с именем ресурса
+func targetNamespace(resource *platformv1.DynamicNamespace) string {
+	if resource.Status.Namespace != "" {
+		return resource.Status.Namespace
+	}
+	return resource.Name
+}
+
+// ownerLabels возвращает лейблы, по которым сгенерированный объект связывается со своим DynamicNamespace
+func ownerLabels(resource *platformv1.DynamicNamespace) map[string]string {
+	var labels = shardLabels(resource.GetLabels())
+	labels[defaultLabelKey] = ownerLabelValue(resource)
+	return labels
 }
 
 func generateNamespace(resource *platformv1.DynamicNamespace) (*v1.Namespace, error) {
-	labels := map[string]string{
-		defaultLabelKey: fmt.Sprintf("%s.%s", resource.Namespace, resource.Name),
+	labels := map[string]string{}
+	for key, value := range resource.Spec.Labels {
+		labels[key] = value
+	}
+	for key, value := range ownerLabels(resource) {
+		labels[key] = value
 	}
 	return &v1.Namespace{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       "Namespace",
+		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:   resource.Name,
+			Name:   targetNamespace(resource),
 			Labels: labels,
 		},
 	}, nil
 }
 
 func generateResourceQuota(resource *platformv1.DynamicNamespace) (*v1.ResourceQuota, error) {
+	hard, err := parentQuota(resource)
+	if err != nil {
+		return nil, err
+	}
+	hard = autoscaledQuota(resource, hard)
 	return &v1.ResourceQuota{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       "ResourceQuota",
+		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      fmt.Sprintf("%s-resourcequota", resource.Name),
-			Namespace: resource.Name,
+			Namespace: targetNamespace(resource),
+			Labels:    ownerLabels(resource),
 		},
 		Spec: v1.ResourceQuotaSpec{
-			Hard: resource.Spec.CreateQuota,
+			Hard: hard,
 		},
 	}, nil
 }
 
-func generateRoleBinding(resource *platformv1.DynamicNamespace) (*v1beta1.RoleBinding, error) {
-	return &v1beta1.RoleBinding{
+// generateResourceQuotas возвращает все квоты целевого namespace: квоту из createQuota
+// и дополнительные квоты из spec.quotas
+func generateResourceQuotas(resource *platformv1.DynamicNamespace) ([]*v1.ResourceQuota, error) {
+	var quotas []*v1.ResourceQuota
+	if len(resource.Spec.CreateQuota) > 0 {
+		quota, err := generateResourceQuota(resource)
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, quota)
+	}
+	for i := range resource.Spec.Quotas {
+		quotas = append(quotas, generateNamedResourceQuota(resource, &resource.Spec.Quotas[i]))
+	}
+	return quotas, nil
+}
+
+func generateNamedResourceQuota(resource *platformv1.DynamicNamespace, quota *platformv1.NamedQuota) *v1.ResourceQuota {
+	return &v1.ResourceQuota{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       "ResourceQuota",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", resource.Name, quota.Name),
+			Namespace: targetNamespace(resource),
+			Labels:    ownerLabels(resource),
+		},
+		Spec: v1.ResourceQuotaSpec{
+			Hard:          quota.Hard,
+			Scopes:        quota.Scopes,
+			ScopeSelector: quota.ScopeSelector,
+		},
+	}
+}
+
+func generateRoleBinding(resource *platformv1.DynamicNamespace) (*rbacv1.RoleBinding, error) {
+	// rbac.authorization.k8s.io/v1beta1 удален в Kubernetes 1.22, поэтому RoleBinding
+	// создается в v1, а субъекты из спецификации конвертируются поле в поле
+	subjects := make([]rbacv1.Subject, 0, len(resource.Spec.RoleBindingSubjects))
+	for _, subject := range resource.Spec.RoleBindingSubjects {
+		subjects = append(subjects, rbacv1.Subject{
+			Kind:      subject.Kind,
+			APIGroup:  subject.APIGroup,
+			Name:      subject.Name,
+			Namespace: subject.Namespace,
+		})
+	}
+	return &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       "RoleBinding",
+		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      fmt.Sprintf("%s-rolebinding", resource.Name),
-			Namespace: resource.Name,
+			Namespace: targetNamespace(resource),
+			Labels:    ownerLabels(resource),
 		},
-		RoleRef: v1beta1.RoleRef{
+		RoleRef: rbacv1.RoleRef{
 			APIGroup: "rbac.authorization.k8s.io",
 			Kind:     "ClusterRole",
-			Name:     "admin",
+			Name:     resource.Spec.Role,
 		},
-		Subjects: resource.Spec.RoleBindingSubjects,
+		Subjects: subjects,
 	}, nil
 }
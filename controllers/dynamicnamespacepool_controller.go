@@ -0,0 +1,528 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/config/crd"
+	"github.com/wbe7/dynamicnamespace/internal/health"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"github.com/wbe7/dynamicnamespace/internal/platform"
+	"k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var (
+	// poolLabelKey помечает свободные namespace пула: <namespace пула>.<имя пула>
+	poolLabelKey = platformv1.GroupVersion.Group + "/pool"
+)
+
+const (
+	// poolFieldManager владеет полями, которые пул задает свободным namespace. Он отличается от
+	// fieldManager окружения, поэтому лейблы и аннотации пула сохраняются после захвата namespace
+	poolFieldManager = "dynamicnamespacepool-controller"
+
+	// Имена объектов пула в свободных namespace
+	poolQuotaName       = "pool-resourcequota"
+	poolRoleBindingName = "pool-rolebinding"
+)
+
+// DynamicNamespacePoolReconciler keeps a number of ready, unassigned namespaces per pool
+type DynamicNamespacePoolReconciler struct {
+	client.Client
+	*platform.PlatformClient
+	// APIReader читает напрямую из API-сервера объекты, которые не нужно держать в кэше
+	APIReader client.Reader
+	// Установка CRD и ожидание его готовности
+	CRDs *platform.CRDInstaller
+	// Watchdog отслеживает зависшие циклы обработки для liveness-проверки
+	Watchdog *health.Watchdog
+	// Настройки из файла конфигурации, которые меняются без перезапуска
+	Settings *SettingsStore
+	// Политика подтверждения окружений. Пулы подтвердить нельзя, поэтому пул, которому
+	// понадобилось бы подтверждение, отклоняется
+	Approval ApprovalPolicy
+	// Число параллельных обработчиков; 0 - controller.groupKindConcurrency из конфигурации или 1
+	MaxConcurrentReconciles int
+	// Ограничитель очереди; nil - ограничитель controller-runtime по умолчанию
+	RateLimiter workqueue.RateLimiter
+	// Только планировать создание и удаление namespace пулов, ничего не применяя
+	DryRun bool
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=platform.cloudnative.space,resources=dynamicnamespacepools,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=platform.cloudnative.space,resources=dynamicnamespacepools/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=platform.cloudnative.space,resources=dynamicnamespacepools/finalizers,verbs=update
+// +kubebuilder:rbac:groups=core,resources=resourcequotas,verbs=deletecollection
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings,verbs=deletecollection
+// +kubebuilder:rbac:groups=core,resources=configmaps;secrets,verbs=get;create;patch
+
+// Reconcile дополняет пул до spec.size свободных namespace и удаляет лишние
+func (r *DynamicNamespacePoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var log = ctrllog.FromContext(ctx).WithValues("dynamicnamespacepool", req.NamespacedName.String())
+	ctx = ctrllog.IntoContext(ctx, log)
+	log.Info("> Начало обработки пула")
+	defer r.Watchdog.Begin()()
+	var settings = r.Settings.Load()
+
+	var pool platformv1.DynamicNamespacePool
+	var err = r.Get(ctx, req.NamespacedName, &pool)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			log.V(1).Info("Пул был удален ранее")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "< Ошибка при чтении CR DynamicNamespacePool")
+		return ctrl.Result{}, err
+	}
+
+	free, err := listPoolNamespaces(ctx, r.Client, &pool)
+	if err != nil {
+		log.Error(err, "Ошибка при получении свободных namespace пула")
+		return ctrl.Result{}, err
+	}
+
+	// Удаление пула: свободные namespace удаляются вместе с ним, захваченные остаются своим DynamicNamespace
+	if pool.GetDeletionTimestamp() != nil {
+		if !controllerutil.ContainsFinalizer(&pool, defaultFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		// В режиме dry-run пул сохраняет финализатор, пока режим не выключат
+		if r.dryRun(&pool) {
+			var plan []string
+			for i := range free {
+				plan = append(plan, planDelete("Namespace", &free[i]))
+			}
+			r.reportPoolPlan(ctx, &pool, int32(len(free)), plan, settings)
+			return ctrl.Result{}, nil
+		}
+		for i := range free {
+			err = r.Delete(ctx, &free[i])
+			if err != nil && !kerrors.IsNotFound(err) {
+				log.Error(err, "Ошибка при удалении namespace пула", "namespace", free[i].GetName())
+				return ctrl.Result{}, err
+			}
+		}
+		controllerutil.RemoveFinalizer(&pool, defaultFinalizer)
+		err = r.Update(ctx, &pool)
+		if err != nil {
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+		log.Info("Успешно удален пул")
+		return ctrl.Result{}, nil
+	}
+
+	// Пулы из namespace, не разрешенных конфигурацией, не заполняются
+	rejection, err := sourceRejection(ctx, r.APIReader, pool.GetNamespace(), settings)
+	if err != nil {
+		log.Error(err, "Ошибка при проверке namespace пула")
+		return ctrl.Result{}, err
+	}
+	if rejection != nil {
+		log.Info("< Пул отклонен", "reason", rejection.String())
+		r.updatePoolStatus(ctx, &pool, int32(len(free)), rejection.Reason, settings.Language.Text(messages.New(messages.PoolRejected, *rejection)))
+		return ctrl.Result{RequeueAfter: rejectedRequeue}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(&pool, defaultFinalizer) {
+		controllerutil.AddFinalizer(&pool, defaultFinalizer)
+		err = r.Update(ctx, &pool)
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Роль, размер и квота пула проверяются до создания namespace; подтверждения для пулов нет
+	err = r.checkPool(&pool, settings)
+	if err != nil {
+		log.Error(err, "Пул не разрешен")
+		r.updatePoolStatus(ctx, &pool, int32(len(free)), messages.FromError(err).Reason, settings.Language.ErrorText(err))
+		return ctrl.Result{}, nil
+	}
+
+	// Объекты заполнения читаются один раз и копируются во все свободные namespace
+	seeds, err := r.seedObjects(ctx, &pool)
+	if err != nil {
+		log.Error(err, "Ошибка при чтении объектов заполнения пула")
+		r.updatePoolStatus(ctx, &pool, int32(len(free)), messages.FromError(err).Reason, settings.Language.ErrorText(err))
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// В режиме dry-run namespace пула только планируются
+	if r.dryRun(&pool) {
+		var plan []string
+		for i := int(pool.Spec.Size); i < len(free); i++ {
+			plan = append(plan, planDelete("Namespace", &free[i]))
+		}
+		for i := len(free); i < int(pool.Spec.Size); i++ {
+			plan = append(plan, settings.Language.Text(messages.New(messages.PlanPoolFill, pool.Name, i+1, pool.Spec.Size)))
+		}
+		r.reportPoolPlan(ctx, &pool, int32(len(free)), plan, settings)
+		return ctrl.Result{}, nil
+	}
+
+	// Лишние namespace удаляются, начиная с самых новых
+	for len(free) > int(pool.Spec.Size) {
+		var namespace = free[len(free)-1]
+		err = r.Delete(ctx, &namespace)
+		if err != nil && !kerrors.IsNotFound(err) {
+			log.Error(err, "Ошибка при удалении лишнего namespace пула", "namespace", namespace.GetName())
+			r.updatePoolStatus(ctx, &pool, int32(len(free)), messages.FromError(err).Reason, settings.Language.ErrorText(err))
+			return ctrl.Result{}, err
+		}
+		log.Info("Удален лишний namespace пула", "namespace", namespace.GetName())
+		free = free[:len(free)-1]
+	}
+
+	for len(free) < int(pool.Spec.Size) {
+		namespace, err := r.createPoolNamespace(ctx, &pool)
+		if err != nil {
+			log.Error(err, "Ошибка при создании namespace пула")
+			r.updatePoolStatus(ctx, &pool, int32(len(free)), messages.FromError(err).Reason, settings.Language.ErrorText(err))
+			return ctrl.Result{}, err
+		}
+		log.Info("Создан namespace пула", "namespace", namespace.GetName())
+		free = append(free, *namespace)
+	}
+
+	// Шаблон применяется и к уже существующим namespace, чтобы они следовали изменениям спецификации пула
+	for i := range free {
+		err = r.preparePoolNamespace(ctx, &pool, &free[i], seeds, settings)
+		if err != nil {
+			log.Error(err, "Ошибка при подготовке namespace пула", "namespace", free[i].GetName())
+			r.updatePoolStatus(ctx, &pool, int32(len(free)), messages.FromError(err).Reason, settings.Language.ErrorText(err))
+			return ctrl.Result{}, err
+		}
+	}
+
+	r.updatePoolStatus(ctx, &pool, int32(len(free)), messages.Ready, settings.Language.Text(messages.New(messages.Ready)))
+	return ctrl.Result{}, nil
+}
+
+// checkPool проверяет роль пула по allowedRoles, размер по defaults.maxPoolSize и роль с квотой
+// свободных namespace по политике подтверждения: пул не может получить то, что окружению
+// выдается только после подтверждения
+func (r *DynamicNamespacePoolReconciler) checkPool(pool *platformv1.DynamicNamespacePool, settings *Settings) error {
+	var role = pool.Spec.Role
+	if role == "" {
+		role = settings.DefaultRole
+	}
+	err := settings.checkRole(role, nil)
+	if err != nil {
+		return err
+	}
+	if pool.Spec.Size > settings.MaxPoolSize {
+		return messages.Errorf(messages.PoolSizeExceeded, pool.Spec.Size, settings.MaxPoolSize)
+	}
+	if reason := r.Approval.check(role, pool.Spec.CreateQuota); reason != nil {
+		return messages.Errorf(messages.PoolApprovalRequired, *reason)
+	}
+	return nil
+}
+
+// dryRun сообщает, что namespace пула нужно только спланировать: глобально (--dry-run)
+// или аннотацией platform.cloudnative.space/dry-run=true на пуле
+func (r *DynamicNamespacePoolReconciler) dryRun(pool *platformv1.DynamicNamespacePool) bool {
+	return r.DryRun || pool.GetAnnotations()[dryRunAnnotation] == "true"
+}
+
+// reportPoolPlan записывает план dry-run в статус пула
+func (r *DynamicNamespacePoolReconciler) reportPoolPlan(ctx context.Context, pool *platformv1.DynamicNamespacePool, ready int32, plan []string, settings *Settings) {
+	var status = platformv1.DynamicNamespacePoolStatus{
+		Ready:   ready,
+		Reason:  string(messages.ChangesPending),
+		Message: settings.Language.Text(messages.New(messages.ChangesPending, len(plan))),
+		Plan:    plan,
+	}
+	if len(plan) == 0 {
+		status.Reason = string(messages.UpToDate)
+		status.Message = settings.Language.Text(messages.New(messages.UpToDate))
+	}
+	if !reflect.DeepEqual(status, pool.Status) {
+		ctrllog.FromContext(ctx).Info("Dry-run: план изменений пула", "plan", plan)
+		pool.Status = status
+		var err = r.Client.Status().Update(ctx, pool)
+		if err != nil {
+			ctrllog.FromContext(ctx).Error(err, "  Ошибка при обновлении статуса пула")
+		}
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DynamicNamespacePoolReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.PlatformClient = platform.NewPlatformClient(mgr.GetConfig(), r.Client)
+	if r.APIReader == nil {
+		r.APIReader = mgr.GetAPIReader()
+	}
+
+	var ctx = ctrllog.IntoContext(context.Background(), mgr.GetLogger().WithName("dynamicnamespacepool"))
+
+	// Создание или обновление CRD ресурса; информеры запускаются только после его готовности
+	err := r.CRDs.Setup(ctx, r.PlatformClient, crd.DynamicNamespacePool, crd.Revision)
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&platformv1.DynamicNamespacePool{}).
+		Watches(&source.Kind{Type: &v1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(mapPoolLabel), builder.WithPredicates(poolPredicate)).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+			RateLimiter:             r.RateLimiter,
+		}).
+		Complete(r)
+}
+
+// poolPredicate пропускает события namespace пула, включая снятие лейбла при захвате namespace,
+// чтобы пул сразу дополнялся
+var poolPredicate = predicate.Funcs{
+	CreateFunc: func(e event.CreateEvent) bool {
+		return hasPoolLabel(e.Object)
+	},
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return hasPoolLabel(e.ObjectOld) || hasPoolLabel(e.ObjectNew)
+	},
+	DeleteFunc: func(e event.DeleteEvent) bool {
+		return hasPoolLabel(e.Object)
+	},
+	GenericFunc: func(e event.GenericEvent) bool {
+		return hasPoolLabel(e.Object)
+	},
+}
+
+func hasPoolLabel(obj client.Object) bool {
+	_, ok := obj.GetLabels()[poolLabelKey]
+	return ok
+}
+
+// mapPoolLabel переводит изменение namespace пула в запрос на reconcile пула
+func mapPoolLabel(obj client.Object) []reconcile.Request {
+	pool, ok := parseOwnerLabel(obj.GetLabels()[poolLabelKey])
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: pool}}
+}
+
+func poolLabelValue(pool *platformv1.DynamicNamespacePool) string {
+	return fmt.Sprintf("%s.%s", pool.Namespace, pool.Name)
+}
+
+// listPoolNamespaces возвращает свободные namespace пула, отсортированные по времени создания
+func listPoolNamespaces(ctx context.Context, c client.Reader, pool *platformv1.DynamicNamespacePool) ([]v1.Namespace, error) {
+	var namespaces v1.NamespaceList
+	err := c.List(ctx, &namespaces, client.MatchingLabels{poolLabelKey: poolLabelValue(pool)})
+	if err != nil {
+		return nil, err
+	}
+
+	var free []v1.Namespace
+	for _, namespace := range namespaces.Items {
+		if namespace.GetDeletionTimestamp() == nil {
+			free = append(free, namespace)
+		}
+	}
+	sort.Slice(free, func(i, j int) bool {
+		return free[i].CreationTimestamp.Before(&free[j].CreationTimestamp)
+	})
+	return free, nil
+}
+
+// createPoolNamespace создает пустой namespace пула; остальное делает preparePoolNamespace
+func (r *DynamicNamespacePoolReconciler) createPoolNamespace(ctx context.Context, pool *platformv1.DynamicNamespacePool) (*v1.Namespace, error) {
+	var namespace = &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: pool.Name + "-",
+			Labels:       poolObjectLabels(pool),
+		},
+	}
+	err := r.Create(ctx, namespace, client.FieldOwner(poolFieldManager))
+	if err != nil {
+		return nil, err
+	}
+	return namespace, nil
+}
+
+// preparePoolNamespace применяет к свободному namespace лейблы и аннотации пула, его квоту,
+// RoleBinding и объекты заполнения
+func (r *DynamicNamespacePoolReconciler) preparePoolNamespace(ctx context.Context, pool *platformv1.DynamicNamespacePool, namespace *v1.Namespace, seeds []client.Object, settings *Settings) error {
+	var labels = poolObjectLabels(pool)
+	for key, value := range pool.Spec.Labels {
+		if _, ok := labels[key]; !ok {
+			labels[key] = value
+		}
+	}
+	// resourceVersion не дает вернуть лейбл пула namespace, который захватили после чтения списка
+	var metadata = &v1.Namespace{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       "Namespace",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            namespace.GetName(),
+			ResourceVersion: namespace.GetResourceVersion(),
+			Labels:          labels,
+			Annotations:     pool.Spec.Annotations,
+		},
+	}
+	err := r.apply(ctx, metadata)
+	if err != nil {
+		return err
+	}
+
+	var quota = &v1.ResourceQuota{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1.SchemeGroupVersion.String(),
+			Kind:       "ResourceQuota",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      poolQuotaName,
+			Namespace: namespace.GetName(),
+			Labels:    poolObjectLabels(pool),
+		},
+		Spec: v1.ResourceQuotaSpec{
+			Hard: pool.Spec.CreateQuota,
+		},
+	}
+	err = r.apply(ctx, quota)
+	if err != nil {
+		return err
+	}
+
+	err = r.applyPoolRoleBinding(ctx, pool, namespace.GetName(), settings)
+	if err != nil {
+		return err
+	}
+
+	for _, seed := range seeds {
+		var obj = seed.DeepCopyObject().(client.Object)
+		obj.SetNamespace(namespace.GetName())
+		err = r.apply(ctx, obj)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyPoolRoleBinding выдает roleBindingSubjects пула доступ к свободному namespace.
+// Без субъектов RoleBinding пула удаляется
+func (r *DynamicNamespacePoolReconciler) applyPoolRoleBinding(ctx context.Context, pool *platformv1.DynamicNamespacePool, namespace string, settings *Settings) error {
+	if len(pool.Spec.RoleBindingSubjects) == 0 {
+		var roleBinding = &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: poolRoleBindingName, Namespace: namespace}}
+		return client.IgnoreNotFound(r.Delete(ctx, roleBinding))
+	}
+
+	var role = pool.Spec.Role
+	if role == "" {
+		role = settings.DefaultRole
+	}
+	var subjects = make([]rbacv1.Subject, 0, len(pool.Spec.RoleBindingSubjects))
+	for _, subject := range pool.Spec.RoleBindingSubjects {
+		subjects = append(subjects, rbacv1.Subject{
+			Kind:      subject.Kind,
+			APIGroup:  subject.APIGroup,
+			Name:      subject.Name,
+			Namespace: subject.Namespace,
+		})
+	}
+	var roleBinding = &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       "RoleBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      poolRoleBindingName,
+			Namespace: namespace,
+			Labels:    poolObjectLabels(pool),
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "ClusterRole",
+			Name:     role,
+		},
+		Subjects: subjects,
+	}
+	return applyRoleBinding(ctx, r.Client, roleBinding, poolFieldManager)
+}
+
+// seedObjects читает объекты заполнения из namespace пула и возвращает их копии без namespace.
+// Объекты читаются из API-сервера, чтобы не держать в кэше все ConfigMap и Secret кластера
+func (r *DynamicNamespacePoolReconciler) seedObjects(ctx context.Context, pool *platformv1.DynamicNamespacePool) ([]client.Object, error) {
+	var objects []client.Object
+	for _, seed := range pool.Spec.Seed {
+		var key = types.NamespacedName{Namespace: pool.Namespace, Name: seed.Name}
+		var objectMeta = metav1.ObjectMeta{Name: seed.Name, Labels: poolObjectLabels(pool)}
+		var err error
+		switch seed.Kind {
+		case "ConfigMap":
+			var source v1.ConfigMap
+			err = r.APIReader.Get(ctx, key, &source)
+			objects = append(objects, &v1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{APIVersion: v1.SchemeGroupVersion.String(), Kind: "ConfigMap"},
+				ObjectMeta: objectMeta,
+				Data:       source.Data,
+				BinaryData: source.BinaryData,
+			})
+		case "Secret":
+			var source v1.Secret
+			err = r.APIReader.Get(ctx, key, &source)
+			objects = append(objects, &v1.Secret{
+				TypeMeta:   metav1.TypeMeta{APIVersion: v1.SchemeGroupVersion.String(), Kind: "Secret"},
+				ObjectMeta: objectMeta,
+				Type:       source.Type,
+				Data:       source.Data,
+			})
+		default:
+			return nil, messages.Errorf(messages.SeedKindUnsupported, seed.Kind)
+		}
+		if kerrors.IsNotFound(err) {
+			return nil, messages.Errorf(messages.SeedNotFound, seed.Kind, seed.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+// poolObjectLabels возвращает лейблы, по которым объекты свободного namespace связываются с пулом
+func poolObjectLabels(pool *platformv1.DynamicNamespacePool) map[string]string {
+	var labels = shardLabels(pool.GetLabels())
+	labels[poolLabelKey] = poolLabelValue(pool)
+	return labels
+}
+
+// apply применяет объект свободного namespace через server-side apply от имени poolFieldManager
+func (r *DynamicNamespacePoolReconciler) apply(ctx context.Context, obj client.Object) error {
+	return r.Patch(ctx, obj, client.Apply, client.FieldOwner(poolFieldManager), client.ForceOwnership)
+}
+
+func (r *DynamicNamespacePoolReconciler) updatePoolStatus(ctx context.Context, pool *platformv1.DynamicNamespacePool, ready int32, reason messages.Reason, message string) {
+	var status = platformv1.DynamicNamespacePoolStatus{Ready: ready, Reason: string(reason), Message: message}
+	if !reflect.DeepEqual(status, pool.Status) {
+		pool.Status = status
+		var err = r.Client.Status().Update(ctx, pool)
+		if err != nil {
+			ctrllog.FromContext(ctx).Error(err, "  Ошибка при обновлении статуса пула")
+		}
+	}
+}
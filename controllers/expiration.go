@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// lastActivityAnnotation (RFC3339) обновляется CI на DynamicNamespace или целевом namespace
+	lastActivityAnnotation = platformv1.GroupVersion.Group + "/last-activity"
+	// wokeAtAnnotation (RFC3339) выставляется на namespace, когда контроллер будит его нагрузку
+	wokeAtAnnotation = platformv1.GroupVersion.Group + "/woke-at"
+)
+
+const (
+	// conditionExpiring выставляется в True, когда до автоматического удаления осталось меньше warningPeriod
+	conditionExpiring = "Expiring"
+
+	defaultWarningPeriod = 24 * time.Hour
+
+	// Поды, созданные в течение этого времени после пробуждения по расписанию, пересозданы
+	// самим контроллером и активностью не считаются
+	wakeActivityGrace = 15 * time.Minute
+)
+
+// expiration - результат вычисления срока жизни окружения
+type expiration struct {
+	expiresAt    time.Time
+	warningAt    time.Time
+	lastActivity *time.Time
+	// TTL или IdleTimeout - какое из ограничений наступает раньше
+	reason string
+}
+
+func (e *expiration) expired(now time.Time) bool {
+	return !now.Before(e.expiresAt)
+}
+
+func (e *expiration) warning(now time.Time) bool {
+	return !now.Before(e.warningAt)
+}
+
+// requeueAfter возвращает время до следующей границы: предупреждения или удаления
+func (e *expiration) requeueAfter(now time.Time) time.Duration {
+	if e.warning(now) {
+		return e.expiresAt.Sub(now)
+	}
+	return e.warningAt.Sub(now)
+}
+
+// evaluateExpiration вычисляет, когда окружение должно быть удалено по TTL или простою.
+// Удаление наступает не раньше, чем через warningPeriod после предупреждения (условие Expiring):
+// окружение с уже истекшим сроком сначала получает предупреждение.
+// Возвращает nil, если для ресурса не задана политика удаления.
+func (r *DynamicNamespaceReconciler) evaluateExpiration(ctx context.Context, resource *platformv1.DynamicNamespace, now time.Time) (*expiration, error) {
+	var policy = resource.Spec.Expiration
+	if policy == nil || (policy.TTL == nil && policy.IdleTimeout == nil) {
+		return nil, nil
+	}
+
+	var result *expiration
+	if policy.TTL != nil {
+		result = &expiration{
+			expiresAt: resource.CreationTimestamp.Add(policy.TTL.Duration),
+			reason:    "TTL",
+		}
+	}
+	if policy.IdleTimeout != nil {
+		lastActivity, err := r.lastActivity(ctx, resource)
+		if err != nil {
+			return nil, err
+		}
+		var idleAt = lastActivity.Add(policy.IdleTimeout.Duration)
+		if result == nil || idleAt.Before(result.expiresAt) {
+			result = &expiration{
+				expiresAt: idleAt,
+				reason:    "IdleTimeout",
+			}
+		}
+		result.lastActivity = &lastActivity
+	}
+
+	var warningPeriod = defaultWarningPeriod
+	if policy.WarningPeriod != nil {
+		warningPeriod = policy.WarningPeriod.Duration
+	}
+	var warnedAt = now
+	if condition := meta.FindStatusCondition(resource.Status.Conditions, conditionExpiring); condition != nil && condition.Status == metav1.ConditionTrue {
+		warnedAt = condition.LastTransitionTime.Time
+	}
+	if earliest := warnedAt.Add(warningPeriod); result.expiresAt.Before(earliest) {
+		result.expiresAt = earliest
+	}
+	result.warningAt = result.expiresAt.Add(-warningPeriod)
+	return result, nil
+}
+
+// setExpirationStatus переносит результат вычисления срока жизни в статус
+func setExpirationStatus(status *platformv1.DynamicNamespaceStatus, result *expiration, now time.Time, language messages.Language) {
+	if result == nil {
+		status.ExpiresAt = nil
+		status.LastActivity = nil
+		meta.RemoveStatusCondition(&status.Conditions, conditionExpiring)
+		return
+	}
+
+	var expiresAt = metav1.NewTime(result.expiresAt)
+	status.ExpiresAt = &expiresAt
+	status.LastActivity = nil
+	if result.lastActivity != nil {
+		var lastActivity = metav1.NewTime(*result.lastActivity)
+		status.LastActivity = &lastActivity
+	}
+
+	var condition = metav1.Condition{
+		Type:    conditionExpiring,
+		Status:  metav1.ConditionFalse,
+		Reason:  result.reason,
+		Message: language.Text(messages.New(messages.ExpiresAt, result.expiresAt.Format(time.RFC3339))),
+	}
+	if result.warning(now) {
+		condition.Status = metav1.ConditionTrue
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+}
+
+// lastActivity определяет время последней активности в окружении: аннотация CI, перезапуск или
+// создание подов, изменения Deployment, StatefulSet, Service и Ingress
+func (r *DynamicNamespaceReconciler) lastActivity(ctx context.Context, resource *platformv1.DynamicNamespace) (time.Time, error) {
+	var last = resource.CreationTimestamp.Time
+	var observe = func(t time.Time) {
+		if t.After(last) {
+			last = t
+		}
+	}
+	var observeObject = func(obj metav1.Object) {
+		observe(obj.GetCreationTimestamp().Time)
+		for _, entry := range obj.GetManagedFields() {
+			// Собственные изменения контроллера и обновления статуса активностью не считаются
+			if entry.Manager == fieldManager || entry.Subresource != "" || entry.Time == nil {
+				continue
+			}
+			observe(entry.Time.Time)
+		}
+	}
+	var observeAnnotation = func(obj metav1.Object) error {
+		value, ok := obj.GetAnnotations()[lastActivityAnnotation]
+		if !ok {
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return messages.Errorf(messages.AnnotationInvalid, lastActivityAnnotation, obj.GetName(), err)
+		}
+		observe(t)
+		return nil
+	}
+
+	err := observeAnnotation(resource)
+	if err != nil {
+		return last, err
+	}
+
+	var namespace v1.Namespace
+	err = r.Get(ctx, types.NamespacedName{Name: targetNamespace(resource)}, &namespace)
+	if err != nil {
+		return last, client.IgnoreNotFound(err)
+	}
+	err = observeAnnotation(&namespace)
+	if err != nil {
+		return last, err
+	}
+
+	// Активность в дочерних namespace продлевает жизнь всего окружения
+	for _, name := range familyNamespaces(resource) {
+		err = r.namespaceActivity(ctx, name, observe, observeObject)
+		if err != nil {
+			return last, err
+		}
+	}
+	return last, nil
+}
+
+// namespaceActivity передает в observe и observeObject признаки активности в одном namespace
+func (r *DynamicNamespaceReconciler) namespaceActivity(ctx context.Context, namespace string, observe func(time.Time), observeObject func(metav1.Object)) error {
+	var wokeAt time.Time
+	var target v1.Namespace
+	err := r.Get(ctx, types.NamespacedName{Name: namespace}, &target)
+	if err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+	if value, ok := target.GetAnnotations()[wokeAtAnnotation]; ok {
+		// Некорректное значение означает, что пробуждения не было
+		wokeAt, _ = time.Parse(time.RFC3339, value)
+	}
+
+	var pods v1.PodList
+	err = r.APIReader.List(ctx, &pods, client.InNamespace(namespace))
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		var created = pod.CreationTimestamp.Time
+		if wokeAt.IsZero() || created.Before(wokeAt) || created.After(wokeAt.Add(wakeActivityGrace)) {
+			observe(created)
+		}
+		for _, container := range pod.Status.ContainerStatuses {
+			if container.RestartCount == 0 {
+				continue
+			}
+			if container.State.Running != nil {
+				observe(container.State.Running.StartedAt.Time)
+			}
+			if container.LastTerminationState.Terminated != nil {
+				observe(container.LastTerminationState.Terminated.FinishedAt.Time)
+			}
+		}
+	}
+
+	var deployments appsv1.DeploymentList
+	var statefulSets appsv1.StatefulSetList
+	var services v1.ServiceList
+	var ingresses networkingv1.IngressList
+	for _, list := range []client.ObjectList{&deployments, &statefulSets, &services, &ingresses} {
+		err = r.APIReader.List(ctx, list, client.InNamespace(namespace))
+		if err != nil {
+			return err
+		}
+		err = meta.EachListItem(list, func(obj runtime.Object) error {
+			observeObject(obj.(metav1.Object))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
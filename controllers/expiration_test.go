@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestEvaluateExpiration(t *testing.T) {
+	var now = time.Date(2024, 3, 12, 12, 0, 0, 0, time.UTC)
+	var hours = func(n int) time.Duration { return time.Duration(n) * time.Hour }
+	var duration = func(d time.Duration) *metav1.Duration { return &metav1.Duration{Duration: d} }
+	var namespace = func(wokeAt time.Time) *v1.Namespace {
+		var ns = &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "feature"}}
+		if !wokeAt.IsZero() {
+			ns.Annotations = map[string]string{wokeAtAnnotation: wokeAt.Format(time.RFC3339)}
+		}
+		return ns
+	}
+	var pod = func(created time.Time) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:              "app-" + created.Format("150405"),
+			Namespace:         "feature",
+			CreationTimestamp: metav1.NewTime(created),
+		}}
+	}
+
+	var tests = []struct {
+		name         string
+		created      time.Time
+		policy       platformv1.ExpirationPolicy
+		warnedAt     time.Time
+		objects      []client.Object
+		expiresAt    time.Time
+		lastActivity time.Time
+		warning      bool
+		expired      bool
+	}{
+		{
+			name:      "TTL еще не истек",
+			created:   now.Add(-hours(1)),
+			policy:    platformv1.ExpirationPolicy{TTL: duration(hours(48))},
+			expiresAt: now.Add(hours(47)),
+		},
+		{
+			name:      "истекший TTL без предупреждения откладывается на warningPeriod",
+			created:   now.Add(-hours(240)),
+			policy:    platformv1.ExpirationPolicy{TTL: duration(hours(168))},
+			expiresAt: now.Add(hours(24)),
+			warning:   true,
+		},
+		{
+			name:      "предупреждение выдано недавно",
+			created:   now.Add(-hours(240)),
+			policy:    platformv1.ExpirationPolicy{TTL: duration(hours(168)), WarningPeriod: duration(hours(2))},
+			warnedAt:  now.Add(-hours(1)),
+			expiresAt: now.Add(hours(1)),
+			warning:   true,
+		},
+		{
+			name:      "после предупреждения прошел warningPeriod",
+			created:   now.Add(-hours(240)),
+			policy:    platformv1.ExpirationPolicy{TTL: duration(hours(168))},
+			warnedAt:  now.Add(-hours(24)),
+			expiresAt: now,
+			warning:   true,
+			expired:   true,
+		},
+		{
+			name:         "поды, пересозданные при пробуждении, не считаются активностью",
+			created:      now.Add(-hours(240)),
+			policy:       platformv1.ExpirationPolicy{IdleTimeout: duration(hours(24))},
+			warnedAt:     now.Add(-hours(24)),
+			objects:      []client.Object{namespace(now.Add(-hours(4))), pod(now.Add(-hours(4) + 5*time.Minute))},
+			expiresAt:    now,
+			lastActivity: now.Add(-hours(240)),
+			warning:      true,
+			expired:      true,
+		},
+		{
+			name:         "поды, созданные позже пробуждения, продлевают окружение",
+			created:      now.Add(-hours(240)),
+			policy:       platformv1.ExpirationPolicy{IdleTimeout: duration(hours(24))},
+			warnedAt:     now.Add(-hours(24)),
+			objects:      []client.Object{namespace(now.Add(-hours(4))), pod(now.Add(-hours(2)))},
+			expiresAt:    now.Add(hours(22)),
+			lastActivity: now.Add(-hours(2)),
+			warning:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var policy = test.policy
+			var resource = &platformv1.DynamicNamespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "team-a", CreationTimestamp: metav1.NewTime(test.created)},
+				Spec:       platformv1.DynamicNamespaceSpec{Expiration: &policy},
+			}
+			if !test.warnedAt.IsZero() {
+				resource.Status.Conditions = []metav1.Condition{{
+					Type:               conditionExpiring,
+					Status:             metav1.ConditionTrue,
+					LastTransitionTime: metav1.NewTime(test.warnedAt),
+				}}
+			}
+			var c = fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(test.objects...).Build()
+			var r = &DynamicNamespaceReconciler{Client: c, APIReader: c}
+
+			result, err := r.evaluateExpiration(context.Background(), resource, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.expiresAt.Equal(test.expiresAt) {
+				t.Errorf("expiresAt = %v, ожидалось %v", result.expiresAt, test.expiresAt)
+			}
+			if !test.lastActivity.IsZero() && (result.lastActivity == nil || !result.lastActivity.Equal(test.lastActivity)) {
+				t.Errorf("lastActivity = %v, ожидалось %v", result.lastActivity, test.lastActivity)
+			}
+			if result.warning(now) != test.warning || result.expired(now) != test.expired {
+				t.Errorf("warning = %v, expired = %v; ожидалось %v, %v", result.warning(now), result.expired(now), test.warning, test.expired)
+			}
+		})
+	}
+}
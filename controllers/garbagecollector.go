@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NamespaceGarbageCollector periodically removes managed namespaces whose
+// DynamicNamespace no longer exists
+type NamespaceGarbageCollector struct {
+	client.Client
+	// APIReader читает напрямую из API-сервера, минуя кэш менеджера
+	APIReader client.Reader
+
+	// Период между проходами сборщика
+	Interval time.Duration
+	// Сколько времени namespace должен оставаться сиротой до удаления
+	GracePeriod time.Duration
+	// Только сообщать о найденных сиротах, ничего не удаляя
+	DryRun bool
+
+	log logr.Logger
+	// Время, когда namespace впервые был замечен сиротой
+	orphanedSince map[string]time.Time
+}
+
+// SetupWithManager registers the garbage collector as a manager runnable.
+func (g *NamespaceGarbageCollector) SetupWithManager(mgr ctrl.Manager) error {
+	g.log = ctrl.Log.WithName("namespace-gc")
+	g.orphanedSince = map[string]time.Time{}
+	if g.APIReader == nil {
+		g.APIReader = mgr.GetAPIReader()
+	}
+	return mgr.Add(g)
+}
+
+// NeedLeaderElection реализует manager.LeaderElectionRunnable: сборщик работает только на лидере
+func (g *NamespaceGarbageCollector) NeedLeaderElection() bool {
+	return true
+}
+
+// Start запускает периодическую очистку до остановки менеджера
+func (g *NamespaceGarbageCollector) Start(ctx context.Context) error {
+	if g.Interval <= 0 {
+		g.log.Info("Сборщик осиротевших namespace отключен")
+		return nil
+	}
+	g.log.Info("Запуск сборщика осиротевших namespace", "interval", g.Interval.String(), "gracePeriod", g.GracePeriod.String(), "dryRun", g.DryRun)
+	wait.UntilWithContext(ctx, g.sweep, g.Interval)
+	return nil
+}
+
+func (g *NamespaceGarbageCollector) sweep(ctx context.Context) {
+	var namespaces v1.NamespaceList
+	err := g.List(ctx, &namespaces, client.HasLabels{defaultLabelKey})
+	if err != nil {
+		g.log.Error(err, "Ошибка при получении списка namespace")
+		return
+	}
+
+	var now = time.Now()
+	var seen = map[string]bool{}
+	for i := range namespaces.Items {
+		namespace := &namespaces.Items[i]
+		if namespace.GetDeletionTimestamp() != nil {
+			continue
+		}
+		var log = g.log.WithValues("namespace", namespace.GetName(), "dynamicnamespace", ownerKey(namespace))
+
+		orphaned, err := g.isOrphaned(ctx, namespace)
+		if err != nil {
+			log.Error(err, "Ошибка при проверке владельца namespace")
+			continue
+		}
+		if !orphaned {
+			continue
+		}
+		seen[namespace.GetName()] = true
+
+		since, ok := g.orphanedSince[namespace.GetName()]
+		if !ok {
+			since = now
+			g.orphanedSince[namespace.GetName()] = since
+			log.Info("DynamicNamespace владельца namespace не найден")
+		}
+		if now.Sub(since) < g.GracePeriod {
+			continue
+		}
+
+		if g.DryRun {
+			log.Info("Dry-run: осиротевший namespace был бы удален")
+			continue
+		}
+		err = g.Delete(ctx, namespace, client.Preconditions{UID: &namespace.UID})
+		if err != nil && !kerrors.IsNotFound(err) {
+			log.Error(err, "Ошибка при удалении осиротевшего namespace")
+			continue
+		}
+		log.Info("Удален осиротевший namespace")
+		delete(seen, namespace.GetName())
+	}
+
+	// Забываем namespace, которые перестали быть сиротами или были удалены
+	for name := range g.orphanedSince {
+		if !seen[name] {
+			delete(g.orphanedSince, name)
+		}
+	}
+}
+
+// isOrphaned проверяет, существует ли DynamicNamespace, на который указывает лейбл namespace.
+// Чтение идет мимо кэша, чтобы не удалить namespace из-за отстающего информера.
+func (g *NamespaceGarbageCollector) isOrphaned(ctx context.Context, namespace *v1.Namespace) (bool, error) {
+	owner, ok := parseOwnerLabel(namespace.GetLabels()[defaultLabelKey])
+	if !ok {
+		return false, fmt.Errorf("некорректное значение лейбла %v: %q", defaultLabelKey, namespace.GetLabels()[defaultLabelKey])
+	}
+
+	var resource platformv1.DynamicNamespace
+	err := g.APIReader.Get(ctx, owner, &resource)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// ownerLabelValue возвращает значение лейбла defaultLabelKey для ресурса: <namespace>.<name>
+func ownerLabelValue(resource *platformv1.DynamicNamespace) string {
+	return fmt.Sprintf("%s.%s", resource.Namespace, resource.Name)
+}
+
+// parseOwnerLabel разбирает значение лейбла defaultLabelKey обратно в ключ DynamicNamespace.
+// Имя namespace не может содержать точек, поэтому разбиваем по первой точке.
+func parseOwnerLabel(value string) (types.NamespacedName, bool) {
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return types.NamespacedName{}, false
+	}
+	return types.NamespacedName{Namespace: parts[0], Name: parts[1]}, true
+}
+
+// ownerKey возвращает ключ DynamicNamespace <namespace>/<имя> из лейбла владельца для логов
+func ownerKey(obj client.Object) string {
+	var value = obj.GetLabels()[defaultLabelKey]
+	owner, ok := parseOwnerLabel(value)
+	if !ok {
+		return value
+	}
+	return owner.String()
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// testScheme возвращает схему со встроенными типами и типами оператора
+func testScheme(t *testing.T) *runtime.Scheme {
+	var scheme = runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := platformv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func TestParseOwnerLabel(t *testing.T) {
+	var tests = []struct {
+		value string
+		want  types.NamespacedName
+		ok    bool
+	}{
+		{value: "team-a.feature", want: types.NamespacedName{Namespace: "team-a", Name: "feature"}, ok: true},
+		{value: "team-a.feature.v2", want: types.NamespacedName{Namespace: "team-a", Name: "feature.v2"}, ok: true},
+		{value: "team-a", ok: false},
+		{value: ".feature", ok: false},
+		{value: "team-a.", ok: false},
+		{value: "", ok: false},
+	}
+	for _, test := range tests {
+		got, ok := parseOwnerLabel(test.value)
+		if ok != test.ok || got != test.want {
+			t.Errorf("parseOwnerLabel(%q) = %v, %v; ожидалось %v, %v", test.value, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestGarbageCollectorSweep(t *testing.T) {
+	var owned = func(name string, owner string) *v1.Namespace {
+		return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			UID:    types.UID(name),
+			Labels: map[string]string{defaultLabelKey: owner},
+		}}
+	}
+	var resource = &platformv1.DynamicNamespace{ObjectMeta: metav1.ObjectMeta{Name: "alive", Namespace: "team-a"}}
+
+	var tests = []struct {
+		name        string
+		namespace   *v1.Namespace
+		gracePeriod time.Duration
+		dryRun      bool
+		deleted     bool
+	}{
+		{name: "сирота удаляется", namespace: owned("orphan", "team-a.gone"), deleted: true},
+		{name: "владелец существует", namespace: owned("alive", "team-a.alive"), deleted: false},
+		{name: "некорректный лейбл", namespace: owned("broken", "broken"), deleted: false},
+		{name: "период ожидания не истек", namespace: owned("orphan", "team-a.gone"), gracePeriod: time.Hour, deleted: false},
+		{name: "dry-run", namespace: owned("orphan", "team-a.gone"), dryRun: true, deleted: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var c = fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(test.namespace, resource.DeepCopy()).Build()
+			var g = &NamespaceGarbageCollector{
+				Client:        c,
+				APIReader:     c,
+				GracePeriod:   test.gracePeriod,
+				DryRun:        test.dryRun,
+				log:           logr.Discard(),
+				orphanedSince: map[string]time.Time{},
+			}
+			g.sweep(context.Background())
+
+			err := c.Get(context.Background(), client.ObjectKeyFromObject(test.namespace), &v1.Namespace{})
+			if deleted := kerrors.IsNotFound(err); deleted != test.deleted {
+				t.Errorf("namespace удален: %v, ожидалось %v (err: %v)", deleted, test.deleted, err)
+			}
+		})
+	}
+}
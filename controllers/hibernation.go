@@ -0,0 +1,273 @@
+package controllers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// originalReplicasAnnotation хранит количество реплик workload до перехода в спящий режим
+var originalReplicasAnnotation = platformv1.GroupVersion.Group + "/original-replicas"
+
+// weekdays сопоставляет дни недели из спецификации с time.Weekday
+var weekdays = map[platformv1.Weekday]time.Weekday{
+	"Sun": time.Sunday,
+	"Mon": time.Monday,
+	"Tue": time.Tuesday,
+	"Wed": time.Wednesday,
+	"Thu": time.Thursday,
+	"Fri": time.Friday,
+	"Sat": time.Saturday,
+}
+
+// reconcileHibernation усыпляет или будит окружение в зависимости от spec.suspended и расписания.
+// Возвращает признак спящего режима и время до следующего перехода по расписанию (0, если расписания нет).
+func (r *DynamicNamespaceReconciler) reconcileHibernation(ctx context.Context, resource *platformv1.DynamicNamespace) (bool, time.Duration, error) {
+	var suspended = resource.Spec.Suspended
+	var requeueAfter time.Duration
+
+	if resource.Spec.SleepSchedule != nil {
+		asleep, next, err := sleepWindow(resource.Spec.SleepSchedule, time.Now())
+		if err != nil {
+			return false, 0, err
+		}
+		suspended = suspended || asleep
+		requeueAfter = time.Until(next)
+	}
+
+	// Спящий режим распространяется на дочерние namespace
+	for _, namespace := range familyNamespaces(resource) {
+		if suspended {
+			err := r.scaleDown(ctx, namespace)
+			if err != nil {
+				return suspended, requeueAfter, err
+			}
+			continue
+		}
+		woke, err := r.scaleUp(ctx, namespace)
+		if err == nil && woke {
+			err = r.markWake(ctx, namespace, time.Now())
+		}
+		if err != nil {
+			return suspended, requeueAfter, err
+		}
+	}
+	return suspended, requeueAfter, nil
+}
+
+// markWake запоминает время пробуждения на namespace: поды, пересозданные после него,
+// не продлевают окружение с idleTimeout
+func (r *DynamicNamespaceReconciler) markWake(ctx context.Context, name string, now time.Time) error {
+	var namespace v1.Namespace
+	err := r.Get(ctx, types.NamespacedName{Name: name}, &namespace)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	var patch = client.MergeFrom(namespace.DeepCopy())
+	setAnnotation(&namespace, wokeAtAnnotation, now.UTC().Format(time.RFC3339))
+	return r.Patch(ctx, &namespace, patch, client.FieldOwner(fieldManager))
+}
+
+// scaleDown масштабирует все Deployment и StatefulSet namespace до нуля, запоминая исходные реплики в аннотации
+func (r *DynamicNamespaceReconciler) scaleDown(ctx context.Context, namespace string) error {
+	workloads, err := r.listWorkloads(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	for _, workload := range workloads {
+		var replicas = workloadReplicas(workload)
+		if _, ok := workload.GetAnnotations()[originalReplicasAnnotation]; ok || replicas == 0 {
+			continue
+		}
+
+		var patch = client.MergeFrom(workload.DeepCopyObject().(client.Object))
+		setAnnotation(workload, originalReplicasAnnotation, strconv.Itoa(int(replicas)))
+		setWorkloadReplicas(workload, 0)
+		err = r.Patch(ctx, workload, patch, client.FieldOwner(fieldManager))
+		if err != nil {
+			return err
+		}
+		ctrllog.FromContext(ctx).Info("Нагрузка усыплена", "kind", workload.GetObjectKind().GroupVersionKind().Kind, "name", workload.GetName(), "namespace", namespace, "replicas", replicas)
+	}
+	return nil
+}
+
+// scaleUp восстанавливает реплики, сохраненные при переходе в спящий режим.
+// Сообщает, была ли разбужена хотя бы одна нагрузка
+func (r *DynamicNamespaceReconciler) scaleUp(ctx context.Context, namespace string) (bool, error) {
+	workloads, err := r.listWorkloads(ctx, namespace)
+	if err != nil {
+		return false, err
+	}
+	var woke bool
+	for _, workload := range workloads {
+		value, ok := workload.GetAnnotations()[originalReplicasAnnotation]
+		if !ok {
+			continue
+		}
+		replicas, err := strconv.Atoi(value)
+		if err != nil {
+			return woke, messages.Errorf(messages.AnnotationInvalid, originalReplicasAnnotation, workload.GetName()+"."+namespace, err)
+		}
+
+		var patch = client.MergeFrom(workload.DeepCopyObject().(client.Object))
+		annotations := workload.GetAnnotations()
+		delete(annotations, originalReplicasAnnotation)
+		workload.SetAnnotations(annotations)
+		setWorkloadReplicas(workload, int32(replicas))
+		err = r.Patch(ctx, workload, patch, client.FieldOwner(fieldManager))
+		if err != nil {
+			return woke, err
+		}
+		woke = true
+		ctrllog.FromContext(ctx).Info("Нагрузка разбужена", "kind", workload.GetObjectKind().GroupVersionKind().Kind, "name", workload.GetName(), "namespace", namespace, "replicas", replicas)
+	}
+	return woke, nil
+}
+
+// listWorkloads читает Deployment и StatefulSet напрямую из API-сервера,
+// чтобы не держать в кэше менеджера все workload кластера
+func (r *DynamicNamespaceReconciler) listWorkloads(ctx context.Context, namespace string) ([]client.Object, error) {
+	var workloads []client.Object
+
+	var deployments appsv1.DeploymentList
+	err := r.APIReader.List(ctx, &deployments, client.InNamespace(namespace))
+	if err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		deployments.Items[i].SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind("Deployment"))
+		workloads = append(workloads, &deployments.Items[i])
+	}
+
+	var statefulSets appsv1.StatefulSetList
+	err = r.APIReader.List(ctx, &statefulSets, client.InNamespace(namespace))
+	if err != nil {
+		return nil, err
+	}
+	for i := range statefulSets.Items {
+		statefulSets.Items[i].SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind("StatefulSet"))
+		workloads = append(workloads, &statefulSets.Items[i])
+	}
+
+	return workloads, nil
+}
+
+func workloadReplicas(workload client.Object) int32 {
+	var replicas *int32
+	switch w := workload.(type) {
+	case *appsv1.Deployment:
+		replicas = w.Spec.Replicas
+	case *appsv1.StatefulSet:
+		replicas = w.Spec.Replicas
+	}
+	// Неуказанное количество реплик по умолчанию равно единице
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+func setWorkloadReplicas(workload client.Object, replicas int32) {
+	switch w := workload.(type) {
+	case *appsv1.Deployment:
+		w.Spec.Replicas = &replicas
+	case *appsv1.StatefulSet:
+		w.Spec.Replicas = &replicas
+	}
+}
+
+func setAnnotation(obj client.Object, key, value string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[key] = value
+	obj.SetAnnotations(annotations)
+}
+
+// sleepWindow определяет, попадает ли момент now в окно сна, и когда произойдет следующий переход.
+// Окно, начавшееся в один из дней schedule.Days, длится до ближайшего времени пробуждения,
+// в том числе если оно наступает уже на следующий день.
+func sleepWindow(schedule *platformv1.SleepSchedule, now time.Time) (bool, time.Time, error) {
+	var location = time.UTC
+	if schedule.TimeZone != "" {
+		var err error
+		location, err = time.LoadLocation(schedule.TimeZone)
+		if err != nil {
+			return false, time.Time{}, messages.Errorf(messages.TimeZoneInvalid, schedule.TimeZone, err)
+		}
+	}
+	start, err := parseClock(schedule.Start)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	end, err := parseClock(schedule.End)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	if start == end {
+		return false, time.Time{}, messages.Errorf(messages.SleepScheduleEmpty, schedule.Start)
+	}
+
+	now = now.In(location)
+	var days = map[time.Weekday]bool{}
+	for _, day := range schedule.Days {
+		days[weekdays[day]] = true
+	}
+	var startsOn = func(day time.Time) bool {
+		return len(days) == 0 || days[day.Weekday()]
+	}
+
+	// Проверяем окна, начинающиеся вчера, сегодня и завтра
+	var asleep bool
+	var next time.Time
+	for offset := -1; offset <= 1; offset++ {
+		var day = now.AddDate(0, 0, offset)
+		var windowStart = atClock(day, start)
+		var windowEnd = atClock(day, end)
+		if end < start {
+			windowEnd = atClock(day.AddDate(0, 0, 1), end)
+		}
+		if !startsOn(day) {
+			continue
+		}
+		if !now.Before(windowStart) && now.Before(windowEnd) {
+			asleep = true
+			next = windowEnd
+			break
+		}
+		if now.Before(windowStart) && (next.IsZero() || windowStart.Before(next)) {
+			next = windowStart
+		}
+	}
+	if next.IsZero() {
+		// Ближайшее окно дальше завтрашнего дня: перепроверяем в следующую полночь
+		next = atClock(now.AddDate(0, 0, 1), 0)
+	}
+	return asleep, next, nil
+}
+
+// parseClock переводит HH:MM в смещение от начала суток
+func parseClock(value string) (time.Duration, error) {
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, messages.Errorf(messages.ClockInvalid, value, err)
+	}
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, nil
+}
+
+// atClock возвращает время HH:MM дня day в его часовом поясе. Время собирается через time.Date,
+// а не прибавлением к полуночи, чтобы в дни перехода на летнее время не сдвигаться на час
+func atClock(day time.Time, clock time.Duration) time.Time {
+	var hours, minutes = int(clock / time.Hour), int(clock % time.Hour / time.Minute)
+	return time.Date(day.Year(), day.Month(), day.Day(), hours, minutes, 0, 0, day.Location())
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+)
+
+func TestSleepWindow(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var night = platformv1.SleepSchedule{Start: "20:00", End: "08:00", TimeZone: "Europe/Berlin"}
+	var weekdays = platformv1.SleepSchedule{Start: "20:00", End: "08:00", TimeZone: "Europe/Berlin", Days: []platformv1.Weekday{"Mon"}}
+
+	var tests = []struct {
+		name     string
+		schedule platformv1.SleepSchedule
+		now      time.Time
+		asleep   bool
+		next     time.Time
+		invalid  bool
+	}{
+		{
+			name:     "ночью окружение спит до утра",
+			schedule: night,
+			now:      time.Date(2024, 3, 12, 23, 0, 0, 0, berlin),
+			asleep:   true,
+			next:     time.Date(2024, 3, 13, 8, 0, 0, 0, berlin),
+		},
+		{
+			name:     "днем окружение работает до вечера",
+			schedule: night,
+			now:      time.Date(2024, 3, 12, 12, 0, 0, 0, berlin),
+			asleep:   false,
+			next:     time.Date(2024, 3, 12, 20, 0, 0, 0, berlin),
+		},
+		{
+			name:     "переход на летнее время: пробуждение в 08:00 по местному времени",
+			schedule: night,
+			now:      time.Date(2024, 3, 31, 8, 30, 0, 0, berlin),
+			asleep:   false,
+			next:     time.Date(2024, 3, 31, 20, 0, 0, 0, berlin),
+		},
+		{
+			name:     "переход на зимнее время: окно заканчивается в 08:00",
+			schedule: night,
+			now:      time.Date(2024, 10, 27, 7, 30, 0, 0, berlin),
+			asleep:   true,
+			next:     time.Date(2024, 10, 27, 8, 0, 0, 0, berlin),
+		},
+		{
+			name:     "окно, начавшееся в понедельник, длится до вторника",
+			schedule: weekdays,
+			now:      time.Date(2024, 3, 12, 7, 0, 0, 0, berlin),
+			asleep:   true,
+			next:     time.Date(2024, 3, 12, 8, 0, 0, 0, berlin),
+		},
+		{
+			name:     "во вторник вечером окно не начинается",
+			schedule: weekdays,
+			now:      time.Date(2024, 3, 12, 21, 0, 0, 0, berlin),
+			asleep:   false,
+			next:     time.Date(2024, 3, 13, 0, 0, 0, 0, berlin),
+		},
+		{
+			name:     "совпадающее время засыпания и пробуждения",
+			schedule: platformv1.SleepSchedule{Start: "08:00", End: "08:00"},
+			invalid:  true,
+		},
+		{
+			name:     "некорректный часовой пояс",
+			schedule: platformv1.SleepSchedule{Start: "20:00", End: "08:00", TimeZone: "Mars/Olympus"},
+			invalid:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			asleep, next, err := sleepWindow(&test.schedule, test.now)
+			if test.invalid {
+				if err == nil {
+					t.Fatal("ожидалась ошибка")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if asleep != test.asleep || !next.Equal(test.next) {
+				t.Errorf("sleepWindow = %v, %v; ожидалось %v, %v", asleep, next, test.asleep, test.next)
+			}
+		})
+	}
+}
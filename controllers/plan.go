@@ -0,0 +1,433 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var (
+	// dryRunAnnotation включает режим dry-run для одного ресурса: изменения планируются, но не применяются
+	dryRunAnnotation = platformv1.GroupVersion.Group + "/dry-run"
+)
+
+const (
+	// conditionPlanned выставляется в режиме dry-run: True, если обработка ресурса внесла бы изменения
+	conditionPlanned = "Planned"
+
+	// Ограничение длины сообщения события в API-сервере
+	maxEventMessageLength = 1024
+)
+
+// dryRun сообщает, что изменения ресурса нужно только спланировать: глобально (--dry-run)
+// или аннотацией platform.cloudnative.space/dry-run=true
+func (r *DynamicNamespaceReconciler) dryRun(resource *platformv1.DynamicNamespace) bool {
+	return r.DryRun || resource.GetAnnotations()[dryRunAnnotation] == "true"
+}
+
+// planChanges вычисляет изменения namespace, квот, RoleBinding и сетевых правил окружения, а также
+// спящий режим, автомасштабирование квоты и удаление по сроку жизни, ничего не применяя
+func (r *DynamicNamespaceReconciler) planChanges(ctx context.Context, resource *platformv1.DynamicNamespace, now time.Time) ([]string, error) {
+	var plan []string
+	if resource.Spec.Pool != "" && resource.Status.Namespace == "" {
+		plan = append(plan, r.Settings.Load().Language.Text(messages.New(messages.PlanPoolClaim, resource.Spec.Pool)))
+	}
+
+	namespace, err := generateNamespace(resource)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := r.planObject(ctx, namespace, &v1.Namespace{})
+	if err != nil {
+		return nil, err
+	}
+	plan = append(plan, changes...)
+
+	quotas, err := generateResourceQuotas(resource)
+	if err != nil {
+		return nil, err
+	}
+	var desiredQuotas = map[string]bool{}
+	for _, quota := range quotas {
+		changes, err = r.planObject(ctx, quota, &v1.ResourceQuota{})
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, changes...)
+		desiredQuotas[quota.Name] = true
+	}
+	var existingQuotas v1.ResourceQuotaList
+	err = r.List(ctx, &existingQuotas, client.InNamespace(targetNamespace(resource)), client.MatchingLabels(ownerLabels(resource)))
+	if err != nil {
+		return nil, err
+	}
+	for i := range existingQuotas.Items {
+		if !desiredQuotas[existingQuotas.Items[i].Name] {
+			plan = append(plan, planDelete("ResourceQuota", &existingQuotas.Items[i]))
+		}
+	}
+
+	changes, err = r.planNamespaceAccess(ctx, resource, targetNamespace(resource))
+	if err != nil {
+		return nil, err
+	}
+	plan = append(plan, changes...)
+
+	var desiredChildren = map[string]bool{}
+	for i := range resource.Spec.Children {
+		var child = &resource.Spec.Children[i]
+		childNamespace, err := generateChildNamespace(resource, child)
+		if err != nil {
+			return nil, err
+		}
+		changes, err = r.planObject(ctx, childNamespace, &v1.Namespace{})
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, changes...)
+
+		quota, err := generateChildResourceQuota(resource, child)
+		if err != nil {
+			return nil, err
+		}
+		changes, err = r.planObject(ctx, quota, &v1.ResourceQuota{})
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, changes...)
+
+		changes, err = r.planNamespaceAccess(ctx, resource, childNamespace.Name)
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, changes...)
+		desiredChildren[childNamespace.Name] = true
+	}
+	children, err := r.listChildren(ctx, resource)
+	if err != nil {
+		return nil, err
+	}
+	for i := range children {
+		if !desiredChildren[children[i].Name] {
+			plan = append(plan, planDelete("Namespace", &children[i]))
+		}
+	}
+
+	changes, err = r.planHibernation(ctx, resource, now)
+	if err != nil {
+		return nil, err
+	}
+	plan = append(plan, changes...)
+
+	changes, err = r.planQuotaAutoscaling(ctx, resource, now)
+	if err != nil {
+		return nil, err
+	}
+	plan = append(plan, changes...)
+
+	expiration, err := r.evaluateExpiration(ctx, resource, now)
+	if err != nil {
+		return nil, err
+	}
+	// Без dry-run окружение сначала получило бы предупреждение, поэтому план показывает удаление
+	// уже в период предупреждения
+	if expiration != nil && expiration.warning(now) {
+		var language = r.Settings.Load().Language
+		plan = append(plan, fmt.Sprintf("%v: %v", planDelete("DynamicNamespace", resource),
+			language.Text(messages.New(messages.PlanExpires, expiration.expiresAt.UTC().Format(time.RFC3339), expiration.reason))))
+	}
+	return plan, nil
+}
+
+// planHibernation планирует изменение реплик нагрузки при переходе в спящий режим и пробуждении
+func (r *DynamicNamespaceReconciler) planHibernation(ctx context.Context, resource *platformv1.DynamicNamespace, now time.Time) ([]string, error) {
+	var suspended = resource.Spec.Suspended
+	if resource.Spec.SleepSchedule != nil {
+		asleep, _, err := sleepWindow(resource.Spec.SleepSchedule, now)
+		if err != nil {
+			return nil, err
+		}
+		suspended = suspended || asleep
+	}
+	var language = r.Settings.Load().Language
+	var plan []string
+	for _, namespace := range familyNamespaces(resource) {
+		workloads, err := r.listWorkloads(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, workload := range workloads {
+			var kind = workload.GetObjectKind().GroupVersionKind().Kind
+			var replicas = workloadReplicas(workload)
+			value, hibernated := workload.GetAnnotations()[originalReplicasAnnotation]
+			if suspended && !hibernated && replicas > 0 {
+				plan = append(plan, fmt.Sprintf("~ %v %v: spec.replicas: %v -> 0 %v", kind, objectName(workload), replicas, language.Text(messages.New(messages.PlanSleep))))
+			}
+			if !suspended && hibernated {
+				plan = append(plan, fmt.Sprintf("~ %v %v: spec.replicas: %v -> %v %v", kind, objectName(workload), replicas, value, language.Text(messages.New(messages.PlanWake))))
+			}
+		}
+	}
+	return plan, nil
+}
+
+// planQuotaAutoscaling планирует изменение лимитов квоты по текущему использованию. Отметки начала
+// высокого и низкого использования в dry-run не сохраняются, поэтому окно отсчитывается от последней обработки без dry-run
+func (r *DynamicNamespaceReconciler) planQuotaAutoscaling(ctx context.Context, resource *platformv1.DynamicNamespace, now time.Time) ([]string, error) {
+	var policy = resource.Spec.QuotaAutoscaling
+	if policy == nil {
+		return nil, nil
+	}
+	usage, err := r.quotaUsage(ctx, resource)
+	if err != nil || usage == nil {
+		return nil, err
+	}
+	base, err := parentQuota(resource)
+	if err != nil {
+		return nil, err
+	}
+	var state = resource.Status.QuotaAutoscaling.DeepCopy()
+	if state == nil {
+		state = &platformv1.QuotaAutoscalingStatus{}
+	}
+	if state.HighSince == nil {
+		state.HighSince = map[v1.ResourceName]metav1.Time{}
+	}
+	if state.LowSince == nil {
+		state.LowSince = map[v1.ResourceName]metav1.Time{}
+	}
+	changes, _ := scaleQuota(policy, base, usage, state, now)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].name < changes[j].name })
+
+	quota, err := generateResourceQuota(resource)
+	if err != nil {
+		return nil, err
+	}
+	var language = r.Settings.Load().Language
+	var plan []string
+	for _, change := range changes {
+		plan = append(plan, fmt.Sprintf("~ ResourceQuota %v: spec.hard.%v: %v -> %v %v", objectName(quota), change.name,
+			change.from.String(), change.to.String(), language.Text(messages.New(messages.PlanQuotaScaled))))
+	}
+	return plan, nil
+}
+
+// planNamespaceAccess планирует RoleBinding и NetworkPolicy namespace окружения
+func (r *DynamicNamespaceReconciler) planNamespaceAccess(ctx context.Context, resource *platformv1.DynamicNamespace, namespace string) ([]string, error) {
+	var plan []string
+	roleBinding, err := generateRoleBinding(resource)
+	if err != nil {
+		return nil, err
+	}
+	roleBinding.Namespace = namespace
+	var existingRoleBinding rbacv1.RoleBinding
+	err = r.Get(ctx, client.ObjectKeyFromObject(roleBinding), &existingRoleBinding)
+	if err != nil && !kerrors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil && existingRoleBinding.RoleRef != roleBinding.RoleRef {
+		// roleRef неизменяем: RoleBinding будет пересоздана
+		plan = append(plan, fmt.Sprintf("~ RoleBinding %v: roleRef.name: %v -> %v %v", objectName(roleBinding), existingRoleBinding.RoleRef.Name, roleBinding.RoleRef.Name, r.Settings.Load().Language.Text(messages.New(messages.PlanRecreate))))
+	} else {
+		changes, err := r.planObject(ctx, roleBinding, &rbacv1.RoleBinding{})
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, changes...)
+	}
+
+	networkPolicy, err := generateNetworkPolicy(resource, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if resource.Spec.IsolateNetwork {
+		changes, err := r.planObject(ctx, networkPolicy, &networkingv1.NetworkPolicy{})
+		if err != nil {
+			return nil, err
+		}
+		return append(plan, changes...), nil
+	}
+	var existingNetworkPolicy networkingv1.NetworkPolicy
+	err = r.Get(ctx, client.ObjectKeyFromObject(networkPolicy), &existingNetworkPolicy)
+	if err != nil {
+		return plan, client.IgnoreNotFound(err)
+	}
+	if existingNetworkPolicy.GetLabels()[defaultLabelKey] == ownerLabelValue(resource) {
+		plan = append(plan, planDelete("NetworkPolicy", &existingNetworkPolicy))
+	}
+	return plan, nil
+}
+
+// planFinalization вычисляет, что удалила бы финализация ресурса
+func (r *DynamicNamespaceReconciler) planFinalization(ctx context.Context, resource *platformv1.DynamicNamespace) ([]string, error) {
+	var plan []string
+	children, err := r.listChildren(ctx, resource)
+	if err != nil {
+		return nil, err
+	}
+	for i := range children {
+		plan = append(plan, planDelete("Namespace", &children[i]))
+	}
+	var namespace v1.Namespace
+	err = r.Get(ctx, client.ObjectKey{Name: targetNamespace(resource)}, &namespace)
+	if err != nil {
+		return plan, client.IgnoreNotFound(err)
+	}
+	if namespace.GetLabels()[defaultLabelKey] == ownerLabelValue(resource) {
+		plan = append(plan, planDelete("Namespace", &namespace))
+	}
+	return plan, nil
+}
+
+// planObject сравнивает сгенерированный объект с существующим. Сравниваются только поля, которые
+// задает генератор: остальные поля server-side apply не затрагивает
+func (r *DynamicNamespaceReconciler) planObject(ctx context.Context, desired client.Object, existing client.Object) ([]string, error) {
+	var kind = desired.GetObjectKind().GroupVersionKind().Kind
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), existing)
+	if kerrors.IsNotFound(err) {
+		return []string{fmt.Sprintf("+ %v %v", kind, objectName(desired))}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	desiredFields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired)
+	if err != nil {
+		return nil, err
+	}
+	existingFields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(existing)
+	if err != nil {
+		return nil, err
+	}
+	var language = r.Settings.Load().Language
+	var changes []string
+	for _, field := range []string{"metadata", "spec", "subjects", "roleRef"} {
+		var desiredValue, ok = desiredFields[field]
+		if !ok {
+			continue
+		}
+		if field == "metadata" {
+			var metadata, _ = desiredValue.(map[string]interface{})
+			desiredValue = map[string]interface{}{"labels": metadata["labels"], "annotations": metadata["annotations"]}
+		}
+		changes = append(changes, diffFields(language, field, desiredValue, existingFields[field])...)
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return []string{fmt.Sprintf("~ %v %v: %v", kind, objectName(desired), strings.Join(changes, "; "))}, nil
+}
+
+// diffFields перечисляет поля desired, значения которых отличаются от existing
+func diffFields(language messages.Language, path string, desired interface{}, existing interface{}) []string {
+	if desired == nil {
+		return nil
+	}
+	desiredMap, ok := desired.(map[string]interface{})
+	if !ok {
+		if reflect.DeepEqual(desired, existing) {
+			return nil
+		}
+		return []string{fmt.Sprintf("%v: %v -> %v", path, formatValue(language, existing), formatValue(language, desired))}
+	}
+	existingMap, _ := existing.(map[string]interface{})
+	var keys []string
+	for key := range desiredMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var changes []string
+	for _, key := range keys {
+		changes = append(changes, diffFields(language, path+"."+key, desiredMap[key], existingMap[key])...)
+	}
+	return changes
+}
+
+func formatValue(language messages.Language, value interface{}) string {
+	if value == nil {
+		return language.Text(messages.New(messages.PlanNoValue))
+	}
+	if text, ok := value.(string); ok {
+		return text
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
+
+func planDelete(kind string, obj client.Object) string {
+	return fmt.Sprintf("- %v %v", kind, objectName(obj))
+}
+
+func objectName(obj client.Object) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// reportPlan записывает план в статус и, если он изменился, в событие ресурса
+func (r *DynamicNamespaceReconciler) reportPlan(ctx context.Context, resource *platformv1.DynamicNamespace, plan []string) {
+	var language = r.Settings.Load().Language
+	var status = resource.Status.DeepCopy()
+	status.Plan = plan
+	// Код окружения, которое уже обрабатывалось, не меняется, чтобы выключение dry-run
+	// не рассылало уведомления о смене статуса
+	if status.Code == "" {
+		status.Code = "PLANNED"
+		status.Reason = string(messages.Planned)
+		status.Message = language.Text(messages.New(messages.Planned))
+	}
+	var condition = metav1.Condition{
+		Type:               conditionPlanned,
+		Status:             metav1.ConditionTrue,
+		Reason:             string(messages.ChangesPending),
+		Message:            language.Text(messages.New(messages.ChangesPending, len(plan))),
+		ObservedGeneration: resource.GetGeneration(),
+	}
+	if len(plan) == 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = string(messages.UpToDate)
+		condition.Message = language.Text(messages.New(messages.UpToDate))
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	if len(plan) > 0 && !reflect.DeepEqual(plan, resource.Status.Plan) {
+		ctrllog.FromContext(ctx).Info("Dry-run: план изменений ресурса", "plan", plan)
+		var message = strings.Join(plan, "\n")
+		if len(message) > maxEventMessageLength {
+			var cut = maxEventMessageLength - len("...")
+			for cut > 0 && !utf8.RuneStart(message[cut]) {
+				cut--
+			}
+			message = message[:cut] + "..."
+		}
+		r.Recorder.Event(resource, v1.EventTypeNormal, "Planned", message)
+	}
+	r.updateStatus(ctx, resource, status)
+}
+
+// clearPlan убирает из статуса план, оставшийся после выключения dry-run
+func clearPlan(status *platformv1.DynamicNamespaceStatus) {
+	status.Plan = nil
+	meta.RemoveStatusCondition(&status.Conditions, conditionPlanned)
+}
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPlanChanges(t *testing.T) {
+	var now = time.Date(2024, 3, 12, 12, 0, 0, 0, time.UTC)
+	var replicas = int32(2)
+	var environment = &platformv1.DynamicNamespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "team-a", CreationTimestamp: metav1.NewTime(now.Add(-48 * time.Hour))},
+		Spec: platformv1.DynamicNamespaceSpec{
+			CreateQuota: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")},
+			Role:        "edit",
+			Suspended:   true,
+			Expiration:  &platformv1.ExpirationPolicy{TTL: &metav1.Duration{Duration: 24 * time.Hour}},
+			QuotaAutoscaling: &platformv1.QuotaAutoscaling{
+				Max: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")},
+			},
+		},
+		Status: platformv1.DynamicNamespaceStatus{
+			Namespace: "feature",
+			QuotaAutoscaling: &platformv1.QuotaAutoscalingStatus{
+				HighSince: map[v1.ResourceName]metav1.Time{v1.ResourceCPU: metav1.NewTime(now.Add(-time.Hour))},
+			},
+		},
+	}
+	var owner = map[string]string{defaultLabelKey: "team-a.feature"}
+	var objects = []client.Object{
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "feature", Labels: owner}},
+		&v1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "feature-resourcequota", Namespace: "feature", Labels: owner},
+			Spec:       v1.ResourceQuotaSpec{Hard: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}},
+			Status: v1.ResourceQuotaStatus{
+				Hard: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")},
+				Used: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1950m")},
+			},
+		},
+		&v1.ResourceQuota{ObjectMeta: metav1.ObjectMeta{Name: "feature-old", Namespace: "feature", Labels: owner}},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "feature"},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		},
+	}
+	var c = fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(objects...).Build()
+	var r = &DynamicNamespaceReconciler{Client: c, APIReader: c}
+
+	plan, err := r.planChanges(context.Background(), environment, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want = []string{
+		"- ResourceQuota feature/feature-old",
+		"+ RoleBinding feature/feature-rolebinding",
+		"~ Deployment feature/api: spec.replicas: 2 -> 0 (hibernation)",
+		"~ ResourceQuota feature/feature-resourcequota: spec.hard.cpu: 2 -> 3 (quota autoscaling)",
+		"- DynamicNamespace team-a/feature: deleted at 2024-03-13T12:00:00Z by TTL",
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("план:\n%v\nожидалось:\n%v", strings.Join(plan, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Разбуженное окружение возвращает реплики, сохраненные при усыплении
+	var awake = environment.DeepCopy()
+	awake.Spec.Suspended = false
+	awake.Spec.Expiration = nil
+	awake.Spec.QuotaAutoscaling = nil
+	awake.Status.QuotaAutoscaling = nil
+	var sleeping = &appsv1.Deployment{}
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: "feature", Name: "api"}, sleeping); err != nil {
+		t.Fatal(err)
+	}
+	var zero = int32(0)
+	sleeping.Spec.Replicas = &zero
+	sleeping.Annotations = map[string]string{originalReplicasAnnotation: "2"}
+	if err := c.Update(context.Background(), sleeping); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = r.planChanges(context.Background(), awake, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsString(plan, "~ Deployment feature/api: spec.replicas: 0 -> 2 (wake-up)") {
+		t.Errorf("план без пробуждения:\n%v", strings.Join(plan, "\n"))
+	}
+}
+
+func TestPoolDryRun(t *testing.T) {
+	var created = time.Date(2024, 3, 12, 12, 0, 0, 0, time.UTC)
+	var poolNamespace = func(name string, age time.Duration) *v1.Namespace {
+		return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Labels:            map[string]string{poolLabelKey: "team-a.warm"},
+			CreationTimestamp: metav1.NewTime(created.Add(-age)),
+		}}
+	}
+	var tests = []struct {
+		name     string
+		size     int32
+		deleted  bool
+		existing []client.Object
+		plan     []string
+	}{
+		{name: "пул дополняется", size: 3, existing: []client.Object{poolNamespace("warm-a", time.Hour)},
+			plan: []string{"+ Namespace warm-<random> (2 of 3)", "+ Namespace warm-<random> (3 of 3)"}},
+		{name: "лишние namespace удаляются", size: 1, existing: []client.Object{poolNamespace("warm-a", 2*time.Hour), poolNamespace("warm-b", time.Hour)},
+			plan: []string{"- Namespace warm-b"}},
+		{name: "пул заполнен", size: 1, existing: []client.Object{poolNamespace("warm-a", time.Hour)}},
+		{name: "удаление пула", size: 1, deleted: true, existing: []client.Object{poolNamespace("warm-a", time.Hour)},
+			plan: []string{"- Namespace warm-a"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var pool = &platformv1.DynamicNamespacePool{
+				ObjectMeta: metav1.ObjectMeta{Name: "warm", Namespace: "team-a", Finalizers: []string{defaultFinalizer}},
+				Spec:       platformv1.DynamicNamespacePoolSpec{Size: test.size},
+			}
+			if test.deleted {
+				var deleted = metav1.NewTime(created)
+				pool.DeletionTimestamp = &deleted
+			}
+			var objects = append([]client.Object{pool}, test.existing...)
+			var c = fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(objects...).Build()
+			var r = &DynamicNamespacePoolReconciler{Client: c, APIReader: c, DryRun: true}
+
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "team-a", Name: "warm"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var namespaces v1.NamespaceList
+			if err := c.List(context.Background(), &namespaces); err != nil {
+				t.Fatal(err)
+			}
+			if len(namespaces.Items) != len(test.existing) {
+				t.Errorf("namespace: %v, ожидалось без изменений", len(namespaces.Items))
+			}
+			var stored platformv1.DynamicNamespacePool
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(pool), &stored); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(stored.Status.Plan, test.plan) {
+				t.Errorf("план %v, ожидалось %v", stored.Status.Plan, test.plan)
+			}
+			if len(stored.Finalizers) == 0 {
+				t.Error("финализатор снят в режиме dry-run")
+			}
+		})
+	}
+}
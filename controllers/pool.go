@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// claimFromPool забирает свободный namespace из пула spec.pool и записывает его имя в status.namespace.
+// Квота и RoleBinding пула удаляются: их заменяют квота и RoleBinding окружения, а лейблы, аннотации и
+// объекты заполнения остаются. Если свободных namespace нет, status.namespace остается пустым и имя
+// namespace назначается по шаблону, как без пула.
+func (r *DynamicNamespaceReconciler) claimFromPool(ctx context.Context, resource *platformv1.DynamicNamespace) error {
+	var owner = ownerLabelValue(resource)
+
+	// Namespace мог быть захвачен в предыдущем цикле, но статус не успел сохраниться
+	var claimed v1.NamespaceList
+	err := r.List(ctx, &claimed, client.MatchingLabels{defaultLabelKey: owner})
+	if err != nil {
+		return err
+	}
+	for _, namespace := range claimed.Items {
+		_, child := namespace.GetLabels()[parentLabelKey]
+		if namespace.GetDeletionTimestamp() == nil && !child {
+			resource.Status.Namespace = namespace.GetName()
+			return r.saveStatus(ctx, resource)
+		}
+	}
+
+	var pool platformv1.DynamicNamespacePool
+	err = r.Get(ctx, types.NamespacedName{Namespace: resource.Namespace, Name: resource.Spec.Pool}, &pool)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return messages.Errorf(messages.PoolNotFound, resource.Spec.Pool)
+		}
+		return err
+	}
+
+	free, err := listPoolNamespaces(ctx, r.Client, &pool)
+	if err != nil {
+		return err
+	}
+	for i := range free {
+		var namespace = &free[i]
+
+		// Оптимистичная блокировка: namespace достанется только одному DynamicNamespace
+		var patch = client.MergeFromWithOptions(namespace.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		labels := namespace.GetLabels()
+		delete(labels, poolLabelKey)
+		labels[defaultLabelKey] = owner
+		namespace.SetLabels(labels)
+		err = r.Patch(ctx, namespace, patch)
+		if kerrors.IsConflict(err) || kerrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// Квота и доступ пула заменяются квотой и RoleBinding окружения
+		for _, obj := range []client.Object{&v1.ResourceQuota{}, &rbacv1.RoleBinding{}} {
+			err = r.DeleteAllOf(ctx, obj, client.InNamespace(namespace.GetName()), client.MatchingLabels{poolLabelKey: poolLabelValue(&pool)})
+			if err != nil {
+				return err
+			}
+		}
+
+		ctrllog.FromContext(ctx).Info("Namespace взят из пула", "namespace", namespace.GetName(), "dynamicnamespacepool", client.ObjectKeyFromObject(&pool).String())
+		r.Recorder.Event(resource, v1.EventTypeNormal, "ClaimedFromPool", r.Settings.Load().Language.Text(messages.New(messages.ClaimedFromPool, namespace.GetName(), pool.GetName())))
+		resource.Status.Namespace = namespace.GetName()
+		return r.saveStatus(ctx, resource)
+	}
+
+	ctrllog.FromContext(ctx).Info("В пуле нет свободных namespace, создаю новый по шаблону", "dynamicnamespacepool", client.ObjectKeyFromObject(&pool).String())
+	r.Recorder.Event(resource, v1.EventTypeWarning, "PoolEmpty", r.Settings.Load().Language.Text(messages.New(messages.PoolEmpty, pool.GetName())))
+	return nil
+}
@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestClaimFromPool(t *testing.T) {
+	var pool = &platformv1.DynamicNamespacePool{ObjectMeta: metav1.ObjectMeta{Name: "warm", Namespace: "team-a"}}
+	var poolLabels = map[string]string{poolLabelKey: "team-a.warm"}
+	var freeNamespace = &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "warm-x1",
+		Labels: map[string]string{poolLabelKey: "team-a.warm", "istio-injection": "enabled"},
+	}}
+	var poolObjects = []client.Object{
+		&v1.ResourceQuota{ObjectMeta: metav1.ObjectMeta{Name: poolQuotaName, Namespace: "warm-x1", Labels: poolLabels}},
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: poolRoleBindingName, Namespace: "warm-x1", Labels: poolLabels}},
+		&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "warm-x1", Labels: poolLabels}},
+	}
+
+	var tests = []struct {
+		name      string
+		objects   []client.Object
+		namespace string
+		reason    messages.Reason
+	}{
+		{name: "свободный namespace захватывается", objects: append([]client.Object{pool, freeNamespace}, poolObjects...), namespace: "warm-x1"},
+		{name: "пустой пул", objects: []client.Object{pool}},
+		{name: "пул не найден", reason: messages.PoolNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var resource = &platformv1.DynamicNamespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "team-a"},
+				Spec:       platformv1.DynamicNamespaceSpec{Pool: "warm"},
+			}
+			var objects = append([]client.Object{resource}, test.objects...)
+			var c = fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(objects...).Build()
+			var r = &DynamicNamespaceReconciler{Client: c, APIReader: c, Recorder: record.NewFakeRecorder(10)}
+
+			err := r.claimFromPool(context.Background(), resource)
+			if test.reason != "" {
+				var coded *messages.Error
+				if !errors.As(err, &coded) || coded.Reason != test.reason {
+					t.Fatalf("ошибка %v, ожидался код %v", err, test.reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resource.Status.Namespace != test.namespace {
+				t.Fatalf("status.namespace = %q, ожидалось %q", resource.Status.Namespace, test.namespace)
+			}
+			if test.namespace == "" {
+				return
+			}
+
+			var claimed v1.Namespace
+			if err = c.Get(context.Background(), types.NamespacedName{Name: test.namespace}, &claimed); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := claimed.Labels[poolLabelKey]; ok || claimed.Labels[defaultLabelKey] != "team-a.feature" || claimed.Labels["istio-injection"] != "enabled" {
+				t.Errorf("лейблы захваченного namespace: %v", claimed.Labels)
+			}
+			var expectations = []struct {
+				obj     client.Object
+				deleted bool
+			}{
+				{obj: &v1.ResourceQuota{}, deleted: true},
+				{obj: &rbacv1.RoleBinding{}, deleted: true},
+				{obj: &v1.ConfigMap{}, deleted: false},
+			}
+			for i, expectation := range expectations {
+				err = c.Get(context.Background(), client.ObjectKeyFromObject(poolObjects[i]), expectation.obj)
+				if deleted := kerrors.IsNotFound(err); deleted != expectation.deleted {
+					t.Errorf("%T удален: %v, ожидалось %v", expectation.obj, deleted, expectation.deleted)
+				}
+			}
+		})
+	}
+}
+
+func TestSeedObjects(t *testing.T) {
+	var pool = &platformv1.DynamicNamespacePool{ObjectMeta: metav1.ObjectMeta{Name: "warm", Namespace: "team-a"}}
+	var sources = []client.Object{
+		&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "team-a"}, Data: map[string]string{"mode": "ci"}},
+		&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "team-a"}, Type: v1.SecretTypeDockerConfigJson, Data: map[string][]byte{".dockerconfigjson": []byte("{}")}},
+	}
+
+	var tests = []struct {
+		name   string
+		seed   []platformv1.PoolSeed
+		reason messages.Reason
+	}{
+		{name: "ConfigMap и Secret копируются", seed: []platformv1.PoolSeed{{Kind: "ConfigMap", Name: "settings"}, {Kind: "Secret", Name: "registry"}}},
+		{name: "объект не найден", seed: []platformv1.PoolSeed{{Kind: "Secret", Name: "missing"}}, reason: messages.SeedNotFound},
+		{name: "неподдерживаемый тип", seed: []platformv1.PoolSeed{{Kind: "Pod", Name: "settings"}}, reason: messages.SeedKindUnsupported},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var c = fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(sources...).Build()
+			var r = &DynamicNamespacePoolReconciler{Client: c, APIReader: c}
+			var spec = pool.DeepCopy()
+			spec.Spec.Seed = test.seed
+
+			objects, err := r.seedObjects(context.Background(), spec)
+			if test.reason != "" {
+				var coded *messages.Error
+				if !errors.As(err, &coded) || coded.Reason != test.reason {
+					t.Fatalf("ошибка %v, ожидался код %v", err, test.reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(objects) != 2 {
+				t.Fatalf("получено %d объектов, ожидалось 2", len(objects))
+			}
+			configMap, secret := objects[0].(*v1.ConfigMap), objects[1].(*v1.Secret)
+			if configMap.Data["mode"] != "ci" || secret.Type != v1.SecretTypeDockerConfigJson || string(secret.Data[".dockerconfigjson"]) != "{}" {
+				t.Errorf("содержимое скопировано неверно: %v, %v", configMap, secret)
+			}
+			for _, obj := range objects {
+				if obj.GetNamespace() != "" || obj.GetLabels()[poolLabelKey] != "team-a.warm" {
+					t.Errorf("%s: namespace %q, лейблы %v", obj.GetName(), obj.GetNamespace(), obj.GetLabels())
+				}
+			}
+		})
+	}
+}
+
+func TestCheckPool(t *testing.T) {
+	var policy = ApprovalPolicy{
+		QuotaThreshold:  v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")},
+		RestrictedRoles: []string{"cluster-admin"},
+		ApproverGroups:  []string{"platform-admins"},
+	}
+	var tests = []struct {
+		name   string
+		policy ApprovalPolicy
+		spec   platformv1.DynamicNamespacePoolSpec
+		reason messages.Reason
+	}{
+		{name: "allowed", policy: policy, spec: platformv1.DynamicNamespacePoolSpec{Size: 3,
+			CreateQuota: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}}},
+		{name: "role not allowed", policy: policy, spec: platformv1.DynamicNamespacePoolSpec{Size: 1, Role: "cluster-admin"},
+			reason: messages.RoleNotAllowed},
+		{name: "size above maximum", policy: policy, spec: platformv1.DynamicNamespacePoolSpec{Size: defaultMaxPoolSize + 1},
+			reason: messages.PoolSizeExceeded},
+		{name: "quota above threshold", policy: policy, spec: platformv1.DynamicNamespacePoolSpec{Size: 1,
+			CreateQuota: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}}, reason: messages.PoolApprovalRequired},
+		{name: "quota without approval policy", spec: platformv1.DynamicNamespacePoolSpec{Size: 1,
+			CreateQuota: v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}}},
+	}
+	var settings = defaultSettings()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var r = &DynamicNamespacePoolReconciler{Approval: test.policy}
+			err := r.checkPool(&platformv1.DynamicNamespacePool{Spec: test.spec}, settings)
+			if test.reason == "" {
+				if err != nil {
+					t.Errorf("checkPool() = %v, ожидалось без ошибки", err)
+				}
+				return
+			}
+			var coded *messages.Error
+			if !errors.As(err, &coded) || coded.Reason != test.reason {
+				t.Errorf("ошибка %v, ожидался код %v", err, test.reason)
+			}
+		})
+	}
+}
+
+func TestRefusedPoolIsNotFilled(t *testing.T) {
+	var pool = &platformv1.DynamicNamespacePool{
+		ObjectMeta: metav1.ObjectMeta{Name: "warm", Namespace: "team-a", Finalizers: []string{defaultFinalizer}},
+		Spec: platformv1.DynamicNamespacePoolSpec{
+			Size:        2,
+			CreateQuota: v1.ResourceList{v1.ResourceCPU: resource.MustParse("64")},
+		},
+	}
+	var c = fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(pool).Build()
+	var r = &DynamicNamespacePoolReconciler{Client: c, APIReader: c, Approval: ApprovalPolicy{
+		QuotaThreshold: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")},
+		ApproverGroups: []string{"platform-admins"},
+	}}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "team-a", Name: "warm"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var namespaces v1.NamespaceList
+	if err := c.List(context.Background(), &namespaces); err != nil {
+		t.Fatal(err)
+	}
+	if len(namespaces.Items) != 0 {
+		t.Errorf("создано namespace: %v, ожидалось 0", len(namespaces.Items))
+	}
+	var stored platformv1.DynamicNamespacePool
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(pool), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status.Reason != string(messages.PoolApprovalRequired) {
+		t.Errorf("status.reason = %v, ожидалось %v", stored.Status.Reason, messages.PoolApprovalRequired)
+	}
+}
@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"k8s.io/api/core/v1"
+	apiresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	defaultScaleUpThreshold   = 90
+	defaultScaleDownThreshold = 50
+	defaultScaleStepPercent   = 50
+	defaultScaleWindow        = 30 * time.Minute
+
+	// Сколько последних изменений квоты хранится в статусе
+	quotaScaleHistoryLimit = 10
+)
+
+// quotaScaleChange - одно изменение лимита квоты
+type quotaScaleChange struct {
+	name     v1.ResourceName
+	from, to apiresource.Quantity
+}
+
+// reconcileQuotaAutoscaling изменяет лимиты квоты целевого namespace по spec.quotaAutoscaling.
+// Лимиты растут шагами, пока использование держится выше порога дольше окна, и возвращаются
+// к createQuota после такого же периода низкого использования. Возвращает время до следующей проверки.
+func (r *DynamicNamespaceReconciler) reconcileQuotaAutoscaling(ctx context.Context, resource *platformv1.DynamicNamespace, usage *platformv1.QuotaUsage, now time.Time) (time.Duration, error) {
+	var policy = resource.Spec.QuotaAutoscaling
+	if policy == nil {
+		if resource.Status.QuotaAutoscaling != nil {
+			// Автомасштабирование выключено: квота возвращается к спецификации
+			resource.Status.QuotaAutoscaling = nil
+			err := r.saveStatus(ctx, resource)
+			if err != nil {
+				return 0, err
+			}
+			return 0, r.createOrUpdateResourceQuota(ctx, resource)
+		}
+		return 0, nil
+	}
+	if usage == nil {
+		return 0, nil
+	}
+
+	base, err := parentQuota(resource)
+	if err != nil {
+		return 0, err
+	}
+	var state = resource.Status.QuotaAutoscaling.DeepCopy()
+	if state == nil {
+		state = &platformv1.QuotaAutoscalingStatus{}
+	}
+	if state.HighSince == nil {
+		state.HighSince = map[v1.ResourceName]metav1.Time{}
+	}
+	if state.LowSince == nil {
+		state.LowSince = map[v1.ResourceName]metav1.Time{}
+	}
+
+	changes, requeueAfter := scaleQuota(policy, base, usage, state, now)
+	for _, change := range changes {
+		if state.Current == nil {
+			state.Current = v1.ResourceList{}
+		}
+		state.Current[change.name] = change.to
+		state.History = append(state.History, platformv1.QuotaScaleEvent{
+			Time:     metav1.NewTime(now),
+			Resource: change.name,
+			From:     change.from,
+			To:       change.to,
+		})
+	}
+	if len(state.History) > quotaScaleHistoryLimit {
+		state.History = state.History[len(state.History)-quotaScaleHistoryLimit:]
+	}
+
+	if len(state.HighSince) == 0 {
+		state.HighSince = nil
+	}
+	if len(state.LowSince) == 0 {
+		state.LowSince = nil
+	}
+	resource.Status.QuotaAutoscaling = state
+	if len(changes) == 0 {
+		return requeueAfter, nil
+	}
+
+	// Статус сохраняется до применения квоты, чтобы новые лимиты не потерялись при ошибке
+	err = r.saveStatus(ctx, resource)
+	if err != nil {
+		return 0, err
+	}
+	err = r.createOrUpdateResourceQuota(ctx, resource)
+	if err != nil {
+		return 0, err
+	}
+	var language = r.Settings.Load().Language
+	for _, change := range changes {
+		ctrllog.FromContext(ctx).Info("Квота ресурса изменена", "resource", change.name, "from", change.from.String(), "to", change.to.String())
+		r.Recorder.Event(resource, v1.EventTypeNormal, "QuotaScaled", language.Text(messages.New(messages.QuotaScaled, change.name, change.from.String(), change.to.String())))
+	}
+	return minRequeue(requeueAfter, scaleWindow(policy)), nil
+}
+
+// scaleWindow возвращает окно, в течение которого использование должно держаться за порогом
+func scaleWindow(policy *platformv1.QuotaAutoscaling) time.Duration {
+	if policy.Window != nil {
+		return policy.Window.Duration
+	}
+	return defaultScaleWindow
+}
+
+// scaleThresholds возвращает пороги увеличения и уменьшения и шаг с учетом значений по умолчанию
+func scaleThresholds(policy *platformv1.QuotaAutoscaling) (up int32, down int32, step int32) {
+	up, down, step = defaultScaleUpThreshold, defaultScaleDownThreshold, defaultScaleStepPercent
+	if policy.ScaleUpThreshold != 0 {
+		up = policy.ScaleUpThreshold
+	}
+	if policy.ScaleDownThreshold != 0 {
+		down = policy.ScaleDownThreshold
+	}
+	if policy.StepPercent != 0 {
+		step = policy.StepPercent
+	}
+	return up, down, step
+}
+
+// scaleQuota вычисляет изменения лимитов квоты по использованию и отмечает в state начало
+// высокого и низкого использования. Ресурсы без максимума в policy.Max не увеличиваются.
+// Возвращает изменения и время до следующей проверки
+func scaleQuota(policy *platformv1.QuotaAutoscaling, base v1.ResourceList, usage *platformv1.QuotaUsage, state *platformv1.QuotaAutoscalingStatus, now time.Time) ([]quotaScaleChange, time.Duration) {
+	var window = scaleWindow(policy)
+	var up, down, step = scaleThresholds(policy)
+
+	var changes []quotaScaleChange
+	var requeueAfter time.Duration
+	for name, baseQuantity := range base {
+		hard, ok := usage.Hard[name]
+		if !ok || hard.IsZero() {
+			continue
+		}
+		used := usage.Used[name]
+		var percent = int32(float64(used.MilliValue()) * 100 / float64(hard.MilliValue()))
+
+		var target = hard.DeepCopy()
+		var increment = apiresource.NewMilliQuantity(baseQuantity.MilliValue()*int64(step)/100, baseQuantity.Format)
+		switch {
+		case percent >= up:
+			delete(state.LowSince, name)
+			max, limited := policy.Max[name]
+			if !limited || hard.Cmp(max) >= 0 {
+				delete(state.HighSince, name)
+				continue
+			}
+			since, tracked := state.HighSince[name]
+			if !tracked {
+				state.HighSince[name] = metav1.NewTime(now)
+				requeueAfter = minRequeue(requeueAfter, window)
+				continue
+			}
+			if now.Sub(since.Time) < window {
+				requeueAfter = minRequeue(requeueAfter, window-now.Sub(since.Time))
+				continue
+			}
+			target.Add(*increment)
+			if target.Cmp(max) > 0 {
+				target = max.DeepCopy()
+			}
+		case percent < down:
+			delete(state.HighSince, name)
+			since, tracked := state.LowSince[name]
+			if hard.Cmp(baseQuantity) <= 0 {
+				delete(state.LowSince, name)
+				continue
+			}
+			if !tracked {
+				state.LowSince[name] = metav1.NewTime(now)
+				requeueAfter = minRequeue(requeueAfter, window)
+				continue
+			}
+			if now.Sub(since.Time) < window {
+				requeueAfter = minRequeue(requeueAfter, window-now.Sub(since.Time))
+				continue
+			}
+			target.Sub(*increment)
+			if target.Cmp(baseQuantity) < 0 {
+				target = baseQuantity.DeepCopy()
+			}
+		default:
+			delete(state.HighSince, name)
+			delete(state.LowSince, name)
+			continue
+		}
+
+		delete(state.HighSince, name)
+		delete(state.LowSince, name)
+		if target.Cmp(hard) == 0 {
+			continue
+		}
+		changes = append(changes, quotaScaleChange{name: name, from: hard, to: target})
+	}
+	return changes, requeueAfter
+}
+
+// autoscaledQuota накладывает лимиты, выставленные автомасштабированием, на базовую квоту
+func autoscaledQuota(resource *platformv1.DynamicNamespace, hard v1.ResourceList) v1.ResourceList {
+	if resource.Spec.QuotaAutoscaling == nil || resource.Status.QuotaAutoscaling == nil {
+		return hard
+	}
+	var result = hard.DeepCopy()
+	for name, quantity := range resource.Status.QuotaAutoscaling.Current {
+		base, ok := result[name]
+		if !ok || quantity.Cmp(base) <= 0 {
+			continue
+		}
+		max, ok := resource.Spec.QuotaAutoscaling.Max[name]
+		if !ok {
+			continue
+		}
+		if quantity.Cmp(max) > 0 {
+			quantity = max
+		}
+		result[name] = quantity
+	}
+	return result
+}
+
+// validateQuotaAutoscaling проверяет, что максимум задан для каждого ресурса квоты и не меньше его.
+// Без максимума квота росла бы без ограничений в обход порога подтверждения
+func validateQuotaAutoscaling(resource *platformv1.DynamicNamespace) error {
+	var policy = resource.Spec.QuotaAutoscaling
+	if policy == nil {
+		return nil
+	}
+	// Пороги сравниваются с учетом значений по умолчанию, с которыми работает scaleQuota
+	up, down, _ := scaleThresholds(policy)
+	if down >= up {
+		return messages.Errorf(messages.AutoscalingThresholdInvalid, down, up)
+	}
+	var names []string
+	for name := range resource.Spec.CreateQuota {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := policy.Max[v1.ResourceName(name)]; !ok {
+			return messages.Errorf(messages.AutoscalingMaxMissing, name)
+		}
+	}
+	for name, max := range policy.Max {
+		base, ok := resource.Spec.CreateQuota[name]
+		if ok && max.Cmp(base) < 0 {
+			return messages.Errorf(messages.AutoscalingMaxBelowQuota, name, max.String(), base.String())
+		}
+	}
+	return nil
+}
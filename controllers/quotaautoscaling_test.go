@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestScaleQuota(t *testing.T) {
+	var now = time.Date(2024, 3, 12, 12, 0, 0, 0, time.UTC)
+	var cpu = func(value string) v1.ResourceList {
+		return v1.ResourceList{v1.ResourceCPU: resource.MustParse(value)}
+	}
+	var since = func(ago time.Duration) map[v1.ResourceName]metav1.Time {
+		return map[v1.ResourceName]metav1.Time{v1.ResourceCPU: metav1.NewTime(now.Add(-ago))}
+	}
+	var policy = &platformv1.QuotaAutoscaling{Max: cpu("4")}
+
+	var tests = []struct {
+		name      string
+		policy    *platformv1.QuotaAutoscaling
+		hard      string
+		used      string
+		highSince map[v1.ResourceName]metav1.Time
+		lowSince  map[v1.ResourceName]metav1.Time
+		target    string
+		high, low bool
+		requeue   time.Duration
+	}{
+		{name: "высокое использование начинает отсчет окна", policy: policy, hard: "2", used: "1900m", high: true, requeue: defaultScaleWindow},
+		{name: "окно еще не прошло", policy: policy, hard: "2", used: "1900m", highSince: since(10 * time.Minute), high: true, requeue: 20 * time.Minute},
+		{name: "квота увеличивается на шаг", policy: policy, hard: "2", used: "1900m", highSince: since(time.Hour), target: "3"},
+		{name: "увеличение ограничено максимумом", policy: policy, hard: "3500m", used: "3400m", highSince: since(time.Hour), target: "4"},
+		{name: "на максимуме квота не растет", policy: policy, hard: "4", used: "3900m", highSince: since(time.Hour)},
+		{name: "ресурс без максимума не растет", policy: &platformv1.QuotaAutoscaling{}, hard: "2", used: "1900m", highSince: since(time.Hour)},
+		{name: "низкое использование начинает отсчет окна", policy: policy, hard: "4", used: "100m", low: true, requeue: defaultScaleWindow},
+		{name: "квота уменьшается на шаг", policy: policy, hard: "4", used: "100m", lowSince: since(time.Hour), target: "3"},
+		{name: "уменьшение ограничено базовой квотой", policy: policy, hard: "2500m", used: "100m", lowSince: since(time.Hour), target: "2"},
+		{name: "базовая квота не уменьшается", policy: policy, hard: "2", used: "100m", lowSince: since(time.Hour)},
+		{name: "использование между порогами сбрасывает отсчет", policy: policy, hard: "2", used: "1200m", highSince: since(time.Hour)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var state = &platformv1.QuotaAutoscalingStatus{
+				HighSince: map[v1.ResourceName]metav1.Time{},
+				LowSince:  map[v1.ResourceName]metav1.Time{},
+			}
+			for name, value := range test.highSince {
+				state.HighSince[name] = value
+			}
+			for name, value := range test.lowSince {
+				state.LowSince[name] = value
+			}
+			var usage = &platformv1.QuotaUsage{Hard: cpu(test.hard), Used: cpu(test.used)}
+
+			changes, requeue := scaleQuota(test.policy, cpu("2"), usage, state, now)
+			if test.target == "" {
+				if len(changes) != 0 {
+					t.Errorf("изменения %v, ожидалось без изменений", changes)
+				}
+			} else if len(changes) != 1 || changes[0].to.Cmp(resource.MustParse(test.target)) != 0 {
+				t.Errorf("изменения %v, ожидалось %v", changes, test.target)
+			}
+			_, high := state.HighSince[v1.ResourceCPU]
+			_, low := state.LowSince[v1.ResourceCPU]
+			if high != test.high || low != test.low {
+				t.Errorf("отсчет высокого %v, низкого %v; ожидалось %v, %v", high, low, test.high, test.low)
+			}
+			if requeue != test.requeue {
+				t.Errorf("requeue = %v, ожидалось %v", requeue, test.requeue)
+			}
+		})
+	}
+}
+
+func TestValidateQuotaAutoscaling(t *testing.T) {
+	var quota = v1.ResourceList{v1.ResourceCPU: resource.MustParse("2"), v1.ResourceMemory: resource.MustParse("4Gi")}
+
+	var tests = []struct {
+		name   string
+		policy *platformv1.QuotaAutoscaling
+		reason messages.Reason
+	}{
+		{name: "без автомасштабирования"},
+		{
+			name:   "максимум задан для всех ресурсов",
+			policy: &platformv1.QuotaAutoscaling{Max: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("8Gi")}},
+		},
+		{
+			name:   "ресурс без максимума",
+			policy: &platformv1.QuotaAutoscaling{Max: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}},
+			reason: messages.AutoscalingMaxMissing,
+		},
+		{
+			name:   "максимум меньше квоты",
+			policy: &platformv1.QuotaAutoscaling{Max: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1"), v1.ResourceMemory: resource.MustParse("8Gi")}},
+			reason: messages.AutoscalingMaxBelowQuota,
+		},
+		{
+			name:   "порог уменьшения выше порога увеличения",
+			policy: &platformv1.QuotaAutoscaling{ScaleUpThreshold: 60, ScaleDownThreshold: 70},
+			reason: messages.AutoscalingThresholdInvalid,
+		},
+		{
+			name:   "порог увеличения ниже порога уменьшения по умолчанию",
+			policy: &platformv1.QuotaAutoscaling{ScaleUpThreshold: 40},
+			reason: messages.AutoscalingThresholdInvalid,
+		},
+		{
+			name:   "порог уменьшения выше порога увеличения по умолчанию",
+			policy: &platformv1.QuotaAutoscaling{ScaleDownThreshold: 95},
+			reason: messages.AutoscalingThresholdInvalid,
+		},
+		{
+			name:   "порог увеличения выше порога уменьшения по умолчанию",
+			policy: &platformv1.QuotaAutoscaling{ScaleUpThreshold: 70, Max: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("8Gi")}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var resource = &platformv1.DynamicNamespace{Spec: platformv1.DynamicNamespaceSpec{CreateQuota: quota, QuotaAutoscaling: test.policy}}
+			err := validateQuotaAutoscaling(resource)
+			if test.reason == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var coded *messages.Error
+			if !errors.As(err, &coded) || coded.Reason != test.reason {
+				t.Fatalf("ошибка %v, ожидался код %v", err, test.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// conditionQuotaPressure выставляется в True, когда использование квоты достигло порога
+	conditionQuotaPressure = "QuotaPressure"
+
+	defaultQuotaWarningThreshold = 90
+)
+
+// quotaUsage читает использование квоты целевого namespace.
+// Возвращает nil, если квота еще не создана или ее статус не заполнен.
+func (r *DynamicNamespaceReconciler) quotaUsage(ctx context.Context, resource *platformv1.DynamicNamespace) (*platformv1.QuotaUsage, error) {
+	if len(resource.Spec.CreateQuota) == 0 {
+		return nil, nil
+	}
+	desiredResourceQuota, err := generateResourceQuota(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	var quota v1.ResourceQuota
+	err = r.Get(ctx, types.NamespacedName{Namespace: desiredResourceQuota.Namespace, Name: desiredResourceQuota.Name}, &quota)
+	if err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	if quota.Status.Hard == nil {
+		return nil, nil
+	}
+	return &platformv1.QuotaUsage{
+		Hard: quota.Status.Hard,
+		Used: quota.Status.Used,
+	}, nil
+}
+
+// quotaUtilization возвращает максимальный процент использования среди ресурсов квоты и имя этого ресурса
+func quotaUtilization(usage *platformv1.QuotaUsage) (int32, v1.ResourceName) {
+	var max int32
+	var maxName v1.ResourceName
+	for name, hard := range usage.Hard {
+		used, ok := usage.Used[name]
+		if !ok {
+			continue
+		}
+		var percent int32
+		if hard.IsZero() {
+			if used.IsZero() {
+				continue
+			}
+			percent = 100
+		} else {
+			percent = int32(float64(used.MilliValue()) * 100 / float64(hard.MilliValue()))
+		}
+		if percent > max || maxName == "" {
+			max = percent
+			maxName = name
+		}
+	}
+	return max, maxName
+}
+
+// setQuotaUsageStatus переносит использование квоты в статус и выставляет условие QuotaPressure.
+// Возвращает true, если порог был превышен в этом цикле впервые.
+func setQuotaUsageStatus(status *platformv1.DynamicNamespaceStatus, usage *platformv1.QuotaUsage, threshold int32, language messages.Language) bool {
+	if usage == nil {
+		status.QuotaUsage = nil
+		status.QuotaUtilization = 0
+		meta.RemoveStatusCondition(&status.Conditions, conditionQuotaPressure)
+		return false
+	}
+
+	var wasPressure = meta.IsStatusConditionTrue(status.Conditions, conditionQuotaPressure)
+	utilization, name := quotaUtilization(usage)
+	status.QuotaUsage = usage
+	status.QuotaUtilization = utilization
+
+	var condition = metav1.Condition{
+		Type:    conditionQuotaPressure,
+		Status:  metav1.ConditionFalse,
+		Reason:  string(messages.BelowThreshold),
+		Message: language.Text(messages.New(messages.BelowThreshold, threshold)),
+	}
+	if utilization >= threshold {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = string(messages.ThresholdExceeded)
+		condition.Message = language.Text(messages.New(messages.ThresholdExceeded, name, utilization, threshold))
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+	return condition.Status == metav1.ConditionTrue && !wasPressure
+}
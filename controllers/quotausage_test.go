@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"testing"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestQuotaUtilization(t *testing.T) {
+	var list = func(values map[v1.ResourceName]string) v1.ResourceList {
+		var result = v1.ResourceList{}
+		for name, value := range values {
+			result[name] = resource.MustParse(value)
+		}
+		return result
+	}
+
+	var tests = []struct {
+		name     string
+		hard     map[v1.ResourceName]string
+		used     map[v1.ResourceName]string
+		percent  int32
+		resource v1.ResourceName
+	}{
+		{name: "пустая квота"},
+		{
+			name:     "берется самый загруженный ресурс",
+			hard:     map[v1.ResourceName]string{v1.ResourceCPU: "2", v1.ResourceMemory: "4Gi"},
+			used:     map[v1.ResourceName]string{v1.ResourceCPU: "1", v1.ResourceMemory: "3Gi"},
+			percent:  75,
+			resource: v1.ResourceMemory,
+		},
+		{
+			name:     "милли-единицы",
+			hard:     map[v1.ResourceName]string{v1.ResourceCPU: "300m"},
+			used:     map[v1.ResourceName]string{v1.ResourceCPU: "100m"},
+			percent:  33,
+			resource: v1.ResourceCPU,
+		},
+		{
+			name:     "использование нулевой квоты считается полным",
+			hard:     map[v1.ResourceName]string{v1.ResourceCPU: "2", "count/pods": "0"},
+			used:     map[v1.ResourceName]string{v1.ResourceCPU: "1", "count/pods": "1"},
+			percent:  100,
+			resource: "count/pods",
+		},
+		{
+			name:     "неиспользуемая нулевая квота пропускается",
+			hard:     map[v1.ResourceName]string{v1.ResourceCPU: "2", "count/pods": "0"},
+			used:     map[v1.ResourceName]string{v1.ResourceCPU: "1", "count/pods": "0"},
+			percent:  50,
+			resource: v1.ResourceCPU,
+		},
+		{
+			name:     "ресурс без использования пропускается",
+			hard:     map[v1.ResourceName]string{v1.ResourceCPU: "2", v1.ResourceMemory: "4Gi"},
+			used:     map[v1.ResourceName]string{v1.ResourceCPU: "500m"},
+			percent:  25,
+			resource: v1.ResourceCPU,
+		},
+		{
+			name:     "превышение квоты",
+			hard:     map[v1.ResourceName]string{v1.ResourceMemory: "1Gi"},
+			used:     map[v1.ResourceName]string{v1.ResourceMemory: "1536Mi"},
+			percent:  150,
+			resource: v1.ResourceMemory,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			percent, name := quotaUtilization(&platformv1.QuotaUsage{Hard: list(test.hard), Used: list(test.used)})
+			if percent != test.percent || name != test.resource {
+				t.Errorf("quotaUtilization = %v, %v; ожидалось %v, %v", percent, name, test.percent, test.resource)
+			}
+		})
+	}
+}
+
+func TestSetQuotaUsageStatus(t *testing.T) {
+	var usage = func(used string) *platformv1.QuotaUsage {
+		return &platformv1.QuotaUsage{
+			Hard: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")},
+			Used: v1.ResourceList{v1.ResourceCPU: resource.MustParse(used)},
+		}
+	}
+	var status platformv1.DynamicNamespaceStatus
+
+	// Событие выдается только при первом превышении порога
+	var steps = []struct {
+		usage    *platformv1.QuotaUsage
+		pressure bool
+		fired    bool
+	}{
+		{usage: usage("500m"), pressure: false, fired: false},
+		{usage: usage("950m"), pressure: true, fired: true},
+		{usage: usage("990m"), pressure: true, fired: false},
+		{usage: usage("100m"), pressure: false, fired: false},
+		{usage: usage("900m"), pressure: true, fired: true},
+		{usage: nil, pressure: false, fired: false},
+	}
+	for i, step := range steps {
+		fired := setQuotaUsageStatus(&status, step.usage, defaultQuotaWarningThreshold, messages.English)
+		if pressure := meta.IsStatusConditionTrue(status.Conditions, conditionQuotaPressure); pressure != step.pressure || fired != step.fired {
+			t.Errorf("шаг %d: QuotaPressure = %v, событие = %v; ожидалось %v, %v", i, pressure, fired, step.pressure, step.fired)
+		}
+	}
+	if status.QuotaUsage != nil || status.QuotaUtilization != 0 || meta.FindStatusCondition(status.Conditions, conditionQuotaPressure) != nil {
+		t.Errorf("без квоты статус использования не очищен: %+v", status)
+	}
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// DefaultRateLimitBaseDelay - задержка первой повторной обработки после ошибки
+	DefaultRateLimitBaseDelay = 5 * time.Millisecond
+	// DefaultRateLimitMaxDelay - предельная задержка повторной обработки после ошибок
+	DefaultRateLimitMaxDelay = 5 * time.Minute
+	// DefaultRateLimitQPS - средняя частота постановки ресурсов в очередь на все ресурсы контроллера
+	DefaultRateLimitQPS = 10
+	// DefaultRateLimitBurst - допустимый всплеск поверх DefaultRateLimitQPS
+	DefaultRateLimitBurst = 100
+)
+
+// RateLimit configures the work queue of a controller: per-item exponential backoff
+// after failures combined with a token bucket shared by all items
+type RateLimit struct {
+	// Задержка первой повторной обработки ресурса после ошибки; удваивается с каждой ошибкой
+	BaseDelay time.Duration
+	// Предельная задержка повторной обработки ресурса
+	MaxDelay time.Duration
+	// Средняя частота постановки в очередь для всех ресурсов контроллера
+	QPS float64
+	// Допустимый всплеск поверх QPS
+	Burst int
+}
+
+// NewRateLimiter создает ограничитель очереди. Каждому контроллеру нужен свой экземпляр:
+// счетчики ошибок и корзина токенов не должны быть общими
+func (l RateLimit) NewRateLimiter() workqueue.RateLimiter {
+	var limit = l.withDefaults()
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(limit.BaseDelay, limit.MaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(limit.QPS), limit.Burst)},
+	)
+}
+
+func (l RateLimit) withDefaults() RateLimit {
+	if l.BaseDelay <= 0 {
+		l.BaseDelay = DefaultRateLimitBaseDelay
+	}
+	if l.MaxDelay <= 0 {
+		l.MaxDelay = DefaultRateLimitMaxDelay
+	}
+	if l.QPS <= 0 {
+		l.QPS = DefaultRateLimitQPS
+	}
+	if l.Burst <= 0 {
+		l.Burst = DefaultRateLimitBurst
+	}
+	return l
+}
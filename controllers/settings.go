@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"text/template"
+
+	configv1alpha1 "github.com/wbe7/dynamicnamespace/api/config/v1alpha1"
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"k8s.io/api/core/v1"
+	apiresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultNamespaceTemplate = "{{ .Name }}"
+	defaultMaxPoolSize       = 10
+)
+
+// Settings holds the DynamicNamespace defaults that can be changed without a restart
+type Settings struct {
+	// Квота окружения, если в спецификации не задан createQuota
+	DefaultQuota v1.ResourceList
+	// ClusterRole, если не задан spec.role
+	DefaultRole string
+	// ClusterRole, которые можно указать в spec.role
+	AllowedRoles []string
+	// Шаблон имени целевого namespace
+	NamespaceTemplate *template.Template
+	// Политика удаления, если не задан spec.expiration
+	DefaultExpiration *platformv1.ExpirationPolicy
+	// Namespace, в которых обрабатываются DynamicNamespace. Пустой список - все namespace
+	SourceNamespaces []string
+	// Селектор лейблов namespace, в которых обрабатываются DynamicNamespace. nil - все namespace
+	SourceNamespaceSelector labels.Selector
+	// Язык сообщений статусов и событий
+	Language messages.Language
+	// Наибольший размер пула
+	MaxPoolSize int32
+}
+
+// NewSettings строит настройки из файла конфигурации, подставляя значения по умолчанию
+func NewSettings(config *configv1alpha1.DynamicNamespaceConfig) (*Settings, error) {
+	var settings = defaultSettings()
+	if config == nil {
+		return settings, nil
+	}
+	if config.Defaults.Quota != nil {
+		settings.DefaultQuota = config.Defaults.Quota.DeepCopy()
+	}
+	if config.Defaults.Role != "" {
+		settings.DefaultRole = config.Defaults.Role
+	}
+	if len(config.Defaults.AllowedRoles) > 0 {
+		settings.AllowedRoles = append([]string(nil), config.Defaults.AllowedRoles...)
+	}
+	if err := settings.checkRole(settings.DefaultRole, nil); err != nil {
+		return nil, fmt.Errorf("defaults.role: %v", err)
+	}
+	if config.Defaults.NamespaceTemplate != "" {
+		tmpl, err := template.New("namespace").Option("missingkey=error").Parse(config.Defaults.NamespaceTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный шаблон имени namespace: %v", err)
+		}
+		settings.NamespaceTemplate = tmpl
+	}
+	settings.DefaultExpiration = config.Defaults.Expiration.DeepCopy()
+	if config.Defaults.MaxPoolSize > 0 {
+		settings.MaxPoolSize = config.Defaults.MaxPoolSize
+	}
+	settings.SourceNamespaces = append([]string(nil), config.SourceNamespaces...)
+	if config.SourceNamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(config.SourceNamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный sourceNamespaceSelector: %v", err)
+		}
+		settings.SourceNamespaceSelector = selector
+	}
+	language, err := messages.ParseLanguage(config.Language)
+	if err != nil {
+		return nil, err
+	}
+	settings.Language = language
+	return settings, nil
+}
+
+func defaultSettings() *Settings {
+	return &Settings{
+		DefaultQuota: v1.ResourceList{
+			v1.ResourceCPU:              apiresource.MustParse("100m"),
+			v1.ResourceMemory:           apiresource.MustParse("100Mi"),
+			v1.ResourceEphemeralStorage: apiresource.MustParse("100Mi"),
+		},
+		DefaultRole:       defaultRole,
+		AllowedRoles:      []string{"admin", "edit", "view"},
+		NamespaceTemplate: template.Must(template.New("namespace").Parse(defaultNamespaceTemplate)),
+		Language:          messages.DefaultLanguage,
+		MaxPoolSize:       defaultMaxPoolSize,
+	}
+}
+
+// SettingsStore keeps the current Settings; safe for concurrent use
+type SettingsStore struct {
+	value atomic.Value
+}
+
+// Load возвращает текущие настройки. Безопасен для nil
+func (s *SettingsStore) Load() *Settings {
+	if s == nil {
+		return defaultSettings()
+	}
+	settings, ok := s.value.Load().(*Settings)
+	if !ok {
+		return defaultSettings()
+	}
+	return settings
+}
+
+// Store заменяет текущие настройки
+func (s *SettingsStore) Store(settings *Settings) {
+	s.value.Store(settings)
+}
+
+// checkRole проверяет, что ClusterRole входит в allowedRoles или в extra
+func (s *Settings) checkRole(role string, extra []string) error {
+	for _, allowed := range s.AllowedRoles {
+		if role == allowed {
+			return nil
+		}
+	}
+	for _, allowed := range extra {
+		if role == allowed {
+			return nil
+		}
+	}
+	var allowed = append(append([]string(nil), s.AllowedRoles...), extra...)
+	return messages.Errorf(messages.RoleNotAllowed, role, strings.Join(allowed, ", "))
+}
+
+// applyDefaults заполняет незаданные поля спецификации значениями из настроек.
+// Изменяется только копия в памяти: спецификация в API-сервере остается прежней
+func applyDefaults(resource *platformv1.DynamicNamespace, settings *Settings) {
+	if resource.Spec.CreateQuota == nil {
+		resource.Spec.CreateQuota = settings.DefaultQuota.DeepCopy()
+	}
+	if resource.Spec.Role == "" {
+		resource.Spec.Role = settings.DefaultRole
+	}
+	if resource.Spec.Expiration == nil {
+		resource.Spec.Expiration = settings.DefaultExpiration.DeepCopy()
+	}
+}
+
+// assignNamespace вычисляет имя целевого namespace по шаблону и сохраняет его в статусе
+// до создания namespace, чтобы смена шаблона не влияла на существующие окружения
+func (r *DynamicNamespaceReconciler) assignNamespace(ctx context.Context, resource *platformv1.DynamicNamespace, settings *Settings) error {
+	name, err := r.legacyNamespace(ctx, resource)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name, err = namespaceName(resource, settings)
+		if err != nil {
+			return err
+		}
+	}
+	resource.Status.Namespace = name
+	return r.saveStatus(ctx, resource)
+}
+
+// legacyNamespace возвращает имя namespace окружения, созданного до появления status.namespace.
+// Тогда namespace назывался по имени ресурса; если он существует и принадлежит ресурсу, окружение
+// остается в нем, а не переезжает в namespace по текущему шаблону. Иначе возвращается пустая строка
+func (r *DynamicNamespaceReconciler) legacyNamespace(ctx context.Context, resource *platformv1.DynamicNamespace) (string, error) {
+	// Читаем из API-сервера: при --shard старый namespace без лейбла шарда не попадает в кэш
+	var namespace v1.Namespace
+	err := r.APIReader.Get(ctx, types.NamespacedName{Name: resource.Name}, &namespace)
+	if err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	if namespace.Labels[defaultLabelKey] != ownerLabelValue(resource) {
+		return "", nil
+	}
+	return namespace.Name, nil
+}
+
+// namespaceName вычисляет имя целевого namespace по шаблону из настроек
+func namespaceName(resource *platformv1.DynamicNamespace, settings *Settings) (string, error) {
+	var buffer bytes.Buffer
+	err := settings.NamespaceTemplate.Execute(&buffer, struct {
+		Name      string
+		Namespace string
+	}{
+		Name:      resource.Name,
+		Namespace: resource.Namespace,
+	})
+	if err != nil {
+		return "", messages.Errorf(messages.NamespaceTemplateFailed, err)
+	}
+	var name = strings.TrimSpace(buffer.String())
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return "", messages.Errorf(messages.NamespaceNameInvalid, name, strings.Join(errs, "; "))
+	}
+	return name, nil
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	configv1alpha1 "github.com/wbe7/dynamicnamespace/api/config/v1alpha1"
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNewSettings(t *testing.T) {
+	var tests = []struct {
+		name     string
+		defaults configv1alpha1.DynamicNamespaceDefaults
+		language string
+		role     string
+		allowed  int
+		ok       bool
+	}{
+		{name: "значения по умолчанию", role: "admin", allowed: 3, ok: true},
+		{name: "роль и список ролей из файла", defaults: configv1alpha1.DynamicNamespaceDefaults{Role: "view", AllowedRoles: []string{"view"}}, role: "view", allowed: 1, ok: true},
+		{name: "роль вне списка", defaults: configv1alpha1.DynamicNamespaceDefaults{Role: "cluster-admin"}},
+		{name: "некорректный шаблон", defaults: configv1alpha1.DynamicNamespaceDefaults{NamespaceTemplate: "{{ .Name"}},
+		{name: "неизвестный язык", language: "de"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings, err := NewSettings(&configv1alpha1.DynamicNamespaceConfig{Defaults: test.defaults, Language: test.language})
+			if (err == nil) != test.ok {
+				t.Fatalf("ошибка %v, ожидался успех %v", err, test.ok)
+			}
+			if !test.ok {
+				return
+			}
+			if settings.DefaultRole != test.role || len(settings.AllowedRoles) != test.allowed {
+				t.Errorf("роль %v, разрешено %v; ожидалось %v, %v ролей", settings.DefaultRole, settings.AllowedRoles, test.role, test.allowed)
+			}
+		})
+	}
+}
+
+// Новый defaults.expiration применяется ко всем окружениям без своей политики, в том числе к давно
+// созданным: они сначала получают предупреждение и удаляются не раньше чем через warningPeriod
+func TestReloadedDefaultExpirationWarnsFirst(t *testing.T) {
+	var now = time.Date(2024, 3, 12, 12, 0, 0, 0, time.UTC)
+	settings, err := NewSettings(&configv1alpha1.DynamicNamespaceConfig{Defaults: configv1alpha1.DynamicNamespaceDefaults{
+		Expiration: &platformv1.ExpirationPolicy{TTL: &metav1.Duration{Duration: 24 * time.Hour}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resource = &platformv1.DynamicNamespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "team-a", CreationTimestamp: metav1.NewTime(now.Add(-90 * 24 * time.Hour))},
+	}
+	applyDefaults(resource, settings)
+
+	var c = fake.NewClientBuilder().WithScheme(testScheme(t)).Build()
+	var r = &DynamicNamespaceReconciler{Client: c, APIReader: c}
+	result, err := r.evaluateExpiration(context.Background(), resource, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.expired(now) || !result.warning(now) || !result.expiresAt.Equal(now.Add(defaultWarningPeriod)) {
+		t.Errorf("expiresAt = %v, warning = %v, expired = %v; ожидалось предупреждение и удаление в %v",
+			result.expiresAt, result.warning(now), result.expired(now), now.Add(defaultWarningPeriod))
+	}
+}
+
+// Окружение, созданное до появления status.namespace, остается в namespace с именем ресурса,
+// даже если шаблон имен с тех пор изменился
+func TestAssignNamespace(t *testing.T) {
+	var tests = []struct {
+		name     string
+		existing []client.Object
+		want     string
+	}{
+		{name: "окружение до обновления", existing: []client.Object{
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "feature", Labels: map[string]string{defaultLabelKey: "team-a.feature"}}},
+		}, want: "feature"},
+		{name: "namespace другого окружения", existing: []client.Object{
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "feature", Labels: map[string]string{defaultLabelKey: "team-b.feature"}}},
+		}, want: "team-a-feature"},
+		{name: "чужой namespace", existing: []client.Object{
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "feature"}},
+		}, want: "team-a-feature"},
+		{name: "новое окружение", want: "team-a-feature"},
+	}
+	settings, err := NewSettings(&configv1alpha1.DynamicNamespaceConfig{Defaults: configv1alpha1.DynamicNamespaceDefaults{
+		NamespaceTemplate: "{{ .Namespace }}-{{ .Name }}",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var resource = &platformv1.DynamicNamespace{ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "team-a"}}
+			var objects = append([]client.Object{resource.DeepCopy()}, test.existing...)
+			var c = fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(objects...).Build()
+			var r = &DynamicNamespaceReconciler{Client: c, APIReader: c}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(resource), resource); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := r.assignNamespace(context.Background(), resource, settings); err != nil {
+				t.Fatal(err)
+			}
+			var stored platformv1.DynamicNamespace
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(resource), &stored); err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status.Namespace != test.want {
+				t.Errorf("status.namespace = %v, ожидалось %v", stored.Status.Namespace, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+var (
+	// shardLabelKey распределяет DynamicNamespace и пулы по экземплярам оператора.
+	// Лейбл копируется на все созданные объекты, чтобы их кэш фильтровался тем же селектором
+	shardLabelKey = platformv1.GroupVersion.Group + "/shard"
+)
+
+// ParseShard разбирает селектор шарда (--shard). Пустая строка - экземпляр обрабатывает все ресурсы.
+// Селектор может ссылаться только на лейбл platform.cloudnative.space/shard: именно он
+// переносится на namespace, квоты и RoleBinding, которые фильтруются тем же селектором
+func ParseShard(value string) (labels.Selector, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	selector, err := labels.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("некорректный селектор шарда %q: %v", value, err)
+	}
+	requirements, _ := selector.Requirements()
+	for _, requirement := range requirements {
+		if requirement.Key() != shardLabelKey {
+			return nil, fmt.Errorf("селектор шарда может использовать только лейбл %v, указан %v", shardLabelKey, requirement.Key())
+		}
+	}
+	return selector, nil
+}
+
+// ShardCacheSelectors возвращает фильтры кэша для объектов шарда. Namespace источников
+// не попадают в кэш, поэтому их лейблы читаются напрямую из API-сервера
+func ShardCacheSelectors(selector labels.Selector) cache.SelectorsByObject {
+	if selector == nil {
+		return nil
+	}
+	return cache.SelectorsByObject{
+		&platformv1.DynamicNamespace{}:     {Label: selector},
+		&platformv1.DynamicNamespacePool{}: {Label: selector},
+		&v1.Namespace{}:                    {Label: selector},
+		&v1.ResourceQuota{}:                {Label: selector},
+		&rbacv1.RoleBinding{}:              {Label: selector},
+		&networkingv1.NetworkPolicy{}:      {Label: selector},
+	}
+}
+
+// ShardLeaderElectionID выводит отдельный ID выбора лидера для шарда: экземпляры разных шардов
+// работают одновременно, а реплики одного шарда по-прежнему выбирают одного лидера
+func ShardLeaderElectionID(base string, selector labels.Selector) string {
+	if selector == nil {
+		return base
+	}
+	var hash = fnv.New32a()
+	_, _ = hash.Write([]byte(selector.String()))
+	var suffix = fmt.Sprintf("%08x", hash.Sum32())
+
+	// Читаемая часть из значений селектора, например shard-blue-1a2b3c4d.a716b450.cloudnative.space
+	var name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(strings.ReplaceAll(selector.String(), shardLabelKey, "")))
+	name = strings.Join(strings.FieldsFunc(name, func(r rune) bool { return r == '-' }), "-")
+	var limit = validation.DNS1123SubdomainMaxLength - len(base) - len(suffix) - len("shard--.")
+	if limit < 0 {
+		limit = 0
+	}
+	if len(name) > limit {
+		name = strings.Trim(name[:limit], "-")
+	}
+	if name == "" {
+		return fmt.Sprintf("shard-%v.%v", suffix, base)
+	}
+	return fmt.Sprintf("shard-%v-%v.%v", name, suffix, base)
+}
+
+// shardLabels возвращает лейбл шарда ресурса для созданных им объектов
+func shardLabels(source map[string]string) map[string]string {
+	var labels = map[string]string{}
+	if shard, ok := source[shardLabelKey]; ok {
+		labels[shardLabelKey] = shard
+	}
+	return labels
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestParseShard(t *testing.T) {
+	var tests = []struct {
+		value string
+		want  string
+		ok    bool
+	}{
+		{value: "", ok: true},
+		{value: "  ", ok: true},
+		{value: shardLabelKey + "=blue", want: shardLabelKey + "=blue", ok: true},
+		{value: shardLabelKey + " in (blue,green)", want: shardLabelKey + " in (blue,green)", ok: true},
+		{value: "!" + shardLabelKey, want: "!" + shardLabelKey, ok: true},
+		{value: "team=a"},
+		{value: shardLabelKey + "=blue,team=a"},
+		{value: shardLabelKey + " in blue"},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			selector, err := ParseShard(test.value)
+			if (err == nil) != test.ok {
+				t.Fatalf("ошибка %v, ожидался успех %v", err, test.ok)
+			}
+			var got string
+			if selector != nil {
+				got = selector.String()
+			}
+			if got != test.want {
+				t.Errorf("ParseShard(%q) = %q, ожидалось %q", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestShardLeaderElectionID(t *testing.T) {
+	const base = "a716b450.cloudnative.space"
+	var tests = []struct {
+		name   string
+		base   string
+		shard  string
+		prefix string
+	}{
+		{name: "без шарда", base: base, prefix: base},
+		{name: "значение шарда в имени", base: base, shard: shardLabelKey + "=blue", prefix: "shard-blue-"},
+		{name: "несколько значений", base: base, shard: shardLabelKey + " in (blue,green)", prefix: "shard-in-blue-green-"},
+		{name: "длинное значение обрезается", base: base, shard: shardLabelKey + "=" + strings.Repeat("a", 63), prefix: "shard-aaa"},
+		{name: "длинный базовый ID", base: strings.Repeat("b", 240) + ".example.com", shard: shardLabelKey + "=blue", prefix: "shard-"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selector, err := ParseShard(test.shard)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got = ShardLeaderElectionID(test.base, selector)
+			if !strings.HasPrefix(got, test.prefix) {
+				t.Errorf("ShardLeaderElectionID() = %v, ожидалось начало %v", got, test.prefix)
+			}
+			if selector != nil && !strings.HasSuffix(got, "."+test.base) {
+				t.Errorf("ShardLeaderElectionID() = %v, ожидалось окончание .%v", got, test.base)
+			}
+			if len(test.base) < 200 {
+				if errs := validation.IsDNS1123Subdomain(got); len(errs) > 0 {
+					t.Errorf("ShardLeaderElectionID() = %v: %v", got, errs)
+				}
+			}
+		})
+	}
+
+	blue, _ := ParseShard(shardLabelKey + "=blue")
+	green, _ := ParseShard(shardLabelKey + "=green")
+	if ShardLeaderElectionID(base, blue) == ShardLeaderElectionID(base, green) {
+		t.Error("разные шарды получили один ID выбора лидера")
+	}
+}
@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// rejectedRequeue - период повторной проверки отклоненных ресурсов: namespace могут
+// разрешить изменением конфигурации или лейблов
+const rejectedRequeue = 5 * time.Minute
+
+// sourceRejection возвращает причину, по которой ресурсы из namespace не обрабатываются,
+// или nil, если namespace разрешен списком sourceNamespaces и sourceNamespaceSelector
+func sourceRejection(ctx context.Context, reader client.Reader, namespace string, settings *Settings) (*messages.Message, error) {
+	if len(settings.SourceNamespaces) > 0 && !containsString(settings.SourceNamespaces, namespace) {
+		var rejection = messages.New(messages.NamespaceNotAllowed, namespace, strings.Join(settings.SourceNamespaces, ", "))
+		return &rejection, nil
+	}
+	if settings.SourceNamespaceSelector == nil || settings.SourceNamespaceSelector.Empty() {
+		return nil, nil
+	}
+	var source v1.Namespace
+	err := reader.Get(ctx, types.NamespacedName{Name: namespace}, &source)
+	if err != nil {
+		return nil, err
+	}
+	if !settings.SourceNamespaceSelector.Matches(labels.Set(source.GetLabels())) {
+		var rejection = messages.New(messages.NamespaceSelectorMismatch, namespace, settings.SourceNamespaceSelector.String())
+		return &rejection, nil
+	}
+	return nil, nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
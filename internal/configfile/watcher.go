@@ -0,0 +1,129 @@
+package configfile
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/go-logr/logr"
+	configv1alpha1 "github.com/wbe7/dynamicnamespace/api/config/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/wait"
+	componentbaseconfigv1alpha1 "k8s.io/component-base/config/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// DefaultInterval - период проверки файла конфигурации по умолчанию
+const DefaultInterval = 10 * time.Second
+
+// Watcher re-reads the controller configuration file and passes every changed
+// version to OnChange. The file is polled rather than watched, so ConfigMap
+// updates, which replace a symlink, are picked up as well
+type Watcher struct {
+	// Путь к файлу конфигурации
+	Path string
+	// Схема, в которой зарегистрирован DynamicNamespaceConfig
+	Scheme *runtime.Scheme
+	// Период проверки файла
+	Interval time.Duration
+	// OnChange применяет новую конфигурацию. Ошибка оставляет прежнюю конфигурацию в силе
+	OnChange func(config *configv1alpha1.DynamicNamespaceConfig) error
+
+	checksum [sha256.Size]byte
+	log      logr.Logger
+}
+
+// SetupWithManager registers the watcher as a manager runnable.
+func (w *Watcher) SetupWithManager(mgr ctrl.Manager) error {
+	w.log = ctrl.Log.WithName("config")
+	if w.Path == "" {
+		return nil
+	}
+	if w.Scheme == nil {
+		w.Scheme = mgr.GetScheme()
+	}
+	if w.Interval <= 0 {
+		w.Interval = DefaultInterval
+	}
+	return mgr.Add(w)
+}
+
+// NeedLeaderElection реализует manager.LeaderElectionRunnable: настройки нужны всем репликам
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Start проверяет файл до остановки менеджера
+func (w *Watcher) Start(ctx context.Context) error {
+	w.log.Info("Отслеживание файла конфигурации", "path", w.Path, "interval", w.Interval.String())
+	wait.UntilWithContext(ctx, w.reload, w.Interval)
+	return nil
+}
+
+func (w *Watcher) reload(ctx context.Context) {
+	data, err := ioutil.ReadFile(w.Path)
+	if err != nil {
+		w.log.Error(err, "Ошибка при чтении файла конфигурации", "path", w.Path)
+		return
+	}
+	var checksum = sha256.Sum256(data)
+	if checksum == w.checksum {
+		return
+	}
+	config, err := Decode(w.Scheme, data)
+	if err != nil {
+		w.log.Error(err, "Ошибка в файле конфигурации, действует прежняя конфигурация", "path", w.Path)
+		return
+	}
+	err = w.OnChange(config)
+	if err != nil {
+		w.log.Error(err, "Конфигурация не применена, действует прежняя", "path", w.Path)
+		return
+	}
+	w.checksum = checksum
+	w.log.Info("Конфигурация применена", "path", w.Path)
+}
+
+// ManagerOptions накладывает настройки менеджера из файла конфигурации на параметры командной строки.
+// Значения, которых нет в файле, например порт webhook и ID leader election, остаются из flags;
+// явно заданные параметры командной строки из explicit важнее файла
+func ManagerOptions(flags ctrl.Options, config *configv1alpha1.DynamicNamespaceConfig, explicit map[string]bool) (ctrl.Options, error) {
+	// AndFrom читает leaderElection без проверки на nil
+	if config.LeaderElection == nil {
+		config = config.DeepCopy()
+		config.LeaderElection = &componentbaseconfigv1alpha1.LeaderElectionConfiguration{}
+	}
+	options, err := ctrl.Options{Scheme: flags.Scheme}.AndFrom(config)
+	if err != nil {
+		return flags, err
+	}
+	if options.MetricsBindAddress == "" || explicit["metrics-bind-address"] {
+		options.MetricsBindAddress = flags.MetricsBindAddress
+	}
+	if options.HealthProbeBindAddress == "" || explicit["health-probe-bind-address"] {
+		options.HealthProbeBindAddress = flags.HealthProbeBindAddress
+	}
+	if config.LeaderElection.LeaderElect == nil || explicit["leader-elect"] {
+		options.LeaderElection = flags.LeaderElection
+	}
+	if options.LeaderElectionID == "" {
+		options.LeaderElectionID = flags.LeaderElectionID
+	}
+	if options.Port == 0 {
+		options.Port = flags.Port
+	}
+	return options, nil
+}
+
+// Decode разбирает файл конфигурации контроллера
+func Decode(scheme *runtime.Scheme, data []byte) (*configv1alpha1.DynamicNamespaceConfig, error) {
+	var config configv1alpha1.DynamicNamespaceConfig
+	err := runtime.DecodeInto(serializer.NewCodecFactory(scheme).UniversalDecoder(), data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при разборе конфигурации: %v", err)
+	}
+	return &config, nil
+}
@@ -0,0 +1,112 @@
+package configfile
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	configv1alpha1 "github.com/wbe7/dynamicnamespace/api/config/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func testScheme(t *testing.T) *runtime.Scheme {
+	var scheme = runtime.NewScheme()
+	if err := configv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func TestManagerOptions(t *testing.T) {
+	var flags = ctrl.Options{
+		MetricsBindAddress:     ":8080",
+		Port:                   9443,
+		HealthProbeBindAddress: ":8081",
+		LeaderElectionID:       "a716b450.cloudnative.space",
+	}
+	var tests = []struct {
+		name     string
+		file     string
+		explicit map[string]bool
+		want     ctrl.Options
+	}{
+		{
+			name: "файл без настроек менеджера",
+			file: "language: ru\n",
+			want: flags,
+		},
+		{
+			name: "файл важнее значений по умолчанию",
+			file: "metrics:\n  bindAddress: :9090\nwebhook:\n  port: 9444\nleaderElection:\n  leaderElect: true\n  resourceName: dn.example.com\n",
+			want: ctrl.Options{MetricsBindAddress: ":9090", Port: 9444, HealthProbeBindAddress: ":8081", LeaderElection: true, LeaderElectionID: "dn.example.com"},
+		},
+		{
+			name:     "явно заданные параметры важнее файла",
+			file:     "metrics:\n  bindAddress: :9090\nleaderElection:\n  leaderElect: true\n",
+			explicit: map[string]bool{"metrics-bind-address": true, "leader-elect": true},
+			want:     flags,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := Decode(testScheme(t), []byte("apiVersion: config.platform.cloudnative.space/v1alpha1\nkind: DynamicNamespaceConfig\n"+test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ManagerOptions(flags, config, test.explicit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.MetricsBindAddress != test.want.MetricsBindAddress || got.Port != test.want.Port ||
+				got.HealthProbeBindAddress != test.want.HealthProbeBindAddress ||
+				got.LeaderElection != test.want.LeaderElection || got.LeaderElectionID != test.want.LeaderElectionID {
+				t.Errorf("ManagerOptions() = %+v, ожидалось %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWatcherReload(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "config.yaml")
+	var applied []string
+	var reject bool
+	var w = &Watcher{
+		Path:   path,
+		Scheme: testScheme(t),
+		OnChange: func(config *configv1alpha1.DynamicNamespaceConfig) error {
+			if reject {
+				return errors.New("отклонено")
+			}
+			applied = append(applied, config.Language)
+			return nil
+		},
+		log: logr.Discard(),
+	}
+	var steps = []struct {
+		name    string
+		file    string
+		reject  bool
+		applied []string
+	}{
+		{name: "первая версия", file: "language: ru\n", applied: []string{"ru"}},
+		{name: "файл не изменился", file: "language: ru\n", applied: []string{"ru"}},
+		{name: "некорректный файл", file: "language: [\n", applied: []string{"ru"}},
+		{name: "конфигурация отклонена", file: "language: en\n", reject: true, applied: []string{"ru"}},
+		{name: "та же версия принята позже", file: "language: en\n", applied: []string{"ru", "en"}},
+	}
+	for _, step := range steps {
+		err := ioutil.WriteFile(path, []byte("apiVersion: config.platform.cloudnative.space/v1alpha1\nkind: DynamicNamespaceConfig\n"+step.file), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reject = step.reject
+		w.reload(context.Background())
+		if len(applied) != len(step.applied) || applied[len(applied)-1] != step.applied[len(step.applied)-1] {
+			t.Errorf("%v: применены %v, ожидалось %v", step.name, applied, step.applied)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package gitwebhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+)
+
+const (
+	providerGitLab = "GitLab"
+	providerGitHub = "GitHub"
+
+	// Максимальная длина DNS-метки
+	maxNameLength = 63
+)
+
+// eventKind - действие с окружением, которое следует из события
+type eventKind string
+
+const (
+	// Открыт или переоткрыт merge request - окружение создается
+	eventOpen eventKind = "open"
+	// Merge request слит или закрыт, ветка удалена - окружение удаляется
+	eventClose eventKind = "close"
+	// Push в ветку - продлевает активность окружения
+	eventPush eventKind = "push"
+	// Событие не влияет на окружения
+	eventIgnore eventKind = "ignore"
+)
+
+// event - событие Git, приведенное к общему виду для всех провайдеров
+type event struct {
+	kind       eventKind
+	repository string
+	branch     string
+	// Ключ окружения <namespace>/<имя>, заполняется при обработке события
+	environment string
+}
+
+// verify проверяет подпись события общим секретом
+func verify(provider string, header http.Header, body []byte, secret []byte) error {
+	switch provider {
+	case providerGitLab:
+		var token = header.Get("X-Gitlab-Token")
+		if subtle.ConstantTimeCompare([]byte(token), secret) != 1 {
+			return messages.Errorf(messages.GitLabTokenInvalid)
+		}
+		return nil
+	case providerGitHub:
+		if signature := header.Get("X-Hub-Signature-256"); signature != "" {
+			return verifyHMAC(signature, "sha256=", body, secret, sha256Sum)
+		}
+		if signature := header.Get("X-Hub-Signature"); signature != "" {
+			return verifyHMAC(signature, "sha1=", body, secret, sha1Sum)
+		}
+		return messages.Errorf(messages.SignatureMissing)
+	}
+	return messages.Errorf(messages.ProviderUnknown, provider)
+}
+
+func verifyHMAC(signature, prefix string, body, secret []byte, sum func(secret, body []byte) []byte) error {
+	if !strings.HasPrefix(signature, prefix) {
+		return messages.Errorf(messages.SignatureMalformed)
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return messages.Errorf(messages.SignatureMalformed)
+	}
+	if !hmac.Equal(expected, sum(secret, body)) {
+		return messages.Errorf(messages.SignatureMismatch)
+	}
+	return nil
+}
+
+func sha256Sum(secret, body []byte) []byte {
+	var mac = hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func sha1Sum(secret, body []byte) []byte {
+	var mac = hmac.New(sha1.New, secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// parseEvent разбирает событие провайдера
+func parseEvent(provider string, header http.Header, body []byte) (*event, error) {
+	switch provider {
+	case providerGitLab:
+		return parseGitLabEvent(header.Get("X-Gitlab-Event"), body)
+	case providerGitHub:
+		return parseGitHubEvent(header.Get("X-GitHub-Event"), body)
+	}
+	return nil, messages.Errorf(messages.ProviderUnknown, provider)
+}
+
+// zeroSHA - значение after в push-событии GitLab при удалении ветки
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+func parseGitLabEvent(name string, body []byte) (*event, error) {
+	switch name {
+	case "Merge Request Hook":
+		var payload struct {
+			Project struct {
+				PathWithNamespace string `json:"path_with_namespace"`
+			} `json:"project"`
+			ObjectAttributes struct {
+				Action       string `json:"action"`
+				SourceBranch string `json:"source_branch"`
+			} `json:"object_attributes"`
+		}
+		err := json.Unmarshal(body, &payload)
+		if err != nil {
+			return nil, err
+		}
+		var result = &event{
+			kind:       eventIgnore,
+			repository: payload.Project.PathWithNamespace,
+			branch:     payload.ObjectAttributes.SourceBranch,
+		}
+		switch payload.ObjectAttributes.Action {
+		case "open", "reopen":
+			result.kind = eventOpen
+		case "merge", "close":
+			result.kind = eventClose
+		case "update":
+			result.kind = eventPush
+		}
+		return result, nil
+	case "Push Hook":
+		var payload struct {
+			Ref     string `json:"ref"`
+			After   string `json:"after"`
+			Project struct {
+				PathWithNamespace string `json:"path_with_namespace"`
+			} `json:"project"`
+		}
+		err := json.Unmarshal(body, &payload)
+		if err != nil {
+			return nil, err
+		}
+		var result = &event{
+			kind:       eventPush,
+			repository: payload.Project.PathWithNamespace,
+			branch:     strings.TrimPrefix(payload.Ref, "refs/heads/"),
+		}
+		if !strings.HasPrefix(payload.Ref, "refs/heads/") {
+			result.kind = eventIgnore
+		} else if payload.After == zeroSHA {
+			result.kind = eventClose
+		}
+		return result, nil
+	}
+	return &event{kind: eventIgnore}, nil
+}
+
+func parseGitHubEvent(name string, body []byte) (*event, error) {
+	var repository struct {
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	err := json.Unmarshal(body, &repository)
+	if err != nil {
+		return nil, err
+	}
+	var result = &event{kind: eventIgnore, repository: repository.Repository.FullName}
+
+	switch name {
+	case "pull_request":
+		var payload struct {
+			Action      string `json:"action"`
+			PullRequest struct {
+				Head struct {
+					Ref string `json:"ref"`
+				} `json:"head"`
+			} `json:"pull_request"`
+		}
+		err = json.Unmarshal(body, &payload)
+		if err != nil {
+			return nil, err
+		}
+		result.branch = payload.PullRequest.Head.Ref
+		switch payload.Action {
+		case "opened", "reopened":
+			result.kind = eventOpen
+		case "closed":
+			result.kind = eventClose
+		case "synchronize":
+			result.kind = eventPush
+		}
+	case "push":
+		var payload struct {
+			Ref     string `json:"ref"`
+			Deleted bool   `json:"deleted"`
+		}
+		err = json.Unmarshal(body, &payload)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(payload.Ref, "refs/heads/") {
+			result.branch = strings.TrimPrefix(payload.Ref, "refs/heads/")
+			result.kind = eventPush
+			if payload.Deleted {
+				result.kind = eventClose
+			}
+		}
+	case "delete":
+		var payload struct {
+			Ref     string `json:"ref"`
+			RefType string `json:"ref_type"`
+		}
+		err = json.Unmarshal(body, &payload)
+		if err != nil {
+			return nil, err
+		}
+		if payload.RefType == "branch" {
+			result.branch = payload.Ref
+			result.kind = eventClose
+		}
+	}
+	return result, nil
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Длина хэша ветки в имени окружения
+const nameHashLength = 8
+
+// environmentName приводит префикс и имя ветки к DNS-метке не длиннее limit. К имени всегда
+// добавляется хэш исходной ветки: feature/foo и feature-foo приводятся к одной метке, но получают
+// разные окружения
+func environmentName(prefix, branch string, limit int) string {
+	var name = invalidNameChars.ReplaceAllString(strings.ToLower(prefix+branch), "-")
+	var hash = sha1.Sum([]byte(prefix + branch))
+	var suffix = hex.EncodeToString(hash[:])[:nameHashLength]
+	if limit > maxNameLength {
+		limit = maxNameLength
+	}
+	limit -= len(suffix) + 1
+	if limit < 0 {
+		limit = 0
+	}
+	if len(name) > limit {
+		name = name[:limit]
+	}
+	name = strings.Trim(name, "-")
+	if name == "" {
+		return "env-" + suffix
+	}
+	return name + "-" + suffix
+}
+
+// nameLimit возвращает максимальную длину имени окружения в namespace: лейбл created-by
+// <namespace>.<имя> и имена дочерних namespace <имя>-<child> должны оставаться не длиннее 63 символов
+func nameLimit(namespace string, template *platformv1.DynamicNamespaceSpec) int {
+	var limit = maxNameLength - len(namespace) - 1
+	for _, child := range template.Children {
+		if childLimit := maxNameLength - len(child.Name) - 1; childLimit < limit {
+			limit = childLimit
+		}
+	}
+	return limit
+}
@@ -0,0 +1,130 @@
+package gitwebhook
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestEnvironmentName(t *testing.T) {
+	var tests = []struct {
+		name   string
+		prefix string
+		branch string
+		limit  int
+		want   string
+	}{
+		{name: "short branch", prefix: "review-", branch: "feature/foo", limit: 63, want: "review-feature-foo-"},
+		{name: "uppercase and symbols", branch: "Feature/ABC_123", limit: 63, want: "feature-abc-123-"},
+		{name: "only symbols", branch: "///", limit: 63, want: "env-"},
+		{name: "long branch", branch: strings.Repeat("a", 100), limit: 40, want: strings.Repeat("a", 31) + "-"},
+		{name: "limit above label length", branch: strings.Repeat("b", 100), limit: 100, want: strings.Repeat("b", 54) + "-"},
+		{name: "trailing dash after cut", branch: strings.Repeat("c", 20) + "/" + strings.Repeat("d", 20), limit: 30, want: strings.Repeat("c", 20) + "-"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got = environmentName(test.prefix, test.branch, test.limit)
+			if !strings.HasPrefix(got, test.want) || len(got) != len(test.want)+nameHashLength {
+				t.Errorf("environmentName() = %v, ожидалось %v<хэш>", got, test.want)
+			}
+			if _, err := hex.DecodeString(got[len(got)-nameHashLength:]); err != nil {
+				t.Errorf("environmentName() = %v, ожидался хэш в конце", got)
+			}
+			if len(got) > test.limit {
+				t.Errorf("len(%v) = %v, ожидалось не больше %v", got, len(got), test.limit)
+			}
+			if errs := validation.IsDNS1123Label(got); len(errs) > 0 {
+				t.Errorf("environmentName() = %v: %v", got, errs)
+			}
+		})
+	}
+
+	if environmentName("", "feature/foo", 63) == environmentName("", "feature-foo", 63) {
+		t.Error("feature/foo и feature-foo получили одно имя окружения")
+	}
+}
+
+func TestNameLimit(t *testing.T) {
+	var tests = []struct {
+		name      string
+		namespace string
+		children  []string
+		want      int
+	}{
+		{name: "created-by label", namespace: "team-a", want: 56},
+		{name: "longest child", namespace: "team-a", children: []string{"db", "observability"}, want: 49},
+		{name: "namespace longer than child", namespace: strings.Repeat("n", 30), children: []string{"db"}, want: 32},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var template platformv1.DynamicNamespaceSpec
+			for _, child := range test.children {
+				template.Children = append(template.Children, platformv1.ChildNamespace{Name: child})
+			}
+			var got = nameLimit(test.namespace, &template)
+			if got != test.want {
+				t.Errorf("nameLimit() = %v, ожидалось %v", got, test.want)
+			}
+			var name = environmentName("", strings.Repeat("x", 100), got)
+			if label := test.namespace + "." + name; len(label) > maxNameLength {
+				t.Errorf("лейбл created-by %v длиннее %v символов", label, maxNameLength)
+			}
+			for _, child := range test.children {
+				if errs := validation.IsDNS1123Label(name + "-" + child); len(errs) > 0 {
+					t.Errorf("дочерний namespace %v-%v: %v", name, child, errs)
+				}
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	var secret = []byte("s3cr3t")
+	var body = []byte(`{"ref":"refs/heads/main"}`)
+	var tests = []struct {
+		name     string
+		provider string
+		header   map[string]string
+		reason   messages.Reason
+	}{
+		{name: "gitlab token", provider: providerGitLab, header: map[string]string{"X-Gitlab-Token": "s3cr3t"}},
+		{name: "gitlab wrong token", provider: providerGitLab, header: map[string]string{"X-Gitlab-Token": "guess"}, reason: messages.GitLabTokenInvalid},
+		{name: "gitlab no token", provider: providerGitLab, reason: messages.GitLabTokenInvalid},
+		{name: "github sha256", provider: providerGitHub,
+			header: map[string]string{"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(sha256Sum(secret, body))}},
+		{name: "github sha1", provider: providerGitHub,
+			header: map[string]string{"X-Hub-Signature": "sha1=" + hex.EncodeToString(sha1Sum(secret, body))}},
+		{name: "github wrong secret", provider: providerGitHub,
+			header: map[string]string{"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(sha256Sum([]byte("guess"), body))}, reason: messages.SignatureMismatch},
+		{name: "github wrong prefix", provider: providerGitHub,
+			header: map[string]string{"X-Hub-Signature-256": "sha1=" + hex.EncodeToString(sha256Sum(secret, body))}, reason: messages.SignatureMalformed},
+		{name: "github not hex", provider: providerGitHub, header: map[string]string{"X-Hub-Signature-256": "sha256=zz"}, reason: messages.SignatureMalformed},
+		{name: "github unsigned", provider: providerGitHub, reason: messages.SignatureMissing},
+		{name: "unknown provider", provider: "Bitbucket", header: map[string]string{"X-Gitlab-Token": "s3cr3t"}, reason: messages.ProviderUnknown},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var header = http.Header{}
+			for key, value := range test.header {
+				header.Set(key, value)
+			}
+			err := verify(test.provider, header, body, secret)
+			if test.reason == "" {
+				if err != nil {
+					t.Errorf("verify() = %v, ожидался успех", err)
+				}
+				return
+			}
+			var coded *messages.Error
+			if !errors.As(err, &coded) || coded.Reason != test.reason {
+				t.Errorf("verify() = %v, ожидался код %v", err, test.reason)
+			}
+		})
+	}
+}
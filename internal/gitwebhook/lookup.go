@@ -0,0 +1,95 @@
+package gitwebhook
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"golang.org/x/time/rate"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// Сколько прочитанные триггер и Secret используются без обращения к API-серверу
+	lookupTTL = 10 * time.Second
+	// Чтений триггеров и Secret из API-сервера в секунду и их запас на всплески событий
+	lookupRate  = 10
+	lookupBurst = 20
+)
+
+// lookup - прочитанный триггер и его секрет
+type lookup struct {
+	// Триггер; nil, если его нет
+	trigger *platformv1.DynamicNamespaceTrigger
+	secret  []byte
+	// Ошибка чтения секрета: событие с ней нельзя проверить
+	secretErr error
+	expires   time.Time
+}
+
+// lookupCache хранит прочитанные триггеры и секреты, в том числе отсутствие триггера, чтобы события
+// с неверной подписью или по несуществующим адресам не читали API-сервер на каждый запрос.
+// Промахи кэша ограничены общим limiter
+type lookupCache struct {
+	mu      sync.Mutex
+	entries map[types.NamespacedName]*lookup
+	limiter *rate.Limiter
+}
+
+func newLookupCache(limit rate.Limit, burst int) *lookupCache {
+	return &lookupCache{
+		entries: map[types.NamespacedName]*lookup{},
+		limiter: rate.NewLimiter(limit, burst),
+	}
+}
+
+// get возвращает неустаревшую запись
+func (c *lookupCache) get(key types.NamespacedName, now time.Time) (*lookup, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || now.After(entry.expires) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// put сохраняет запись и удаляет устаревшие: их число ограничено частотой промахов
+func (c *lookupCache) put(key types.NamespacedName, entry *lookup, now time.Time) *lookup {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for existing, cached := range c.entries {
+		if now.After(cached.expires) {
+			delete(c.entries, existing)
+		}
+	}
+	entry.expires = now.Add(lookupTTL)
+	c.entries[key] = entry
+	return entry
+}
+
+// lookup возвращает триггер и его секрет из кэша или читает их из API-сервера, если позволяет limiter.
+// Возвращаемый триггер общий для всех событий и не должен изменяться
+func (r *Receiver) lookup(ctx context.Context, key types.NamespacedName) (*lookup, error) {
+	var now = time.Now()
+	if entry, ok := r.lookups.get(key, now); ok {
+		return entry, nil
+	}
+	if !r.lookups.limiter.Allow() {
+		return nil, messages.Errorf(messages.TriggerLookupLimited)
+	}
+
+	var trigger platformv1.DynamicNamespaceTrigger
+	err := r.APIReader.Get(ctx, key, &trigger)
+	if kerrors.IsNotFound(err) {
+		return r.lookups.put(key, &lookup{}, now), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	secret, err := r.secret(ctx, &trigger)
+	return r.lookups.put(key, &lookup{trigger: &trigger, secret: secret, secretErr: err}, now), nil
+}
@@ -0,0 +1,377 @@
+package gitwebhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/config/crd"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"github.com/wbe7/dynamicnamespace/internal/platform"
+	"k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	hooksPath = "/hooks/"
+
+	// Максимальный размер тела события: события merge request и push GitLab и GitHub
+	// укладываются в несколько мегабайт
+	maxBodySize = 5 << 20
+)
+
+var (
+	// triggerLabelKey помечает DynamicNamespace именем создавшего его триггера
+	triggerLabelKey = platformv1.GroupVersion.Group + "/trigger"
+	// branchAnnotation хранит исходную ветку окружения
+	branchAnnotation = platformv1.GroupVersion.Group + "/branch"
+	// lastActivityAnnotation продлевает окружение с idleTimeout при push в ветку
+	lastActivityAnnotation = platformv1.GroupVersion.Group + "/last-activity"
+	// shardLabelKey распределяет DynamicNamespace по экземплярам оператора (--shard)
+	shardLabelKey = platformv1.GroupVersion.Group + "/shard"
+)
+
+// Receiver accepts GitLab and GitHub webhooks and creates or deletes
+// DynamicNamespaces according to DynamicNamespaceTrigger rules
+type Receiver struct {
+	client.Client
+	// APIReader читает триггеры и Secret напрямую из API-сервера, не кэшируя Secret всего кластера
+	APIReader client.Reader
+
+	// Адрес, на котором слушает приемник. Пустой адрес отключает приемник
+	BindAddress string
+	// Каталог с сертификатом tls.crt и ключом tls.key приемника
+	CertDir string
+	// Принимать события по HTTP, например за Ingress, который терминирует TLS
+	Insecure bool
+	// Установка CRD и ожидание его готовности
+	CRDs *platform.CRDInstaller
+	// Язык сообщений в статусе триггера; nil - язык по умолчанию
+	Language func() messages.Language
+
+	log     logr.Logger
+	lookups *lookupCache
+}
+
+// +kubebuilder:rbac:groups=platform.cloudnative.space,resources=dynamicnamespacetriggers,verbs=get;list;watch
+// +kubebuilder:rbac:groups=platform.cloudnative.space,resources=dynamicnamespacetriggers/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get
+
+// SetupWithManager deploys the DynamicNamespaceTrigger CRD and registers the receiver as a manager runnable.
+func (r *Receiver) SetupWithManager(mgr ctrl.Manager) error {
+	r.log = ctrl.Log.WithName("git-webhook")
+	if r.BindAddress != "" && r.CertDir == "" && !r.Insecure {
+		return errors.New("приемник событий Git без TLS передавал бы события открытым текстом: задайте каталог сертификата или явно разрешите HTTP")
+	}
+	r.lookups = newLookupCache(lookupRate, lookupBurst)
+	if r.APIReader == nil {
+		r.APIReader = mgr.GetAPIReader()
+	}
+
+	var ctx = ctrllog.IntoContext(context.Background(), r.log)
+
+	// Создание или обновление CRD ресурса
+	err := r.CRDs.Setup(ctx, platform.NewPlatformClient(mgr.GetConfig(), r.Client), crd.DynamicNamespaceTrigger, crd.Revision)
+	if err != nil {
+		return err
+	}
+
+	if r.BindAddress == "" {
+		return nil
+	}
+	return mgr.Add(r)
+}
+
+// NeedLeaderElection реализует manager.LeaderElectionRunnable: события принимаются всеми репликами
+func (r *Receiver) NeedLeaderElection() bool {
+	return false
+}
+
+// Start принимает события до остановки менеджера
+func (r *Receiver) Start(ctx context.Context) error {
+	var mux = http.NewServeMux()
+	mux.HandleFunc(hooksPath, r.handle)
+	var server = &http.Server{Addr: r.BindAddress, Handler: mux}
+
+	var errs = make(chan error, 1)
+	go func() {
+		r.log.Info("Запуск приемника событий Git", "address", r.BindAddress, "tls", r.CertDir != "")
+		var err error
+		if r.CertDir != "" {
+			err = server.ListenAndServeTLS(filepath.Join(r.CertDir, "tls.crt"), filepath.Join(r.CertDir, "tls.key"))
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			errs <- err
+		}
+		close(errs)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		var shutdownCtx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+// handle обрабатывает событие по адресу /hooks/<namespace>/<имя триггера>
+func (r *Receiver) handle(w http.ResponseWriter, req *http.Request) {
+	var language = r.language()
+	if req.Method != http.MethodPost {
+		writeMessage(w, http.StatusMethodNotAllowed, language, messages.New(messages.MethodNotAllowed))
+		return
+	}
+	var parts = strings.Split(strings.TrimPrefix(req.URL.Path, hooksPath), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		writeMessage(w, http.StatusNotFound, language, messages.New(messages.HookPathInvalid))
+		return
+	}
+	var log = r.log.WithValues("dynamicnamespacetrigger", parts[0]+"/"+parts[1])
+	var ctx = ctrllog.IntoContext(req.Context(), log)
+
+	// Триггер и секрет берутся из кэша, а промахи ограничены по частоте: события с чужой подписью
+	// и по несуществующим адресам не нагружают API-сервер
+	entry, err := r.lookup(ctx, types.NamespacedName{Namespace: parts[0], Name: parts[1]})
+	if err != nil {
+		if failure := messages.FromError(err); failure.Reason == messages.TriggerLookupLimited {
+			writeMessage(w, http.StatusTooManyRequests, language, failure)
+			return
+		}
+		log.Error(err, "Ошибка при чтении триггера")
+		writeMessage(w, http.StatusInternalServerError, language, messages.New(messages.TriggerReadFailed))
+		return
+	}
+	if entry.trigger == nil {
+		writeMessage(w, http.StatusNotFound, language, messages.New(messages.TriggerNotFound))
+		return
+	}
+	if entry.secretErr != nil {
+		log.Error(entry.secretErr, "Ошибка при чтении секрета триггера")
+		writeMessage(w, http.StatusInternalServerError, language, messages.New(messages.TriggerSecretFailed))
+		return
+	}
+	var trigger = entry.trigger.DeepCopy()
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxBodySize))
+	if err != nil {
+		writeMessage(w, http.StatusRequestEntityTooLarge, language, messages.New(messages.RequestBodyTooLarge, maxBodySize))
+		return
+	}
+	err = verify(trigger.Spec.Provider, req.Header, body, entry.secret)
+	if err != nil {
+		log.Info("Событие отклонено", "reason", err.Error())
+		writeMessage(w, http.StatusUnauthorized, language, messages.FromError(err))
+		return
+	}
+
+	e, err := parseEvent(trigger.Spec.Provider, req.Header, body)
+	if err != nil {
+		writeMessage(w, http.StatusBadRequest, language, messages.New(messages.EventInvalid, err))
+		return
+	}
+	message, err := r.process(ctx, trigger, e)
+	if err != nil {
+		log.Error(err, "Ошибка при обработке события", "dynamicnamespace", e.environment, "branch", e.branch)
+		var failure = messages.FromError(err)
+		r.updateTriggerStatus(ctx, trigger, failure.Reason, language.Text(failure))
+		writeMessage(w, http.StatusInternalServerError, language, failure)
+		return
+	}
+	if e.kind != eventIgnore {
+		log.Info(language.Text(message), "dynamicnamespace", e.environment, "branch", e.branch)
+		r.updateTriggerStatus(ctx, trigger, message.Reason, language.Text(message))
+	}
+	writeMessage(w, http.StatusOK, language, message)
+}
+
+// language возвращает текущий язык сообщений
+func (r *Receiver) language() messages.Language {
+	if r.Language == nil {
+		return messages.DefaultLanguage
+	}
+	return r.Language()
+}
+
+// process применяет событие к окружению ветки и возвращает описание результата
+func (r *Receiver) process(ctx context.Context, trigger *platformv1.DynamicNamespaceTrigger, e *event) (messages.Message, error) {
+	if e.kind == eventIgnore {
+		return messages.New(messages.EventIgnored), nil
+	}
+	if trigger.Spec.Repository != "" && trigger.Spec.Repository != e.repository {
+		e.kind = eventIgnore
+		return messages.New(messages.RepositoryNotServed, e.repository), nil
+	}
+	rule, err := matchRule(trigger, e.branch)
+	if err != nil {
+		return messages.Message{}, err
+	}
+	if rule == nil {
+		e.kind = eventIgnore
+		return messages.New(messages.BranchNotMatched, e.branch), nil
+	}
+
+	var name = environmentName(rule.NamePrefix, e.branch, nameLimit(trigger.Namespace, &rule.Template))
+	e.environment = trigger.Namespace + "/" + name
+	switch e.kind {
+	case eventOpen:
+		// Окружение попадает в шард триггера
+		var resourceLabels = map[string]string{triggerLabelKey: trigger.Name}
+		if shard, ok := trigger.Labels[shardLabelKey]; ok {
+			resourceLabels[shardLabelKey] = shard
+		}
+		var resource = &platformv1.DynamicNamespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   trigger.Namespace,
+				Labels:      resourceLabels,
+				Annotations: map[string]string{branchAnnotation: e.branch},
+			},
+			Spec: *rule.Template.DeepCopy(),
+		}
+		err = r.Create(ctx, resource)
+		if kerrors.IsAlreadyExists(err) {
+			return messages.New(messages.EnvironmentExists, name, e.branch), nil
+		}
+		if err != nil {
+			return messages.Message{}, err
+		}
+		return messages.New(messages.EnvironmentCreated, name, e.branch), nil
+
+	case eventClose:
+		resource, err := r.owned(ctx, trigger, name)
+		if err != nil || resource == nil {
+			return messages.New(messages.EnvironmentNotFound, name, e.branch), err
+		}
+		err = r.Delete(ctx, resource)
+		if err != nil {
+			return messages.Message{}, client.IgnoreNotFound(err)
+		}
+		return messages.New(messages.EnvironmentDeleted, name, e.branch), nil
+
+	case eventPush:
+		resource, err := r.owned(ctx, trigger, name)
+		if err != nil || resource == nil {
+			e.kind = eventIgnore
+			return messages.New(messages.EnvironmentNotFound, name, e.branch), err
+		}
+		var patch = client.MergeFrom(resource.DeepCopy())
+		if resource.Annotations == nil {
+			resource.Annotations = map[string]string{}
+		}
+		resource.Annotations[lastActivityAnnotation] = time.Now().UTC().Format(time.RFC3339)
+		err = r.Patch(ctx, resource, patch)
+		if err != nil {
+			return messages.Message{}, err
+		}
+		return messages.New(messages.EnvironmentActivity, name, e.branch), nil
+	}
+	return messages.Message{}, nil
+}
+
+// owned возвращает окружение, созданное триггером, или nil, если его нет
+func (r *Receiver) owned(ctx context.Context, trigger *platformv1.DynamicNamespaceTrigger, name string) (*platformv1.DynamicNamespace, error) {
+	var resource platformv1.DynamicNamespace
+	err := r.APIReader.Get(ctx, types.NamespacedName{Namespace: trigger.Namespace, Name: name}, &resource)
+	if err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	if resource.Labels[triggerLabelKey] != trigger.Name || resource.GetDeletionTimestamp() != nil {
+		return nil, nil
+	}
+	return &resource, nil
+}
+
+// branchPatterns хранит скомпилированные шаблоны веток, чтобы не компилировать их на каждое событие
+var branchPatterns sync.Map
+
+// branchPattern компилирует шаблон ветки один раз
+func branchPattern(expr string) (*regexp.Regexp, error) {
+	if pattern, ok := branchPatterns.Load(expr); ok {
+		return pattern.(*regexp.Regexp), nil
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	branchPatterns.Store(expr, pattern)
+	return pattern, nil
+}
+
+// matchRule возвращает первое правило, под которое подходит ветка
+func matchRule(trigger *platformv1.DynamicNamespaceTrigger, branch string) (*platformv1.TriggerRule, error) {
+	if branch == "" {
+		return nil, nil
+	}
+	for i := range trigger.Spec.Rules {
+		var rule = &trigger.Spec.Rules[i]
+		if rule.Branch == "" {
+			return rule, nil
+		}
+		pattern, err := branchPattern(rule.Branch)
+		if err != nil {
+			return nil, messages.Errorf(messages.BranchPatternInvalid, rule.Branch, err)
+		}
+		if pattern.MatchString(branch) {
+			return rule, nil
+		}
+	}
+	return nil, nil
+}
+
+// secret читает общий секрет триггера
+func (r *Receiver) secret(ctx context.Context, trigger *platformv1.DynamicNamespaceTrigger) ([]byte, error) {
+	var secret v1.Secret
+	err := r.APIReader.Get(ctx, types.NamespacedName{Namespace: trigger.Namespace, Name: trigger.Spec.SecretRef.Name}, &secret)
+	if err != nil {
+		return nil, err
+	}
+	var key = trigger.Spec.SecretRef.Key
+	if key == "" {
+		key = "token"
+	}
+	value, ok := secret.Data[key]
+	if !ok || len(value) == 0 {
+		return nil, fmt.Errorf("в Secret %v нет ключа %v", secret.Name, key)
+	}
+	return value, nil
+}
+
+// updateTriggerStatus записывает результат события в статус триггера. Триггер может быть взят из кэша,
+// поэтому статус обновляется патчем без проверки resourceVersion
+func (r *Receiver) updateTriggerStatus(ctx context.Context, trigger *platformv1.DynamicNamespaceTrigger, reason messages.Reason, message string) {
+	var patch = client.MergeFrom(trigger.DeepCopy())
+	var now = metav1.Now()
+	trigger.Status.LastEventTime = &now
+	trigger.Status.Reason = string(reason)
+	trigger.Status.Message = message
+	err := r.Status().Patch(ctx, trigger, patch)
+	if err != nil {
+		ctrllog.FromContext(ctx).Error(err, "Ошибка при обновлении статуса триггера")
+	}
+}
+
+// writeMessage отвечает переведенным текстом сообщения и его кодом
+func writeMessage(w http.ResponseWriter, code int, language messages.Language, message messages.Message) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": language.Text(message), "reason": string(message.Reason)})
+}
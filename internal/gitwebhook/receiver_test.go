@@ -0,0 +1,147 @@
+package gitwebhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"golang.org/x/time/rate"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testScheme(t *testing.T) *runtime.Scheme {
+	var scheme = runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := platformv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func TestProcessShard(t *testing.T) {
+	var tests = []struct {
+		name   string
+		labels map[string]string
+		shard  string
+		shared bool
+	}{
+		{name: "sharded trigger", labels: map[string]string{shardLabelKey: "blue"}, shard: "blue", shared: true},
+		{name: "trigger without shard"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var c = fake.NewClientBuilder().WithScheme(testScheme(t)).Build()
+			var r = &Receiver{Client: c, APIReader: c}
+			var trigger = &platformv1.DynamicNamespaceTrigger{
+				ObjectMeta: metav1.ObjectMeta{Name: "review", Namespace: "team-a", Labels: test.labels},
+				Spec:       platformv1.DynamicNamespaceTriggerSpec{Rules: []platformv1.TriggerRule{{NamePrefix: "review-"}}},
+			}
+			if _, err := r.process(context.Background(), trigger, &event{kind: eventOpen, branch: "feature/foo"}); err != nil {
+				t.Fatal(err)
+			}
+
+			var resources platformv1.DynamicNamespaceList
+			if err := c.List(context.Background(), &resources); err != nil {
+				t.Fatal(err)
+			}
+			if len(resources.Items) != 1 {
+				t.Fatalf("создано окружений: %v, ожидалось 1", len(resources.Items))
+			}
+			var labels = resources.Items[0].Labels
+			if labels[triggerLabelKey] != trigger.Name {
+				t.Errorf("лейблы %v, ожидался лейбл триггера", labels)
+			}
+			if shard, ok := labels[shardLabelKey]; ok != test.shared || shard != test.shard {
+				t.Errorf("лейблы %v, ожидался шард %q", labels, test.shard)
+			}
+		})
+	}
+}
+
+// countingReader считает чтения из API-сервера
+type countingReader struct {
+	client.Reader
+	reads int
+}
+
+func (r *countingReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	r.reads++
+	return r.Reader.Get(ctx, key, obj)
+}
+
+// Запросы выполняются по порядку одним приемником: триггер и секрет читаются один раз,
+// а промахи кэша сверх лимита отклоняются без обращения к API-серверу
+func TestHandleLookups(t *testing.T) {
+	var steps = []struct {
+		name  string
+		path  string
+		token string
+		body  string
+		code  int
+		reads int
+	}{
+		{name: "wrong token", path: "/hooks/team-a/review", token: "wrong", body: "{}", code: http.StatusUnauthorized, reads: 2},
+		{name: "wrong token from cache", path: "/hooks/team-a/review", token: "wrong", body: "{}", code: http.StatusUnauthorized, reads: 2},
+		{name: "unknown trigger", path: "/hooks/team-a/missing", code: http.StatusNotFound, reads: 3},
+		{name: "unknown trigger from cache", path: "/hooks/team-a/missing", code: http.StatusNotFound, reads: 3},
+		{name: "lookups over the limit", path: "/hooks/team-a/other", code: http.StatusTooManyRequests, reads: 3},
+		{name: "body over the limit", path: "/hooks/team-a/review", token: "secret", body: strings.Repeat("x", maxBodySize+1), code: http.StatusRequestEntityTooLarge, reads: 3},
+	}
+	var objects = []client.Object{
+		&platformv1.DynamicNamespaceTrigger{
+			ObjectMeta: metav1.ObjectMeta{Name: "review", Namespace: "team-a"},
+			Spec: platformv1.DynamicNamespaceTriggerSpec{
+				Provider:  providerGitLab,
+				SecretRef: platformv1.SecretKeyReference{Name: "gitlab"},
+				Rules:     []platformv1.TriggerRule{{}},
+			},
+		},
+		&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "gitlab", Namespace: "team-a"}, Data: map[string][]byte{"token": []byte("secret")}},
+	}
+	var c = fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(objects...).Build()
+	var reader = &countingReader{Reader: c}
+	// Запаса хватает на два промаха, новые чтения за время теста не накапливаются
+	var r = &Receiver{Client: c, APIReader: reader, log: logr.Discard(), lookups: newLookupCache(rate.Limit(0.001), 2)}
+
+	for _, step := range steps {
+		var request = httptest.NewRequest(http.MethodPost, step.path, strings.NewReader(step.body))
+		request.Header.Set("X-Gitlab-Token", step.token)
+		request.Header.Set("X-Gitlab-Event", "Merge Request Hook")
+		var recorder = httptest.NewRecorder()
+		r.handle(recorder, request)
+		if recorder.Code != step.code {
+			t.Errorf("%v: код ответа %v, ожидался %v: %v", step.name, recorder.Code, step.code, recorder.Body.String())
+		}
+		if reader.reads != step.reads {
+			t.Errorf("%v: чтений из API-сервера %v, ожидалось %v", step.name, reader.reads, step.reads)
+		}
+	}
+}
+
+func TestSetupRequiresTLS(t *testing.T) {
+	var tests = []struct {
+		name     string
+		receiver Receiver
+	}{
+		{name: "no TLS", receiver: Receiver{BindAddress: ":8083"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Проверка выполняется до обращения к менеджеру
+			if err := test.receiver.SetupWithManager(nil); err == nil {
+				t.Error("SetupWithManager без ошибки, ожидалась ошибка")
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+const (
+	// DefaultReconcileTimeout - время, после которого незавершенный цикл обработки считается зависшим
+	DefaultReconcileTimeout = 10 * time.Minute
+
+	checkTimeout = 5 * time.Second
+)
+
+// CacheSynced возвращает проверку синхронизации кэшей информеров менеджера
+func CacheSynced(informers cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		var ctx, cancel = context.WithTimeout(req.Context(), checkTimeout)
+		defer cancel()
+		if !informers.WaitForCacheSync(ctx) {
+			return fmt.Errorf("кэши информеров не синхронизированы")
+		}
+		return nil
+	}
+}
+
+// APIServer возвращает проверку доступности API-сервера
+func APIServer(config *rest.Config) (healthz.Checker, error) {
+	client, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return func(req *http.Request) error {
+		var ctx, cancel = context.WithTimeout(req.Context(), checkTimeout)
+		defer cancel()
+		err := client.RESTClient().Get().AbsPath("/readyz").Do(ctx).Error()
+		if err != nil {
+			return fmt.Errorf("API-сервер недоступен: %v", err)
+		}
+		return nil
+	}, nil
+}
+
+// Watchdog tracks in-flight reconcile loops and reports the process as unhealthy
+// when one of them runs longer than Timeout
+type Watchdog struct {
+	// Максимальная длительность одного цикла обработки
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	next     uint64
+	inflight map[uint64]time.Time
+}
+
+// Begin отмечает начало цикла обработки и возвращает функцию, отмечающую его завершение.
+// Безопасен для nil
+func (w *Watchdog) Begin() func() {
+	if w == nil {
+		return func() {}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.inflight == nil {
+		w.inflight = map[uint64]time.Time{}
+	}
+	w.next++
+	var id = w.next
+	w.inflight[id] = time.Now()
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.inflight, id)
+	}
+}
+
+// Check реализует healthz.Checker: ни один цикл обработки не выполняется дольше Timeout
+func (w *Watchdog) Check(_ *http.Request) error {
+	var timeout = w.Timeout
+	if timeout <= 0 {
+		timeout = DefaultReconcileTimeout
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, started := range w.inflight {
+		if elapsed := time.Since(started); elapsed > timeout {
+			return fmt.Errorf("цикл обработки выполняется %v, дольше %v", elapsed.Round(time.Second), timeout)
+		}
+	}
+	return nil
+}
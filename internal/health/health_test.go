@@ -0,0 +1,46 @@
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	var tests = []struct {
+		name    string
+		timeout time.Duration
+		// Время начала незавершенных циклов относительно текущего момента
+		started []time.Duration
+		// Завершить циклы перед проверкой
+		finish  bool
+		healthy bool
+	}{
+		{name: "no loops", healthy: true},
+		{name: "short loop", timeout: time.Minute, started: []time.Duration{-time.Second}, healthy: true},
+		{name: "stuck loop", timeout: time.Minute, started: []time.Duration{-time.Second, -2 * time.Minute}},
+		{name: "default timeout", started: []time.Duration{-DefaultReconcileTimeout - time.Minute}},
+		{name: "stuck loop finished", timeout: time.Minute, started: []time.Duration{-2 * time.Minute}, finish: true, healthy: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var watchdog = &Watchdog{Timeout: test.timeout}
+			var done []func()
+			for _, offset := range test.started {
+				done = append(done, watchdog.Begin())
+				watchdog.inflight[watchdog.next] = time.Now().Add(offset)
+			}
+			if test.finish {
+				for _, finish := range done {
+					finish()
+				}
+			}
+			if err := watchdog.Check(nil); (err == nil) != test.healthy {
+				t.Errorf("Check() = %v, ожидалась исправность %v", err, test.healthy)
+			}
+		})
+	}
+
+	// nil-watchdog используется, когда проверка не настроена
+	var disabled *Watchdog
+	disabled.Begin()()
+}
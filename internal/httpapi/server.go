@@ -0,0 +1,548 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/api/rbac/v1beta1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	environmentsPath = "/api/v1/environments"
+
+	// Максимальное время ожидания перехода окружения в ACTIVE через параметр wait
+	maxWait = 10 * time.Minute
+
+	// Максимальный размер тела запроса
+	maxRequestBody = 64 << 10
+)
+
+var (
+	// requestedByAnnotation хранит имя пользователя, запросившего окружение через API
+	requestedByAnnotation = platformv1.GroupVersion.Group + "/requested-by"
+	// shardLabelKey распределяет DynamicNamespace по экземплярам оператора (--shard)
+	shardLabelKey = platformv1.GroupVersion.Group + "/shard"
+)
+
+// Server serves a REST API for creating DynamicNamespaces on behalf of callers
+// authenticated with Kubernetes bearer tokens
+type Server struct {
+	client.Client
+	// APIReader читает DynamicNamespace напрямую из API-сервера: кэш менеджера при --shard
+	// содержит только окружения своего шарда
+	APIReader client.Reader
+
+	// Адрес, на котором слушает API. Пустой адрес отключает API
+	BindAddress string
+	// Namespace, в котором создаются DynamicNamespace по запросам API
+	Namespace string
+	// Адрес API-сервера Kubernetes в выдаваемых kubeconfig
+	KubeconfigServer string
+	// CA API-сервера Kubernetes в выдаваемых kubeconfig
+	KubeconfigCAData []byte
+	// Сертификат и ключ TLS. API принимает bearer-токены и возвращает их в kubeconfig,
+	// поэтому без TLS он запускается только при явно заданном Insecure
+	TLSCertFile string
+	TLSKeyFile  string
+	// Обслуживать API по HTTP, например за Ingress, который терминирует TLS
+	Insecure bool
+	// Селектор шарда экземпляра (--shard). Создаваемое окружение должно в него попадать,
+	// иначе его не обработает ни этот, ни другой экземпляр с тем же селектором
+	Shard labels.Selector
+	// Язык сообщений об ошибках; nil - язык по умолчанию
+	Language func() messages.Language
+
+	log logr.Logger
+}
+
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// SetupWithManager registers the API server as a manager runnable.
+func (s *Server) SetupWithManager(mgr ctrl.Manager) error {
+	s.log = ctrl.Log.WithName("http-api")
+	if s.BindAddress == "" {
+		return nil
+	}
+	if s.Namespace == "" {
+		return errors.New("не задан namespace для DynamicNamespace, создаваемых через API")
+	}
+	if (s.TLSCertFile == "") != (s.TLSKeyFile == "") {
+		return errors.New("для TLS HTTP API нужны и сертификат, и ключ")
+	}
+	if s.TLSCertFile == "" && !s.Insecure {
+		return errors.New("HTTP API без TLS передавал бы токены открытым текстом: задайте сертификат и ключ или явно разрешите HTTP")
+	}
+
+	if s.APIReader == nil {
+		s.APIReader = mgr.GetAPIReader()
+	}
+	var config = mgr.GetConfig()
+	if s.KubeconfigServer == "" {
+		s.KubeconfigServer = config.Host
+	}
+	if s.KubeconfigCAData == nil {
+		s.KubeconfigCAData = config.CAData
+		if s.KubeconfigCAData == nil && config.CAFile != "" {
+			data, err := ioutil.ReadFile(config.CAFile)
+			if err != nil {
+				return err
+			}
+			s.KubeconfigCAData = data
+		}
+	}
+	return mgr.Add(s)
+}
+
+// NeedLeaderElection реализует manager.LeaderElectionRunnable: API обслуживается всеми репликами
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// handler возвращает обработчик всех путей API
+func (s *Server) handler() http.Handler {
+	var mux = http.NewServeMux()
+	mux.HandleFunc(environmentsPath, s.handleEnvironments)
+	mux.HandleFunc(environmentsPath+"/", s.handleEnvironment)
+	return mux
+}
+
+// Start обслуживает API до остановки менеджера
+func (s *Server) Start(ctx context.Context) error {
+	var server = &http.Server{Addr: s.BindAddress, Handler: s.handler()}
+
+	var errs = make(chan error, 1)
+	go func() {
+		s.log.Info("Запуск HTTP API", "address", s.BindAddress)
+		var err error
+		if s.TLSCertFile != "" {
+			err = server.ListenAndServeTLS(s.TLSCertFile, s.TLSKeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			errs <- err
+		}
+		close(errs)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		var shutdownCtx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+// environmentRequest - тело запроса на создание окружения
+type environmentRequest struct {
+	Name string `json:"name"`
+	// Значение лейбла platform.cloudnative.space/shard окружения
+	Shard string          `json:"shard,omitempty"`
+	Spec  environmentSpec `json:"spec"`
+}
+
+// environmentSpec - поля спецификации, которые можно задать через API. Роль, пул, лейблы, дочерние
+// namespace, дополнительные квоты и автомасштабирование берутся из значений по умолчанию контроллера:
+// через API их мог бы задать любой, кому разрешено создавать DynamicNamespace в --api-namespace
+type environmentSpec struct {
+	CreateQuota    v1.ResourceList              `json:"createQuota,omitempty"`
+	Expiration     *platformv1.ExpirationPolicy `json:"expiration,omitempty"`
+	SleepSchedule  *platformv1.SleepSchedule    `json:"sleepSchedule,omitempty"`
+	IsolateNetwork bool                         `json:"isolateNetwork,omitempty"`
+}
+
+// environment - представление окружения в ответах API
+type environment struct {
+	Name       string       `json:"name"`
+	Status     string       `json:"status"`
+	Reason     string       `json:"reason,omitempty"`
+	Message    string       `json:"message,omitempty"`
+	Namespace  string       `json:"namespace,omitempty"`
+	ExpiresAt  *metav1.Time `json:"expiresAt,omitempty"`
+	Kubeconfig string       `json:"kubeconfig,omitempty"`
+}
+
+// caller - аутентифицированный пользователь API
+type caller struct {
+	user  authenticationv1.UserInfo
+	token string
+}
+
+// handleEnvironments обслуживает GET (список окружений пользователя) и POST (создание)
+func (s *Server) handleEnvironments(w http.ResponseWriter, r *http.Request) {
+	who, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if s.authorize(w, r, who, "list") {
+			s.list(w, r, who)
+		}
+	case http.MethodPost:
+		if s.authorize(w, r, who, "create") {
+			s.create(w, r, who)
+		}
+	default:
+		s.writeError(w, http.StatusMethodNotAllowed, messages.New(messages.MethodNotAllowed))
+	}
+}
+
+// handleEnvironment обслуживает GET и DELETE одного окружения
+func (s *Server) handleEnvironment(w http.ResponseWriter, r *http.Request) {
+	who, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	var name = strings.TrimPrefix(r.URL.Path, environmentsPath+"/")
+	if name == "" || strings.Contains(name, "/") {
+		s.writeError(w, http.StatusNotFound, messages.New(messages.EnvironmentUnknown))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if s.authorize(w, r, who, "get") {
+			s.get(w, r, who, name)
+		}
+	case http.MethodDelete:
+		if s.authorize(w, r, who, "delete") {
+			s.delete(w, r, who, name)
+		}
+	default:
+		s.writeError(w, http.StatusMethodNotAllowed, messages.New(messages.MethodNotAllowed))
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, who *caller) {
+	var resources platformv1.DynamicNamespaceList
+	err := s.APIReader.List(r.Context(), &resources, client.InNamespace(s.Namespace))
+	if err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+	var environments = []environment{}
+	for i := range resources.Items {
+		if resources.Items[i].Annotations[requestedByAnnotation] == who.user.Username {
+			environments = append(environments, s.environment(&resources.Items[i], who))
+		}
+	}
+	writeJSON(w, http.StatusOK, environments)
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request, who *caller) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err != nil {
+		s.writeError(w, http.StatusRequestEntityTooLarge, messages.New(messages.RequestBodyTooLarge, maxRequestBody))
+		return
+	}
+	// Поля спецификации вне environmentSpec отклоняются, а не пропускаются молча
+	var request environmentRequest
+	var decoder = json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&request)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, messages.New(messages.RequestBodyInvalid, err))
+		return
+	}
+	if request.Name == "" {
+		s.writeError(w, http.StatusBadRequest, messages.New(messages.EnvironmentNameMissing))
+		return
+	}
+	var resourceLabels = map[string]string{}
+	if request.Shard != "" {
+		resourceLabels[shardLabelKey] = request.Shard
+	}
+	if s.Shard != nil && !s.Shard.Matches(labels.Set(resourceLabels)) {
+		s.writeError(w, http.StatusBadRequest, messages.New(messages.ShardNotServed, request.Shard, s.Shard.String()))
+		return
+	}
+
+	var resource = &platformv1.DynamicNamespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        request.Name,
+			Namespace:   s.Namespace,
+			Labels:      resourceLabels,
+			Annotations: map[string]string{requestedByAnnotation: who.user.Username},
+		},
+		Spec: platformv1.DynamicNamespaceSpec{
+			CreateQuota:    request.Spec.CreateQuota,
+			Expiration:     request.Spec.Expiration,
+			SleepSchedule:  request.Spec.SleepSchedule,
+			IsolateNetwork: request.Spec.IsolateNetwork,
+		},
+	}
+	// Доступ к окружению получает только запросивший его пользователь
+	resource.Spec.RoleBindingSubjects = []v1beta1.Subject{{
+		Kind:     v1beta1.UserKind,
+		APIGroup: v1beta1.GroupName,
+		Name:     who.user.Username,
+	}}
+	err = s.Create(r.Context(), resource)
+	if err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+	s.log.Info("Окружение создано", "dynamicnamespace", resource.Namespace+"/"+resource.Name, "user", who.user.Username)
+
+	// Кэш менеджера может еще не содержать созданный объект, поэтому без wait возвращается ответ создания
+	if r.URL.Query().Get("wait") != "" {
+		resource, err = s.wait(r, resource.Name)
+		if err != nil {
+			s.writeAPIError(w, err)
+			return
+		}
+	}
+	var code = http.StatusAccepted
+	if resource.Status.Code == "ACTIVE" {
+		code = http.StatusCreated
+	}
+	writeJSON(w, code, s.environment(resource, who))
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, who *caller, name string) {
+	resource, err := s.wait(r, name)
+	if err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+	if resource.Annotations[requestedByAnnotation] != who.user.Username {
+		s.writeError(w, http.StatusNotFound, messages.New(messages.EnvironmentUnknown))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.environment(resource, who))
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request, who *caller, name string) {
+	var resource platformv1.DynamicNamespace
+	err := s.APIReader.Get(r.Context(), types.NamespacedName{Namespace: s.Namespace, Name: name}, &resource)
+	if err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+	if resource.Annotations[requestedByAnnotation] != who.user.Username {
+		s.writeError(w, http.StatusNotFound, messages.New(messages.EnvironmentUnknown))
+		return
+	}
+	err = s.Delete(r.Context(), &resource, client.Preconditions{UID: &resource.UID})
+	if err != nil {
+		s.writeAPIError(w, err)
+		return
+	}
+	s.log.Info("Окружение удалено", "dynamicnamespace", resource.Namespace+"/"+resource.Name, "user", who.user.Username)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// wait читает окружение и, если задан параметр wait, ждет его перехода в ACTIVE или ERROR
+func (s *Server) wait(r *http.Request, name string) (*platformv1.DynamicNamespace, error) {
+	var timeout time.Duration
+	if value := r.URL.Query().Get("wait"); value != "" {
+		var err error
+		timeout, err = time.ParseDuration(value)
+		if err != nil {
+			return nil, kerrors.NewBadRequest(s.language().Text(messages.New(messages.WaitInvalid, err)))
+		}
+		if timeout > maxWait {
+			timeout = maxWait
+		}
+	}
+
+	var key = types.NamespacedName{Namespace: s.Namespace, Name: name}
+	var resource platformv1.DynamicNamespace
+	if timeout == 0 {
+		err := s.APIReader.Get(r.Context(), key, &resource)
+		if err != nil {
+			return nil, err
+		}
+		return &resource, nil
+	}
+
+	var ctx, cancel = context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	// last - результат последнего опроса: nil, если окружение прочитано, или NotFound
+	var last error
+	var polled bool
+	for {
+		err := s.APIReader.Get(ctx, key, &resource)
+		if err != nil && polled && ctx.Err() == context.DeadlineExceeded {
+			// Время ожидания истекло во время чтения: отвечаем последним прочитанным состоянием
+			err = last
+			if err == nil {
+				return &resource, nil
+			}
+			return nil, err
+		}
+		if err != nil && !kerrors.IsNotFound(err) {
+			return nil, err
+		}
+		last, polled = err, true
+		if err == nil && (resource.Status.Code == "ACTIVE" || resource.Status.Code == "ERROR") {
+			return &resource, nil
+		}
+		select {
+		case <-ctx.Done():
+			if last != nil {
+				return nil, last
+			}
+			return &resource, nil
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// authenticate проверяет bearer-токен через TokenReview
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*caller, bool) {
+	var header = r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		s.writeError(w, http.StatusUnauthorized, messages.New(messages.BearerTokenMissing))
+		return nil, false
+	}
+	var token = strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+
+	var review = &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}
+	err := s.Create(r.Context(), review)
+	if err != nil {
+		s.log.Error(err, "Ошибка при проверке токена")
+		s.writeError(w, http.StatusInternalServerError, messages.New(messages.TokenReviewFailed))
+		return nil, false
+	}
+	if !review.Status.Authenticated {
+		s.writeError(w, http.StatusUnauthorized, messages.New(messages.TokenInvalid))
+		return nil, false
+	}
+	return &caller{user: review.Status.User, token: token}, true
+}
+
+// authorize проверяет через SubjectAccessReview, что пользователь сам может выполнить verb над
+// DynamicNamespace в namespace API: контроллер создает их со своими правами
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, who *caller, verb string) bool {
+	var extra = map[string]authorizationv1.ExtraValue{}
+	for key, values := range who.user.Extra {
+		extra[key] = authorizationv1.ExtraValue(values)
+	}
+	var review = &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   who.user.Username,
+			UID:    who.user.UID,
+			Groups: who.user.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: s.Namespace,
+				Verb:      verb,
+				Group:     platformv1.GroupVersion.Group,
+				Resource:  "dynamicnamespaces",
+			},
+		},
+	}
+	err := s.Create(r.Context(), review)
+	if err != nil {
+		s.log.Error(err, "Ошибка при проверке прав пользователя", "user", who.user.Username)
+		s.writeError(w, http.StatusInternalServerError, messages.New(messages.AccessReviewFailed))
+		return false
+	}
+	if !review.Status.Allowed {
+		s.writeError(w, http.StatusForbidden, messages.New(messages.AccessDenied, who.user.Username, verb, s.Namespace))
+		return false
+	}
+	return true
+}
+
+// environment формирует ответ API; kubeconfig выдается только активному окружению
+func (s *Server) environment(resource *platformv1.DynamicNamespace, who *caller) environment {
+	var result = environment{
+		Name:      resource.Name,
+		Status:    resource.Status.Code,
+		Reason:    resource.Status.Reason,
+		Message:   resource.Status.Message,
+		Namespace: resource.Status.Namespace,
+		ExpiresAt: resource.Status.ExpiresAt,
+	}
+	if resource.Status.Code == "ACTIVE" && resource.Status.Namespace != "" {
+		kubeconfig, err := s.kubeconfig(resource.Status.Namespace, who)
+		if err != nil {
+			s.log.Error(err, "Ошибка при формировании kubeconfig", "dynamicnamespace", resource.Namespace+"/"+resource.Name)
+		}
+		result.Kubeconfig = kubeconfig
+	}
+	return result
+}
+
+// kubeconfig собирает kubeconfig с токеном пользователя и namespace окружения
+func (s *Server) kubeconfig(namespace string, who *caller) (string, error) {
+	var config = clientcmdapi.NewConfig()
+	config.Clusters["cluster"] = &clientcmdapi.Cluster{
+		Server:                   s.KubeconfigServer,
+		CertificateAuthorityData: s.KubeconfigCAData,
+	}
+	config.AuthInfos["user"] = &clientcmdapi.AuthInfo{Token: who.token}
+	config.Contexts[namespace] = &clientcmdapi.Context{
+		Cluster:   "cluster",
+		AuthInfo:  "user",
+		Namespace: namespace,
+	}
+	config.CurrentContext = namespace
+	data, err := clientcmd.Write(*config)
+	return string(data), err
+}
+
+// writeAPIError переводит ошибку API Kubernetes в HTTP-ответ
+func (s *Server) writeAPIError(w http.ResponseWriter, err error) {
+	var status kerrors.APIStatus
+	if errors.As(err, &status) {
+		var code = int(status.Status().Code)
+		if kerrors.IsNotFound(err) {
+			s.writeError(w, http.StatusNotFound, messages.New(messages.EnvironmentUnknown))
+			return
+		}
+		if code >= 400 && code < 500 {
+			// Ошибки API-сервера передаются как есть, с его кодом причины
+			writeJSON(w, code, map[string]string{"error": status.Status().Message, "reason": string(status.Status().Reason)})
+			return
+		}
+	}
+	s.log.Error(err, "Ошибка при обработке запроса API")
+	s.writeError(w, http.StatusInternalServerError, messages.FromError(err))
+}
+
+// language возвращает текущий язык сообщений
+func (s *Server) language() messages.Language {
+	if s.Language == nil {
+		return messages.DefaultLanguage
+	}
+	return s.Language()
+}
+
+// writeError отвечает переведенным текстом ошибки и ее кодом
+func (s *Server) writeError(w http.ResponseWriter, code int, message messages.Message) {
+	writeJSON(w, code, map[string]string{"error": s.language().Text(message), "reason": string(message.Reason)})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
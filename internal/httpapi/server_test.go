@@ -0,0 +1,356 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// reviewClient отвечает на TokenReview и SubjectAccessReview вместо API-сервера
+type reviewClient struct {
+	client.Client
+
+	// Токен, который считается действительным, и пользователь, которому он принадлежит
+	token string
+	user  string
+	// Глаголы, разрешенные пользователю
+	allowed map[string]bool
+	// Последний SubjectAccessReview
+	access *authorizationv1.SubjectAccessReview
+}
+
+func (c *reviewClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	switch review := obj.(type) {
+	case *authenticationv1.TokenReview:
+		if review.Spec.Token == c.token {
+			review.Status.Authenticated = true
+			review.Status.User = authenticationv1.UserInfo{Username: c.user, Groups: []string{"developers"}}
+		}
+		return nil
+	case *authorizationv1.SubjectAccessReview:
+		c.access = review
+		review.Status.Allowed = c.allowed[review.Spec.ResourceAttributes.Verb]
+		return nil
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func testServer(t *testing.T, allowed ...string) (*Server, *reviewClient) {
+	var scheme = runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := platformv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	var c = &reviewClient{
+		Client:  fake.NewClientBuilder().WithScheme(scheme).Build(),
+		token:   "valid",
+		user:    "alice",
+		allowed: map[string]bool{},
+	}
+	for _, verb := range allowed {
+		c.allowed[verb] = true
+	}
+	return &Server{Client: c, APIReader: c, Namespace: "environments", log: logr.Discard()}, c
+}
+
+func TestCreateEnvironment(t *testing.T) {
+	var tests = []struct {
+		name    string
+		token   string
+		allowed []string
+		body    string
+		code    int
+	}{
+		{name: "no token", body: `{"name":"feature"}`, code: http.StatusUnauthorized},
+		{name: "invalid token", token: "stolen", allowed: []string{"create"}, body: `{"name":"feature"}`, code: http.StatusUnauthorized},
+		{name: "not allowed to create", token: "valid", allowed: []string{"list"}, body: `{"name":"feature"}`, code: http.StatusForbidden},
+		{name: "allowed", token: "valid", allowed: []string{"create"},
+			body: `{"name":"feature","spec":{"createQuota":{"cpu":"2"},"isolateNetwork":true}}`, code: http.StatusAccepted},
+		{name: "role is not settable", token: "valid", allowed: []string{"create"},
+			body: `{"name":"feature","spec":{"role":"cluster-admin"}}`, code: http.StatusBadRequest},
+		{name: "children are not settable", token: "valid", allowed: []string{"create"},
+			body: `{"name":"feature","spec":{"children":[{"name":"db"}]}}`, code: http.StatusBadRequest},
+		{name: "body too large", token: "valid", allowed: []string{"create"},
+			body: `{"name":"` + strings.Repeat("a", maxRequestBody) + `"}`, code: http.StatusRequestEntityTooLarge},
+		{name: "missing name", token: "valid", allowed: []string{"create"}, body: `{}`, code: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, c := testServer(t, test.allowed...)
+			var request = httptest.NewRequest(http.MethodPost, environmentsPath, strings.NewReader(test.body))
+			if test.token != "" {
+				request.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			var recorder = httptest.NewRecorder()
+			s.handler().ServeHTTP(recorder, request)
+			if recorder.Code != test.code {
+				t.Fatalf("код ответа %v, ожидался %v: %v", recorder.Code, test.code, recorder.Body.String())
+			}
+			if test.code != http.StatusAccepted {
+				return
+			}
+
+			var attributes = c.access.Spec.ResourceAttributes
+			if c.access.Spec.User != "alice" || attributes.Namespace != "environments" ||
+				attributes.Group != platformv1.GroupVersion.Group || attributes.Resource != "dynamicnamespaces" {
+				t.Errorf("SubjectAccessReview = %+v", c.access.Spec)
+			}
+			var resource platformv1.DynamicNamespace
+			err := c.Get(context.Background(), types.NamespacedName{Namespace: "environments", Name: "feature"}, &resource)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !resource.Spec.IsolateNetwork || resource.Spec.CreateQuota.Cpu().String() != "2" {
+				t.Errorf("spec = %+v", resource.Spec)
+			}
+			if len(resource.Spec.RoleBindingSubjects) != 1 || resource.Spec.RoleBindingSubjects[0].Name != "alice" {
+				t.Errorf("subjects = %+v", resource.Spec.RoleBindingSubjects)
+			}
+			if resource.Annotations[requestedByAnnotation] != "alice" {
+				t.Errorf("annotations = %v", resource.Annotations)
+			}
+		})
+	}
+}
+
+func TestAuthorizeVerbs(t *testing.T) {
+	var tests = []struct {
+		method string
+		path   string
+		verb   string
+	}{
+		{method: http.MethodGet, path: environmentsPath, verb: "list"},
+		{method: http.MethodGet, path: environmentsPath + "/feature", verb: "get"},
+		{method: http.MethodDelete, path: environmentsPath + "/feature", verb: "delete"},
+	}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			s, c := testServer(t)
+			var request = httptest.NewRequest(test.method, test.path, nil)
+			request.Header.Set("Authorization", "Bearer valid")
+			var recorder = httptest.NewRecorder()
+			s.handler().ServeHTTP(recorder, request)
+			if recorder.Code != http.StatusForbidden {
+				t.Fatalf("код ответа %v, ожидался %v", recorder.Code, http.StatusForbidden)
+			}
+			if verb := c.access.Spec.ResourceAttributes.Verb; verb != test.verb {
+				t.Errorf("verb = %v, ожидалось %v", verb, test.verb)
+			}
+		})
+	}
+}
+
+func TestSetupRequiresTLS(t *testing.T) {
+	var tests = []struct {
+		name   string
+		server Server
+	}{
+		{name: "no TLS", server: Server{BindAddress: ":8443", Namespace: "environments"}},
+		{name: "cert without key", server: Server{BindAddress: ":8443", Namespace: "environments", TLSCertFile: "tls.crt"}},
+		{name: "no namespace", server: Server{BindAddress: ":8443", Insecure: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Проверки выполняются до обращения к менеджеру
+			if err := test.server.SetupWithManager(nil); err == nil {
+				t.Error("SetupWithManager без ошибки, ожидалась ошибка")
+			}
+		})
+	}
+}
+
+func TestErrorBody(t *testing.T) {
+	var tests = []struct {
+		name     string
+		language messages.Language
+		token    string
+		reason   messages.Reason
+		want     string
+	}{
+		{name: "english", language: messages.English, reason: messages.BearerTokenMissing,
+			want: "the Authorization: Bearer <token> header is required"},
+		{name: "russian", language: messages.Russian, reason: messages.BearerTokenMissing,
+			want: "требуется заголовок Authorization: Bearer <token>"},
+		{name: "access denied", language: messages.English, token: "valid", reason: messages.AccessDenied,
+			want: "user alice may not list dynamicnamespaces in namespace environments"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, _ := testServer(t)
+			var language = test.language
+			s.Language = func() messages.Language { return language }
+			var request = httptest.NewRequest(http.MethodGet, environmentsPath, nil)
+			if test.token != "" {
+				request.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			var recorder = httptest.NewRecorder()
+			s.handler().ServeHTTP(recorder, request)
+			var body map[string]string
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body["reason"] != string(test.reason) || body["error"] != test.want {
+				t.Errorf("ответ %v, ожидалось %v: %q", body, test.reason, test.want)
+			}
+		})
+	}
+}
+
+// shardCache отдает только объекты шарда, как кэш менеджера с --shard
+type shardCache struct {
+	client.Client
+	selector labels.Selector
+}
+
+func (c *shardCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	err := c.Client.Get(ctx, key, obj)
+	if err == nil && !c.selector.Matches(labels.Set(obj.GetLabels())) {
+		return kerrors.NewNotFound(platformv1.GroupVersion.WithResource("dynamicnamespaces").GroupResource(), key.Name)
+	}
+	return err
+}
+
+func (c *shardCache) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.Client.List(ctx, list, append(opts, client.MatchingLabelsSelector{Selector: c.selector})...)
+}
+
+func TestShardedServer(t *testing.T) {
+	var tests = []struct {
+		name  string
+		body  string
+		code  int
+		shard string
+	}{
+		{name: "shard of the instance", body: `{"name":"feature","shard":"blue"}`, code: http.StatusAccepted, shard: "blue"},
+		{name: "no shard", body: `{"name":"feature"}`, code: http.StatusBadRequest},
+		{name: "other shard", body: `{"name":"feature","shard":"green"}`, code: http.StatusBadRequest},
+	}
+	selector, err := labels.Parse(shardLabelKey + "=blue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, c := testServer(t, "create", "get", "list")
+			// Кэш видит только шард экземпляра, API-сервер - все окружения
+			var apiServer = c.Client
+			c.Client = &shardCache{Client: apiServer, selector: selector}
+			s.APIReader = apiServer
+			s.Shard = selector
+
+			var request = httptest.NewRequest(http.MethodPost, environmentsPath, strings.NewReader(test.body))
+			request.Header.Set("Authorization", "Bearer valid")
+			var recorder = httptest.NewRecorder()
+			s.handler().ServeHTTP(recorder, request)
+			if recorder.Code != test.code {
+				t.Fatalf("код ответа %v, ожидался %v: %v", recorder.Code, test.code, recorder.Body.String())
+			}
+			if test.code != http.StatusAccepted {
+				return
+			}
+
+			// Окружение читается сразу после создания, не дожидаясь кэша
+			for _, path := range []string{environmentsPath + "/feature", environmentsPath} {
+				request = httptest.NewRequest(http.MethodGet, path, nil)
+				request.Header.Set("Authorization", "Bearer valid")
+				recorder = httptest.NewRecorder()
+				s.handler().ServeHTTP(recorder, request)
+				if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"name":"feature"`) {
+					t.Errorf("GET %v: код %v, ответ %v", path, recorder.Code, recorder.Body.String())
+				}
+			}
+			var resource platformv1.DynamicNamespace
+			if err := apiServer.Get(context.Background(), types.NamespacedName{Namespace: "environments", Name: "feature"}, &resource); err != nil {
+				t.Fatal(err)
+			}
+			if resource.Labels[shardLabelKey] != test.shard {
+				t.Errorf("лейблы %v, ожидался шард %v", resource.Labels, test.shard)
+			}
+		})
+	}
+}
+
+// hangingReader отвечает на первое чтение, а следующие зависают до отмены контекста
+type hangingReader struct {
+	client.Reader
+	calls int
+}
+
+func (r *hangingReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	r.calls++
+	if r.calls > 1 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return r.Reader.Get(ctx, key, obj)
+}
+
+func TestWait(t *testing.T) {
+	var tests = []struct {
+		name     string
+		query    string
+		resource string
+		hang     bool
+		code     string
+		notFound bool
+	}{
+		{name: "без ожидания", resource: "feature", code: "PENDING"},
+		{name: "окружения нет", resource: "other", notFound: true},
+		{name: "зависший API-сервер", query: "?wait=100ms", resource: "feature", hang: true, code: "PENDING"},
+		{name: "окружение не появилось", query: "?wait=100ms", resource: "other", notFound: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, c := testServer(t)
+			var environment = &platformv1.DynamicNamespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "feature", Namespace: "environments"},
+				Status:     platformv1.DynamicNamespaceStatus{Code: "PENDING"},
+			}
+			if err := c.Create(context.Background(), environment); err != nil {
+				t.Fatal(err)
+			}
+			if test.hang {
+				s.APIReader = &hangingReader{Reader: c}
+			}
+
+			var request = httptest.NewRequest(http.MethodGet, environmentsPath+"/"+test.resource+test.query, nil)
+			var started = time.Now()
+			resource, err := s.wait(request, test.resource)
+			if elapsed := time.Since(started); elapsed > 5*time.Second {
+				t.Errorf("ожидание заняло %v", elapsed)
+			}
+			if test.notFound {
+				if !kerrors.IsNotFound(err) {
+					t.Errorf("ошибка %v, ожидалось NotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resource.Status.Code != test.code {
+				t.Errorf("статус %v, ожидался %v", resource.Status.Code, test.code)
+			}
+		})
+	}
+}
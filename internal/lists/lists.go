@@ -0,0 +1,38 @@
+package lists
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Split разбирает список значений через запятую, пропуская пустые
+func Split(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// ResourceList разбирает список вида cpu=4,memory=16Gi
+func ResourceList(value string) (v1.ResourceList, error) {
+	var list = v1.ResourceList{}
+	for _, item := range Split(value) {
+		var parts = strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("некорректный элемент %q, ожидается ресурс=значение", item)
+		}
+		quantity, err := resource.ParseQuantity(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("некорректное значение %q: %v", item, err)
+		}
+		list[v1.ResourceName(parts[0])] = quantity
+	}
+	return list, nil
+}
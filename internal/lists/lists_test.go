@@ -0,0 +1,61 @@
+package lists
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestSplit(t *testing.T) {
+	var tests = []struct {
+		value string
+		want  []string
+	}{
+		{value: "", want: nil},
+		{value: "a", want: []string{"a"}},
+		{value: " a , b ,,c, ", want: []string{"a", "b", "c"}},
+	}
+	for _, test := range tests {
+		if got := Split(test.value); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Split(%q) = %q, ожидалось %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestResourceList(t *testing.T) {
+	var tests = []struct {
+		value   string
+		want    v1.ResourceList
+		invalid bool
+	}{
+		{value: "", want: v1.ResourceList{}},
+		{value: "cpu=2, memory=4Gi", want: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2"), v1.ResourceMemory: resource.MustParse("4Gi")}},
+		{value: "count/pods=10", want: v1.ResourceList{"count/pods": resource.MustParse("10")}},
+		{value: "cpu", invalid: true},
+		{value: "cpu=two", invalid: true},
+	}
+	for _, test := range tests {
+		got, err := ResourceList(test.value)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("ResourceList(%q): ожидалась ошибка", test.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ResourceList(%q): %v", test.value, err)
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("ResourceList(%q) = %v, ожидалось %v", test.value, got, test.want)
+			continue
+		}
+		for name, want := range test.want {
+			if quantity := got[name]; quantity.Cmp(want) != 0 {
+				t.Errorf("ResourceList(%q)[%v] = %v, ожидалось %v", test.value, name, quantity.String(), want.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package messages
+
+// Коды сообщений статусов и событий. Коды, совпадающие с причинами событий и условий,
+// используются и как их reason
+const (
+	// Статус DynamicNamespace и DynamicNamespacePool
+	Ready          Reason = "Ready"
+	Suspended      Reason = "Suspended"
+	Planned        Reason = "Planned"
+	ReconcileError Reason = "ReconcileError"
+
+	// Отклонение по namespace источника
+	Rejected                  Reason = "Rejected"
+	PoolRejected              Reason = "PoolRejected"
+	NamespaceNotAllowed       Reason = "NamespaceNotAllowed"
+	NamespaceSelectorMismatch Reason = "NamespaceSelectorMismatch"
+
+	// Подтверждение
+	AwaitingApproval    Reason = "AwaitingApproval"
+	RestrictedRole      Reason = "RestrictedRole"
+	QuotaAboveThreshold Reason = "QuotaAboveThreshold"
+
+	// Ошибки обработки
+	NamespaceConflict           Reason = "NamespaceConflict"
+	NamespaceTemplateFailed     Reason = "NamespaceTemplateFailed"
+	NamespaceNameInvalid        Reason = "NamespaceNameInvalid"
+	PoolNotFound                Reason = "PoolNotFound"
+	SeedNotFound                Reason = "SeedNotFound"
+	SeedKindUnsupported         Reason = "SeedKindUnsupported"
+	QuotaNameReserved           Reason = "QuotaNameReserved"
+	QuotaDuplicated             Reason = "QuotaDuplicated"
+	QuotaLimitsMissing          Reason = "QuotaLimitsMissing"
+	ChildDuplicated             Reason = "ChildDuplicated"
+	ChildNamespaceNameInvalid   Reason = "ChildNamespaceNameInvalid"
+	ChildResourceNotInParent    Reason = "ChildResourceNotInParent"
+	ChildQuotaExceedsParent     Reason = "ChildQuotaExceedsParent"
+	AutoscalingThresholdInvalid Reason = "AutoscalingThresholdInvalid"
+	AutoscalingMaxBelowQuota    Reason = "AutoscalingMaxBelowQuota"
+	AutoscalingMaxMissing       Reason = "AutoscalingMaxMissing"
+	RoleNotAllowed              Reason = "RoleNotAllowed"
+	PoolSizeExceeded            Reason = "PoolSizeExceeded"
+	PoolApprovalRequired        Reason = "PoolApprovalRequired"
+	TimeZoneInvalid             Reason = "TimeZoneInvalid"
+	SleepScheduleEmpty          Reason = "SleepScheduleEmpty"
+	ClockInvalid                Reason = "ClockInvalid"
+	AnnotationInvalid           Reason = "AnnotationInvalid"
+
+	// События и условия
+	Expired           Reason = "Expired"
+	ExpiringSoon      Reason = "ExpiringSoon"
+	ExpiresAt         Reason = "ExpiresAt"
+	BelowThreshold    Reason = "BelowThreshold"
+	ThresholdExceeded Reason = "ThresholdExceeded"
+	ChangesPending    Reason = "ChangesPending"
+	UpToDate          Reason = "UpToDate"
+	ClaimedFromPool   Reason = "ClaimedFromPool"
+	PoolEmpty         Reason = "PoolEmpty"
+	QuotaScaled       Reason = "QuotaScaled"
+
+	// Строки плана dry-run
+	PlanPoolClaim   Reason = "PlanPoolClaim"
+	PlanRecreate    Reason = "PlanRecreate"
+	PlanNoValue     Reason = "PlanNoValue"
+	PlanSleep       Reason = "PlanSleep"
+	PlanWake        Reason = "PlanWake"
+	PlanQuotaScaled Reason = "PlanQuotaScaled"
+	PlanExpires     Reason = "PlanExpires"
+	PlanPoolFill    Reason = "PlanPoolFill"
+
+	// Статус DynamicNamespaceTrigger
+	EventIgnored         Reason = "EventIgnored"
+	RepositoryNotServed  Reason = "RepositoryNotServed"
+	BranchNotMatched     Reason = "BranchNotMatched"
+	BranchPatternInvalid Reason = "BranchPatternInvalid"
+	EnvironmentCreated   Reason = "EnvironmentCreated"
+	EnvironmentExists    Reason = "EnvironmentExists"
+	EnvironmentNotFound  Reason = "EnvironmentNotFound"
+	EnvironmentDeleted   Reason = "EnvironmentDeleted"
+	EnvironmentActivity  Reason = "EnvironmentActivity"
+
+	// Заголовки уведомлений
+	NotifyActive       Reason = "NotifyActive"
+	NotifyError        Reason = "NotifyError"
+	NotifyExpiringSoon Reason = "NotifyExpiringSoon"
+	NotifyDeleted      Reason = "NotifyDeleted"
+	NotifyEvent        Reason = "NotifyEvent"
+
+	// Ответы HTTP API и приемника событий Git
+	MethodNotAllowed       Reason = "MethodNotAllowed"
+	RequestBodyTooLarge    Reason = "RequestBodyTooLarge"
+	RequestBodyInvalid     Reason = "RequestBodyInvalid"
+	RequestBodyUnreadable  Reason = "RequestBodyUnreadable"
+	WaitInvalid            Reason = "WaitInvalid"
+	EnvironmentNameMissing Reason = "EnvironmentNameMissing"
+	ShardNotServed         Reason = "ShardNotServed"
+	EnvironmentUnknown     Reason = "EnvironmentUnknown"
+	BearerTokenMissing     Reason = "BearerTokenMissing"
+	TokenReviewFailed      Reason = "TokenReviewFailed"
+	TokenInvalid           Reason = "TokenInvalid"
+	AccessReviewFailed     Reason = "AccessReviewFailed"
+	AccessDenied           Reason = "AccessDenied"
+	HookPathInvalid        Reason = "HookPathInvalid"
+	TriggerNotFound        Reason = "TriggerNotFound"
+	TriggerReadFailed      Reason = "TriggerReadFailed"
+	TriggerLookupLimited   Reason = "TriggerLookupLimited"
+	TriggerSecretFailed    Reason = "TriggerSecretFailed"
+	ProviderUnknown        Reason = "ProviderUnknown"
+	GitLabTokenInvalid     Reason = "GitLabTokenInvalid"
+	SignatureMissing       Reason = "SignatureMissing"
+	SignatureMalformed     Reason = "SignatureMalformed"
+	SignatureMismatch      Reason = "SignatureMismatch"
+	EventInvalid           Reason = "EventInvalid"
+
+	// Отказы webhook подтверждения
+	ApproverRequired Reason = "ApproverRequired"
+	ApprovalForged   Reason = "ApprovalForged"
+)
+
+var catalogs = map[Language]map[Reason]string{
+	English: {
+		Ready:          "All good",
+		Suspended:      "Environment is hibernated",
+		Planned:        "Dry run: the environment has not been created yet",
+		ReconcileError: "%v",
+
+		Rejected:                  "Environments cannot be requested from this namespace: %v",
+		PoolRejected:              "Pool rejected: %v",
+		NamespaceNotAllowed:       "namespace %v is not in the allowed list: %v",
+		NamespaceSelectorMismatch: "labels of namespace %v do not match the selector %v",
+
+		AwaitingApproval:    "Environment is awaiting approval: %v",
+		RestrictedRole:      "role %v requested",
+		QuotaAboveThreshold: "%v %v requested, threshold %v",
+
+		NamespaceConflict:           "namespace %v already exists",
+		NamespaceTemplateFailed:     "cannot render the namespace name: %v",
+		NamespaceNameInvalid:        "namespace name %q rendered from the template is invalid: %v",
+		PoolNotFound:                "pool %v not found",
+		SeedNotFound:                "seed %v %v not found in the pool namespace",
+		SeedKindUnsupported:         "seed kind %v is not supported",
+		QuotaNameReserved:           "quota name %v is reserved for createQuota",
+		QuotaDuplicated:             "quota %v is listed more than once",
+		QuotaLimitsMissing:          "quota %v has no limits",
+		ChildDuplicated:             "child namespace %v is listed more than once",
+		ChildNamespaceNameInvalid:   "child namespace name %q is invalid: %v",
+		ChildResourceNotInParent:    "resource %v of child namespace %v is missing from the parent quota",
+		ChildQuotaExceedsParent:     "child namespace quotas for %v exceed the parent quota %v",
+		AutoscalingThresholdInvalid: "quota scale-down threshold %v%% must be below the scale-up threshold %v%%",
+		AutoscalingMaxBelowQuota:    "quota maximum for %v (%v) is below createQuota (%v)",
+		AutoscalingMaxMissing:       "quotaAutoscaling.max has no limit for %v; every createQuota resource needs one",
+		RoleNotAllowed:              "ClusterRole %v is not allowed; allowed roles: %v",
+		PoolSizeExceeded:            "pool size %v exceeds defaults.maxPoolSize %v",
+		PoolApprovalRequired:        "pools cannot be approved, so their namespaces may not get what requires approval: %v",
+		TimeZoneInvalid:             "invalid time zone %q: %v",
+		SleepScheduleEmpty:          "sleep and wake-up times are the same: %v",
+		ClockInvalid:                "invalid time %q: %v",
+		AnnotationInvalid:           "invalid value of annotation %v on %v: %v",
+
+		Expired:           "Environment deleted: %v",
+		ExpiringSoon:      "Environment will be deleted at %v: %v",
+		ExpiresAt:         "Environment will be deleted at %v",
+		BelowThreshold:    "Quota usage is below %v%%",
+		ThresholdExceeded: "Resource %v is %v%% used, threshold %v%%",
+		ChangesPending:    "Dry run: %v changes planned",
+		UpToDate:          "Dry run: no changes",
+		ClaimedFromPool:   "Namespace %v claimed from pool %v",
+		PoolEmpty:         "Pool %v has no free namespaces",
+		QuotaScaled:       "Quota of %v changed: %v -> %v",
+
+		PlanPoolClaim:   "* namespace will be claimed from pool %v if it has a free one",
+		PlanRecreate:    "(recreate)",
+		PlanNoValue:     "<none>",
+		PlanSleep:       "(hibernation)",
+		PlanWake:        "(wake-up)",
+		PlanQuotaScaled: "(quota autoscaling)",
+		PlanExpires:     "deleted at %v by %v",
+		PlanPoolFill:    "+ Namespace %v-<random> (%v of %v)",
+
+		EventIgnored:         "event does not affect environments",
+		RepositoryNotServed:  "repository %v is not served by the trigger",
+		BranchNotMatched:     "branch %v does not match any rule",
+		BranchPatternInvalid: "invalid branch regular expression %q: %v",
+		EnvironmentCreated:   "created environment %v for branch %v",
+		EnvironmentExists:    "environment %v for branch %v already exists",
+		EnvironmentNotFound:  "environment %v for branch %v not found",
+		EnvironmentDeleted:   "deleted environment %v of branch %v",
+		EnvironmentActivity:  "marked activity of environment %v of branch %v",
+
+		NotifyActive:       "Environment %v is ready",
+		NotifyError:        "Environment %v failed",
+		NotifyExpiringSoon: "Environment %v will be deleted soon",
+		NotifyDeleted:      "Environment %v deleted",
+		NotifyEvent:        "Environment %v: %v",
+
+		MethodNotAllowed:       "method not allowed",
+		RequestBodyTooLarge:    "request body exceeds %v bytes",
+		RequestBodyInvalid:     "invalid request body: %v",
+		RequestBodyUnreadable:  "cannot read the request body: %v",
+		WaitInvalid:            "invalid wait parameter: %v",
+		EnvironmentNameMissing: "environment name is not set",
+		ShardNotServed:         "shard %q is not served by this instance (%v)",
+		EnvironmentUnknown:     "environment not found",
+		BearerTokenMissing:     "the Authorization: Bearer <token> header is required",
+		TokenReviewFailed:      "cannot verify the token",
+		TokenInvalid:           "the token is not valid",
+		AccessReviewFailed:     "cannot verify permissions",
+		AccessDenied:           "user %v may not %v dynamicnamespaces in namespace %v",
+		HookPathInvalid:        "expected path /hooks/<namespace>/<trigger name>",
+		TriggerNotFound:        "trigger not found",
+		TriggerReadFailed:      "cannot read the trigger",
+		TriggerLookupLimited:   "too many events for unknown or changed triggers, retry later",
+		TriggerSecretFailed:    "cannot read the trigger secret",
+		ProviderUnknown:        "unknown provider %q",
+		GitLabTokenInvalid:     "invalid X-Gitlab-Token",
+		SignatureMissing:       "the X-Hub-Signature-256 signature is missing",
+		SignatureMalformed:     "malformed signature",
+		SignatureMismatch:      "signature does not match",
+		EventInvalid:           "invalid event: %v",
+
+		ApproverRequired: "user %v is not in the groups that approve environments: %v",
+		ApprovalForged:   "annotations %v and %v are only set through %v",
+	},
+	Russian: {
+		Ready:          "Все хорошо",
+		Suspended:      "Окружение в спящем режиме",
+		Planned:        "Dry-run: окружение еще не создавалось",
+		ReconcileError: "%v",
+
+		Rejected:                  "Окружения нельзя запрашивать из этого namespace: %v",
+		PoolRejected:              "Пул отклонен: %v",
+		NamespaceNotAllowed:       "namespace %v не входит в список разрешенных: %v",
+		NamespaceSelectorMismatch: "лейблы namespace %v не подходят под селектор %v",
+
+		AwaitingApproval:    "Окружение ожидает подтверждения: %v",
+		RestrictedRole:      "запрошена роль %v",
+		QuotaAboveThreshold: "запрошено %v %v при пороге %v",
+
+		NamespaceConflict:           "namespace %v с таким именем уже существует",
+		NamespaceTemplateFailed:     "ошибка при вычислении имени namespace: %v",
+		NamespaceNameInvalid:        "имя namespace %q, полученное по шаблону, некорректно: %v",
+		PoolNotFound:                "пул %v не найден",
+		SeedNotFound:                "объект заполнения %v %v не найден в namespace пула",
+		SeedKindUnsupported:         "тип объекта заполнения %v не поддерживается",
+		QuotaNameReserved:           "имя квоты %v зарезервировано для createQuota",
+		QuotaDuplicated:             "квота %v указана несколько раз",
+		QuotaLimitsMissing:          "у квоты %v не заданы лимиты",
+		ChildDuplicated:             "дочерний namespace %v указан несколько раз",
+		ChildNamespaceNameInvalid:   "имя дочернего namespace %q некорректно: %v",
+		ChildResourceNotInParent:    "ресурс %v дочернего namespace %v отсутствует в квоте родителя",
+		ChildQuotaExceedsParent:     "сумма квот дочерних namespace по ресурсу %v превышает квоту родителя %v",
+		AutoscalingThresholdInvalid: "порог уменьшения квоты %v%% должен быть меньше порога увеличения %v%%",
+		AutoscalingMaxBelowQuota:    "максимум квоты %v (%v) меньше createQuota (%v)",
+		AutoscalingMaxMissing:       "в quotaAutoscaling.max не задан максимум для %v; он обязателен для каждого ресурса createQuota",
+		RoleNotAllowed:              "ClusterRole %v не разрешена; разрешенные роли: %v",
+		PoolSizeExceeded:            "размер пула %v больше defaults.maxPoolSize %v",
+		PoolApprovalRequired:        "пулы нельзя подтвердить, поэтому их namespace не могут получить то, что требует подтверждения: %v",
+		TimeZoneInvalid:             "некорректный часовой пояс %q: %v",
+		SleepScheduleEmpty:          "время засыпания и пробуждения совпадают: %v",
+		ClockInvalid:                "некорректное время %q: %v",
+		AnnotationInvalid:           "некорректное значение аннотации %v у [%v]: %v",
+
+		Expired:           "Окружение удалено: %v",
+		ExpiringSoon:      "Окружение будет удалено %v: %v",
+		ExpiresAt:         "Окружение будет удалено %v",
+		BelowThreshold:    "Использование квоты ниже %v%%",
+		ThresholdExceeded: "Ресурс %v использован на %v%%, порог %v%%",
+		ChangesPending:    "Dry-run: запланировано изменений: %v",
+		UpToDate:          "Dry-run: изменений нет",
+		ClaimedFromPool:   "Namespace %v взят из пула %v",
+		PoolEmpty:         "В пуле %v нет свободных namespace",
+		QuotaScaled:       "Квота ресурса %v изменена: %v -> %v",
+
+		PlanPoolClaim:   "* namespace будет взят из пула %v, если в нем есть свободный",
+		PlanRecreate:    "(пересоздание)",
+		PlanNoValue:     "<нет>",
+		PlanSleep:       "(спящий режим)",
+		PlanWake:        "(пробуждение)",
+		PlanQuotaScaled: "(автомасштабирование квоты)",
+		PlanExpires:     "удаление в %v по %v",
+		PlanPoolFill:    "+ Namespace %v-<случайный суффикс> (%v из %v)",
+
+		EventIgnored:         "событие не влияет на окружения",
+		RepositoryNotServed:  "репозиторий %v не обслуживается триггером",
+		BranchNotMatched:     "ветка %v не подходит ни под одно правило",
+		BranchPatternInvalid: "некорректное регулярное выражение ветки %q: %v",
+		EnvironmentCreated:   "создано окружение %v для ветки %v",
+		EnvironmentExists:    "окружение %v для ветки %v уже существует",
+		EnvironmentNotFound:  "окружение %v для ветки %v не найдено",
+		EnvironmentDeleted:   "удалено окружение %v ветки %v",
+		EnvironmentActivity:  "отмечена активность окружения %v ветки %v",
+
+		NotifyActive:       "Окружение %v готово",
+		NotifyError:        "Ошибка окружения %v",
+		NotifyExpiringSoon: "Окружение %v скоро будет удалено",
+		NotifyDeleted:      "Окружение %v удалено",
+		NotifyEvent:        "Окружение %v: %v",
+
+		MethodNotAllowed:       "метод не поддерживается",
+		RequestBodyTooLarge:    "тело запроса больше %v байт",
+		RequestBodyInvalid:     "некорректное тело запроса: %v",
+		RequestBodyUnreadable:  "ошибка при чтении тела запроса: %v",
+		WaitInvalid:            "некорректный параметр wait: %v",
+		EnvironmentNameMissing: "не задано имя окружения",
+		ShardNotServed:         "шард %q не обслуживается этим экземпляром (%v)",
+		EnvironmentUnknown:     "окружение не найдено",
+		BearerTokenMissing:     "требуется заголовок Authorization: Bearer <token>",
+		TokenReviewFailed:      "не удалось проверить токен",
+		TokenInvalid:           "токен не прошел проверку",
+		AccessReviewFailed:     "не удалось проверить права",
+		AccessDenied:           "пользователю %v запрещено %v dynamicnamespaces в namespace %v",
+		HookPathInvalid:        "ожидается адрес /hooks/<namespace>/<имя триггера>",
+		TriggerNotFound:        "триггер не найден",
+		TriggerReadFailed:      "ошибка при чтении триггера",
+		TriggerLookupLimited:   "слишком много событий для неизвестных или измененных триггеров, повторите позже",
+		TriggerSecretFailed:    "ошибка при чтении секрета триггера",
+		ProviderUnknown:        "неизвестный провайдер %q",
+		GitLabTokenInvalid:     "неверный X-Gitlab-Token",
+		SignatureMissing:       "отсутствует подпись X-Hub-Signature-256",
+		SignatureMalformed:     "некорректный формат подписи",
+		SignatureMismatch:      "подпись не совпадает",
+		EventInvalid:           "некорректное событие: %v",
+
+		ApproverRequired: "пользователь %v не входит в группы, подтверждающие окружения: %v",
+		ApprovalForged:   "аннотации %v и %v выставляются только через %v",
+	},
+}
@@ -0,0 +1,108 @@
+package messages
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Language - язык сообщений статусов и событий
+type Language string
+
+const (
+	English Language = "en"
+	Russian Language = "ru"
+
+	// DefaultLanguage используется, если язык не задан в конфигурации
+	DefaultLanguage = English
+)
+
+// ParseLanguage проверяет язык из конфигурации. Пустая строка - язык по умолчанию
+func ParseLanguage(value string) (Language, error) {
+	if value == "" {
+		return DefaultLanguage, nil
+	}
+	var language = Language(strings.ToLower(value))
+	if _, ok := catalogs[language]; !ok {
+		return "", fmt.Errorf("язык сообщений %q не поддерживается, доступны: %v, %v", value, English, Russian)
+	}
+	return language, nil
+}
+
+// Reason - стабильный машиночитаемый код сообщения. Не зависит от языка и не меняется
+// между версиями, поэтому по нему можно строить автоматизацию
+type Reason string
+
+// Message - сообщение каталога: код и аргументы шаблона. Аргументы типа Message и
+// ошибки с кодом переводятся на тот же язык, что и само сообщение
+type Message struct {
+	Reason Reason
+	Args   []interface{}
+}
+
+// New создает сообщение каталога
+func New(reason Reason, args ...interface{}) Message {
+	return Message{Reason: reason, Args: args}
+}
+
+// String возвращает текст сообщения на языке по умолчанию
+func (m Message) String() string {
+	return DefaultLanguage.Text(m)
+}
+
+// Error - ошибка с кодом каталога. Текст переводится при записи в статус
+type Error struct {
+	Message
+}
+
+// Errorf создает ошибку с кодом каталога
+func Errorf(reason Reason, args ...interface{}) error {
+	return &Error{Message: New(reason, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.Message.String()
+}
+
+// FromError возвращает сообщение ошибки. Ошибки без кода (например, ошибки API-сервера)
+// получают код ReconcileError и исходный текст
+func FromError(err error) Message {
+	var coded *Error
+	if errors.As(err, &coded) {
+		return coded.Message
+	}
+	return New(ReconcileError, err)
+}
+
+// Text переводит сообщение. Если перевода нет, используется английский текст
+func (l Language) Text(message Message) string {
+	format, ok := catalogs[l][message.Reason]
+	if !ok {
+		format, ok = catalogs[DefaultLanguage][message.Reason]
+	}
+	if !ok {
+		return string(message.Reason)
+	}
+	var args = make([]interface{}, len(message.Args))
+	for i, arg := range message.Args {
+		switch value := arg.(type) {
+		case Message:
+			args[i] = l.Text(value)
+		case error:
+			var coded *Error
+			if errors.As(value, &coded) {
+				args[i] = l.Text(coded.Message)
+			} else {
+				args[i] = value.Error()
+			}
+		default:
+			args[i] = arg
+		}
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// ErrorText переводит ошибку: текст ошибок с кодом берется из каталога
+func (l Language) ErrorText(err error) string {
+	return l.Text(FromError(err))
+}
@@ -0,0 +1,74 @@
+package messages
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	// Сообщение, переведенное только на английский
+	const untranslated Reason = "Untranslated"
+	catalogs[English][untranslated] = "only in English: %v"
+	defer delete(catalogs[English], untranslated)
+
+	var tests = []struct {
+		name     string
+		language Language
+		message  Message
+		want     string
+	}{
+		{name: "english", language: English, message: New(PoolNotFound, "warm"), want: "pool warm not found"},
+		{name: "russian", language: Russian, message: New(PoolNotFound, "warm"), want: "пул warm не найден"},
+		{name: "no translation falls back to english", language: Russian, message: New(untranslated, 1), want: "only in English: 1"},
+		{name: "unknown language falls back to english", language: "de", message: New(PoolNotFound, "warm"), want: "pool warm not found"},
+		{name: "unknown reason", language: Russian, message: New("Unknown"), want: "Unknown"},
+		{name: "nested message", language: Russian, message: New(PoolRejected, New(NamespaceNotAllowed, "dev", "prod")),
+			want: "Пул отклонен: namespace dev не входит в список разрешенных: prod"},
+		{name: "coded error", language: Russian, message: New(ReconcileError, fmt.Errorf("claim: %w", Errorf(PoolEmpty, "warm"))),
+			want: "В пуле warm нет свободных namespace"},
+		{name: "plain error", language: Russian, message: FromError(errors.New("connection refused")), want: "connection refused"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.language.Text(test.message); got != test.want {
+				t.Errorf("Text() = %q, ожидалось %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCatalogsComplete(t *testing.T) {
+	for language, catalog := range catalogs {
+		for reason := range catalogs[English] {
+			if _, ok := catalog[reason]; !ok {
+				t.Errorf("нет перевода %v на %v", reason, language)
+			}
+		}
+		for reason := range catalog {
+			if _, ok := catalogs[English][reason]; !ok {
+				t.Errorf("сообщение %v на %v отсутствует в английском каталоге", reason, language)
+			}
+		}
+	}
+}
+
+func TestParseLanguage(t *testing.T) {
+	var tests = []struct {
+		value string
+		want  Language
+		ok    bool
+	}{
+		{value: "", want: English, ok: true},
+		{value: "RU", want: Russian, ok: true},
+		{value: "de"},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := ParseLanguage(test.value)
+			if (err == nil) != test.ok || got != test.want {
+				t.Errorf("ParseLanguage(%q) = %v, %v; ожидалось %v", test.value, got, err, test.want)
+			}
+		})
+	}
+}
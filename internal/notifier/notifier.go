@@ -0,0 +1,378 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-logr/logr"
+	configv1alpha1 "github.com/wbe7/dynamicnamespace/api/config/v1alpha1"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Типы событий жизненного цикла окружения
+const (
+	EventActive       = "Active"
+	EventError        = "Error"
+	EventExpiringSoon = "ExpiringSoon"
+	EventDeleted      = "Deleted"
+)
+
+const (
+	// configKey - ключ Secret или ConfigMap с конфигурацией уведомлений
+	configKey = "config.yaml"
+
+	defaultRetries = 5
+	defaultTimeout = 10 * time.Second
+	queueSize      = 1000
+)
+
+// Event describes a lifecycle change of a DynamicNamespace
+type Event struct {
+	Type            string     `json:"type"`
+	Name            string     `json:"name"`
+	Namespace       string     `json:"namespace"`
+	TargetNamespace string     `json:"targetNamespace,omitempty"`
+	Message         string     `json:"message,omitempty"`
+	ExpiresAt       *time.Time `json:"expiresAt,omitempty"`
+	Time            time.Time  `json:"time"`
+}
+
+// key возвращает ключ DynamicNamespace для логов
+func (e Event) key() string {
+	return e.Namespace + "/" + e.Name
+}
+
+// Config is the notifier configuration stored in a Secret or ConfigMap under config.yaml
+type Config struct {
+	Endpoints []Endpoint `json:"endpoints"`
+	// Количество попыток доставки. По умолчанию 5
+	Retries int `json:"retries,omitempty"`
+	// Таймаут одного запроса, например 10s
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Endpoint is a single webhook receiving notifications
+type Endpoint struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Формат тела запроса: generic (по умолчанию), slack или teams
+	Format string `json:"format,omitempty"`
+	// Типы событий. Пустой список - все события
+	Events []string `json:"events,omitempty"`
+	// Дополнительные заголовки, например Authorization
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Notifier posts lifecycle events to the webhooks configured in a Secret or ConfigMap
+type Notifier struct {
+	// Reader читает конфигурацию напрямую из API-сервера
+	Reader client.Reader
+	// Ссылка на конфигурацию: Secret/<namespace>/<name> или ConfigMap/<namespace>/<name>.
+	// Если ссылка пуста, используется конфигурация, переданная через SetConfig
+	ConfigRef string
+	// Язык заголовков уведомлений Slack и Teams; nil - язык по умолчанию
+	Language func() messages.Language
+
+	// Конфигурация из файла контроллера
+	inline     atomic.Value
+	configKind string
+	configName types.NamespacedName
+	httpClient *http.Client
+	queue      chan Event
+	log        logr.Logger
+}
+
+// +kubebuilder:rbac:groups=core,resources=secrets;configmaps,verbs=get
+
+// SetupWithManager registers the notifier as a manager runnable.
+func (n *Notifier) SetupWithManager(mgr ctrl.Manager) error {
+	n.log = ctrl.Log.WithName("notifier")
+	if n.ConfigRef != "" {
+		var parts = strings.Split(n.ConfigRef, "/")
+		if len(parts) != 3 || (parts[0] != "Secret" && parts[0] != "ConfigMap") {
+			return fmt.Errorf("некорректная ссылка на конфигурацию уведомлений %q, ожидается Secret/<namespace>/<name> или ConfigMap/<namespace>/<name>", n.ConfigRef)
+		}
+		n.configKind = parts[0]
+		n.configName = types.NamespacedName{Namespace: parts[1], Name: parts[2]}
+	}
+	if n.Reader == nil {
+		n.Reader = mgr.GetAPIReader()
+	}
+	n.httpClient = &http.Client{}
+	n.queue = make(chan Event, queueSize)
+	return mgr.Add(n)
+}
+
+// NeedLeaderElection реализует manager.LeaderElectionRunnable: события порождает только лидер
+func (n *Notifier) NeedLeaderElection() bool {
+	return true
+}
+
+// Start доставляет события до остановки менеджера
+func (n *Notifier) Start(ctx context.Context) error {
+	if n.ConfigRef != "" {
+		n.log.Info("Запуск уведомлений", "configRef", n.ConfigRef)
+	} else {
+		n.log.Info("Запуск уведомлений, конфигурация из файла контроллера")
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-n.queue:
+			n.dispatch(ctx, event)
+		}
+	}
+}
+
+// SetConfig заменяет конфигурацию, используемую при пустой ConfigRef. nil отключает уведомления
+func (n *Notifier) SetConfig(config *Config) {
+	n.inline.Store(config)
+}
+
+// Notify ставит событие в очередь доставки. Безопасен для nil и выключенного notifier
+func (n *Notifier) Notify(event Event) {
+	if n == nil || n.queue == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	select {
+	case n.queue <- event:
+	default:
+		n.log.Info("Очередь уведомлений переполнена, событие отброшено", "event", event.Type, "dynamicnamespace", event.key())
+	}
+}
+
+// language возвращает текущий язык уведомлений
+func (n *Notifier) language() messages.Language {
+	if n.Language == nil {
+		return messages.DefaultLanguage
+	}
+	return n.Language()
+}
+
+// dispatch читает актуальную конфигурацию и отправляет событие всем подходящим адресатам
+func (n *Notifier) dispatch(ctx context.Context, event Event) {
+	config, err := n.loadConfig(ctx)
+	if err != nil {
+		n.log.Error(err, "Ошибка при чтении конфигурации уведомлений", "event", event.Type, "dynamicnamespace", event.key())
+		return
+	}
+	if config == nil {
+		return
+	}
+	var retries = config.Retries
+	if retries <= 0 {
+		retries = defaultRetries
+	}
+	var timeout = defaultTimeout
+	if config.Timeout != "" {
+		timeout, err = time.ParseDuration(config.Timeout)
+		if err != nil {
+			n.log.Error(err, "Некорректный таймаут уведомлений", "timeout", config.Timeout)
+			return
+		}
+	}
+
+	for _, endpoint := range config.Endpoints {
+		if !endpoint.accepts(event.Type) {
+			continue
+		}
+		body, err := format(endpoint.Format, event, n.language())
+		if err != nil {
+			n.log.Error(err, "Ошибка при формировании уведомления", "endpoint", endpoint.Name, "event", event.Type, "dynamicnamespace", event.key())
+			continue
+		}
+		// Доставка в отдельной горутине, чтобы повторы не задерживали остальные события
+		go n.deliver(ctx, endpoint, body, retries, timeout, event)
+	}
+}
+
+// deliver отправляет уведомление с повторами и экспоненциальной задержкой
+func (n *Notifier) deliver(ctx context.Context, endpoint Endpoint, body []byte, retries int, timeout time.Duration, event Event) {
+	var backoff = wait.Backoff{Duration: time.Second, Factor: 2, Jitter: 0.1, Steps: retries, Cap: time.Minute}
+	var lastErr error
+	err := wait.ExponentialBackoffWithContext(ctx, backoff, func() (bool, error) {
+		lastErr = n.post(ctx, endpoint, body, timeout)
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		n.log.Error(lastErr, "Не удалось доставить уведомление", "endpoint", endpoint.Name, "event", event.Type, "dynamicnamespace", event.key())
+		return
+	}
+	n.log.Info("Уведомление доставлено", "endpoint", endpoint.Name, "event", event.Type, "dynamicnamespace", event.key())
+}
+
+func (n *Notifier) post(ctx context.Context, endpoint Endpoint, body []byte, timeout time.Duration) error {
+	var requestCtx, cancel = context.WithTimeout(ctx, timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(requestCtx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range endpoint.Headers {
+		request.Header.Set(key, value)
+	}
+	response, err := n.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("ответ %v", response.Status)
+	}
+	return nil
+}
+
+// loadConfig возвращает конфигурацию из Secret или ConfigMap, а без ConfigRef - переданную через SetConfig
+func (n *Notifier) loadConfig(ctx context.Context) (*Config, error) {
+	var data []byte
+	switch n.configKind {
+	case "":
+		config, _ := n.inline.Load().(*Config)
+		return config, nil
+	case "Secret":
+		var secret v1.Secret
+		err := n.Reader.Get(ctx, n.configName, &secret)
+		if err != nil {
+			return nil, err
+		}
+		data = secret.Data[configKey]
+	case "ConfigMap":
+		var configMap v1.ConfigMap
+		err := n.Reader.Get(ctx, n.configName, &configMap)
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(configMap.Data[configKey])
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("в %v %v нет ключа %v", n.configKind, n.configName, configKey)
+	}
+	var config Config
+	err := yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// ConfigFrom переводит раздел notifier файла конфигурации контроллера в конфигурацию уведомлений
+func ConfigFrom(spec *configv1alpha1.NotifierConfig) *Config {
+	if len(spec.Endpoints) == 0 {
+		return nil
+	}
+	var config = &Config{Retries: spec.Retries}
+	if spec.Timeout != nil {
+		config.Timeout = spec.Timeout.Duration.String()
+	}
+	for _, endpoint := range spec.Endpoints {
+		config.Endpoints = append(config.Endpoints, Endpoint{
+			Name:    endpoint.Name,
+			URL:     endpoint.URL,
+			Format:  endpoint.Format,
+			Events:  endpoint.Events,
+			Headers: endpoint.Headers,
+		})
+	}
+	return config
+}
+
+func (e *Endpoint) accepts(eventType string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, t := range e.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// format формирует тело запроса в формате адресата
+func format(name string, event Event, language messages.Language) ([]byte, error) {
+	switch name {
+	case "", "generic":
+		return json.Marshal(event)
+	case "slack":
+		return json.Marshal(map[string]interface{}{
+			"text": fmt.Sprintf("*%v*\n%v", title(event, language), details(event)),
+		})
+	case "teams":
+		var facts = []map[string]string{
+			{"name": "DynamicNamespace", "value": event.Namespace + "/" + event.Name},
+		}
+		if event.TargetNamespace != "" {
+			facts = append(facts, map[string]string{"name": "Namespace", "value": event.TargetNamespace})
+		}
+		if event.ExpiresAt != nil {
+			facts = append(facts, map[string]string{"name": "Expires", "value": event.ExpiresAt.Format(time.RFC3339)})
+		}
+		return json.Marshal(map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "http://schema.org/extensions",
+			"summary":    title(event, language),
+			"title":      title(event, language),
+			"themeColor": color(event),
+			"text":       event.Message,
+			"sections":   []interface{}{map[string]interface{}{"facts": facts}},
+		})
+	}
+	return nil, fmt.Errorf("неизвестный формат %q", name)
+}
+
+func title(event Event, language messages.Language) string {
+	switch event.Type {
+	case EventActive:
+		return language.Text(messages.New(messages.NotifyActive, event.Name))
+	case EventError:
+		return language.Text(messages.New(messages.NotifyError, event.Name))
+	case EventExpiringSoon:
+		return language.Text(messages.New(messages.NotifyExpiringSoon, event.Name))
+	case EventDeleted:
+		return language.Text(messages.New(messages.NotifyDeleted, event.Name))
+	}
+	return language.Text(messages.New(messages.NotifyEvent, event.Name, event.Type))
+}
+
+func details(event Event) string {
+	var lines = []string{fmt.Sprintf("DynamicNamespace: %v/%v", event.Namespace, event.Name)}
+	if event.TargetNamespace != "" {
+		lines = append(lines, fmt.Sprintf("Namespace: %v", event.TargetNamespace))
+	}
+	if event.ExpiresAt != nil {
+		lines = append(lines, fmt.Sprintf("Expires: %v", event.ExpiresAt.Format(time.RFC3339)))
+	}
+	if event.Message != "" {
+		lines = append(lines, event.Message)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func color(event Event) string {
+	switch event.Type {
+	case EventActive:
+		return "2EB886"
+	case EventError:
+		return "D40E0D"
+	case EventExpiringSoon:
+		return "DAA038"
+	}
+	return "808080"
+}
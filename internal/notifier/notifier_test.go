@@ -0,0 +1,65 @@
+package notifier
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+)
+
+func TestFormat(t *testing.T) {
+	var event = Event{Type: EventActive, Name: "feature", Namespace: "team-a", TargetNamespace: "feature"}
+	var tests = []struct {
+		name     string
+		format   string
+		language messages.Language
+		field    string
+		want     string
+	}{
+		{name: "slack english", format: "slack", language: messages.English, field: "text",
+			want: "*Environment feature is ready*\nDynamicNamespace: team-a/feature\nNamespace: feature"},
+		{name: "slack russian", format: "slack", language: messages.Russian, field: "text",
+			want: "*Окружение feature готово*\nDynamicNamespace: team-a/feature\nNamespace: feature"},
+		{name: "teams english", format: "teams", language: messages.English, field: "title", want: "Environment feature is ready"},
+		{name: "teams russian", format: "teams", language: messages.Russian, field: "title", want: "Окружение feature готово"},
+		{name: "generic", format: "generic", language: messages.Russian, field: "type", want: EventActive},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := format(test.format, event, test.language)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(data, &body); err != nil {
+				t.Fatal(err)
+			}
+			if body[test.field] != test.want {
+				t.Errorf("%v = %q, ожидалось %q", test.field, body[test.field], test.want)
+			}
+		})
+	}
+	if _, err := format("email", event, messages.English); err == nil {
+		t.Error("неизвестный формат без ошибки")
+	}
+}
+
+func TestTitle(t *testing.T) {
+	var tests = []struct {
+		event string
+		want  string
+	}{
+		{event: EventActive, want: "Environment feature is ready"},
+		{event: EventError, want: "Environment feature failed"},
+		{event: EventExpiringSoon, want: "Environment feature will be deleted soon"},
+		{event: EventDeleted, want: "Environment feature deleted"},
+		{event: "Claimed", want: "Environment feature: Claimed"},
+	}
+	for _, test := range tests {
+		t.Run(test.event, func(t *testing.T) {
+			if got := title(Event{Type: test.event, Name: "feature"}, messages.English); got != test.want {
+				t.Errorf("title() = %q, ожидалось %q", got, test.want)
+			}
+		})
+	}
+}
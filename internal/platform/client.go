@@ -2,17 +2,24 @@ package platform
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+
 	"github.com/ghodss/yaml"
-	"github.com/sirupsen/logrus"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apiextensions "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kjson "k8s.io/apimachinery/pkg/util/json"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
+// RevisionAnnotation хранит ревизию схемы CRD, установленной контроллером
+const RevisionAnnotation = "platform.cloudnative.space/crd-revision"
+
 // PlatformClient - Платформенный клиент
 type PlatformClient struct {
 	Client          client.Client
@@ -28,42 +35,86 @@ func NewPlatformClient(kubeConfig *rest.Config, client client.Client) *PlatformC
 	}
 }
 
-func (c *PlatformClient) DeployCRD(ctx context.Context, crdData []byte) {
-	var crd = c.LoadCRD(ctx, crdData)
+// DeployCRD создает или обновляет CRD из встроенного YAML с ревизией revision.
+// CRD с большей ревизией не заменяется, а CRD, в которой хранятся версии,
+// отсутствующие во встроенной схеме, считается несовместимой
+func (c *PlatformClient) DeployCRD(ctx context.Context, crdData []byte, revision int) error {
+	crd, err := c.LoadCRD(crdData)
+	if err != nil {
+		return err
+	}
+	if crd.Annotations == nil {
+		crd.Annotations = map[string]string{}
+	}
+	crd.Annotations[RevisionAnnotation] = strconv.Itoa(revision)
 
-	currentCrd, err := c.apiextClientset.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, crd.Name, metav1.GetOptions{})
-	if currentCrd == nil || currentCrd.Name == "" {
-		_, err = c.apiextClientset.ApiextensionsV1().CustomResourceDefinitions().Create(ctx, crd, metav1.CreateOptions{})
+	var crds = c.apiextClientset.ApiextensionsV1().CustomResourceDefinitions()
+	currentCrd, err := crds.Get(ctx, crd.Name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		_, err = crds.Create(ctx, crd, metav1.CreateOptions{})
 		if err != nil {
-			c.log(ctx).Fatalf("Ошибка при деплое CRD: %v; %v", err, crd)
+			return fmt.Errorf("ошибка при создании CRD %v: %v", crd.Name, err)
+		}
+		ctrllog.FromContext(ctx).Info("Успешно создан CRD", "crd", crd.Name, "revision", revision)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка при чтении CRD %v: %v", crd.Name, err)
+	}
+
+	for _, stored := range currentCrd.Status.StoredVersions {
+		if !servesVersion(crd, stored) {
+			return fmt.Errorf("CRD %v хранит объекты в версии %v, которой нет во встроенной схеме", crd.Name, stored)
 		}
-		c.log(ctx).Infof("Успешно создан CRD: %v", crd.Name)
-	} else {
-		crd.ResourceVersion = currentCrd.ResourceVersion
-		_, err = c.apiextClientset.ApiextensionsV1().CustomResourceDefinitions().Update(ctx, crd, metav1.UpdateOptions{})
+	}
+
+	// CRD без аннотации установлен вручную или контроллером без ревизий и обновляется
+	var currentRevision = 0
+	if value, ok := currentCrd.Annotations[RevisionAnnotation]; ok {
+		currentRevision, err = strconv.Atoi(value)
 		if err != nil {
-			c.log(ctx).Fatalf("Ошибка при деплое CRD: %v; %v", err, crd)
+			return fmt.Errorf("некорректная ревизия CRD %v: %q", crd.Name, value)
 		}
-		c.log(ctx).Infof("Успешно обновлен CRD: %v", crd.Name)
 	}
+	switch {
+	case currentRevision > revision:
+		ctrllog.FromContext(ctx).Info("CRD в кластере новее встроенной, обновление пропущено", "crd", crd.Name, "clusterRevision", currentRevision, "revision", revision)
+		return nil
+	case currentRevision == revision:
+		ctrllog.FromContext(ctx).Info("CRD актуален", "crd", crd.Name, "revision", revision)
+		return nil
+	}
+
+	crd.ResourceVersion = currentCrd.ResourceVersion
+	_, err = crds.Update(ctx, crd, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("ошибка при обновлении CRD %v: %v", crd.Name, err)
+	}
+	ctrllog.FromContext(ctx).Info("Успешно обновлен CRD", "crd", crd.Name, "previousRevision", currentRevision, "revision", revision)
+	return nil
 }
 
-func (c *PlatformClient) LoadCRD(ctx context.Context, crdData []byte) *apiextensionsv1.CustomResourceDefinition {
+func (c *PlatformClient) LoadCRD(crdData []byte) (*apiextensionsv1.CustomResourceDefinition, error) {
 	var crd = apiextensionsv1.CustomResourceDefinition{}
 
 	var jsonData, err = yaml.YAMLToJSON(crdData)
 	if err != nil {
-		c.log(ctx).Fatalf("Ошибка при конвертации CRD из YAML в Json: %v; %v", err, string(crdData))
+		return nil, fmt.Errorf("ошибка при конвертации CRD из YAML в Json: %v", err)
 	}
 
 	err = kjson.Unmarshal(jsonData, &crd)
 	if err != nil {
-		c.log(ctx).Fatalf("Ошибка при десериализации CRD: %v; %v", err, string(crdData))
+		return nil, fmt.Errorf("ошибка при десериализации CRD: %v", err)
 	}
 
-	return &crd
+	return &crd, nil
 }
 
-func (c *PlatformClient) log(ctx context.Context) *logrus.Entry {
-	return ctx.Value("log").(*logrus.Entry)
+func servesVersion(crd *apiextensionsv1.CustomResourceDefinition, version string) bool {
+	for _, v := range crd.Spec.Versions {
+		if v.Name == version {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,117 @@
+package platform
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// DefaultCRDTimeout - время ожидания готовности CRD по умолчанию
+	DefaultCRDTimeout = time.Minute
+
+	crdPollInterval = time.Second
+)
+
+// CRDInstaller installs the embedded CRDs, waits until they are served and
+// reports their readiness to the readyz check
+type CRDInstaller struct {
+	// Устанавливать и обновлять CRD. Выключается, когда CRD управляет GitOps
+	Install bool
+	// Время ожидания условий Established и NamesAccepted
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	client *PlatformClient
+	names  []string
+}
+
+// Setup устанавливает CRD (если включено) и ждет, пока API-сервер начнет его обслуживать.
+// До этого информеры контроллера не могут запуститься
+func (i *CRDInstaller) Setup(ctx context.Context, client *PlatformClient, crdData []byte, revision int) error {
+	crd, err := client.LoadCRD(crdData)
+	if err != nil {
+		return err
+	}
+	if i.Install {
+		err = client.DeployCRD(ctx, crdData, revision)
+		if err != nil {
+			return err
+		}
+	}
+
+	var timeout = i.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCRDTimeout
+	}
+	err = client.WaitForCRD(ctx, crd.Name, timeout)
+	if err != nil {
+		return err
+	}
+	ctrllog.FromContext(ctx).Info("CRD готов", "crd", crd.Name)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.client = client
+	i.names = append(i.names, crd.Name)
+	return nil
+}
+
+// Check реализует healthz.Checker: все установленные CRD существуют и обслуживаются
+func (i *CRDInstaller) Check(req *http.Request) error {
+	i.mu.Lock()
+	var client = i.client
+	var names = append([]string(nil), i.names...)
+	i.mu.Unlock()
+
+	for _, name := range names {
+		err := client.CRDEstablished(req.Context(), name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitForCRD ждет условий Established и NamesAccepted у CRD не дольше timeout
+func (c *PlatformClient) WaitForCRD(ctx context.Context, name string, timeout time.Duration) error {
+	var lastErr error
+	err := wait.PollImmediateWithContext(ctx, crdPollInterval, timeout, func(ctx context.Context) (bool, error) {
+		lastErr = c.CRDEstablished(ctx, name)
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("CRD %v не готов за %v: %v", name, timeout, lastErr)
+	}
+	return nil
+}
+
+// CRDEstablished возвращает ошибку, если CRD отсутствует или еще не обслуживается API-сервером
+func (c *PlatformClient) CRDEstablished(ctx context.Context, name string) error {
+	crd, err := c.apiextClientset.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("ошибка при чтении CRD %v: %v", name, err)
+	}
+	for _, conditionType := range []apiextensionsv1.CustomResourceDefinitionConditionType{apiextensionsv1.NamesAccepted, apiextensionsv1.Established} {
+		if !crdConditionTrue(crd, conditionType) {
+			return fmt.Errorf("у CRD %v нет условия %v", name, conditionType)
+		}
+	}
+	return nil
+}
+
+func crdConditionTrue(crd *apiextensionsv1.CustomResourceDefinition, conditionType apiextensionsv1.CustomResourceDefinitionConditionType) bool {
+	for _, condition := range crd.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Status == apiextensionsv1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package platform
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+const crdPath = "/apis/apiextensions.k8s.io/v1/customresourcedefinitions/dynamicnamespaces.platform.cloudnative.space"
+
+// crdServer отдает CRD с заданными условиями; nil - CRD отсутствует
+func crdServer(t *testing.T, conditions []apiextensionsv1.CustomResourceDefinitionCondition) *PlatformClient {
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != crdPath || conditions == nil {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(metav1.Status{
+				TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+				Status:   metav1.StatusFailure, Reason: metav1.StatusReasonNotFound, Code: http.StatusNotFound,
+			})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(apiextensionsv1.CustomResourceDefinition{
+			TypeMeta:   metav1.TypeMeta{Kind: "CustomResourceDefinition", APIVersion: "apiextensions.k8s.io/v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: "dynamicnamespaces.platform.cloudnative.space"},
+			Status:     apiextensionsv1.CustomResourceDefinitionStatus{Conditions: conditions},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return NewPlatformClient(&rest.Config{Host: server.URL}, nil)
+}
+
+func TestCRDEstablished(t *testing.T) {
+	var condition = func(conditionType apiextensionsv1.CustomResourceDefinitionConditionType, status apiextensionsv1.ConditionStatus) apiextensionsv1.CustomResourceDefinitionCondition {
+		return apiextensionsv1.CustomResourceDefinitionCondition{Type: conditionType, Status: status}
+	}
+	var tests = []struct {
+		name       string
+		conditions []apiextensionsv1.CustomResourceDefinitionCondition
+		ready      bool
+	}{
+		{name: "missing"},
+		{name: "no conditions", conditions: []apiextensionsv1.CustomResourceDefinitionCondition{}},
+		{name: "names not accepted", conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+			condition(apiextensionsv1.NamesAccepted, apiextensionsv1.ConditionFalse),
+			condition(apiextensionsv1.Established, apiextensionsv1.ConditionTrue),
+		}},
+		{name: "not established", conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+			condition(apiextensionsv1.NamesAccepted, apiextensionsv1.ConditionTrue),
+		}},
+		{name: "established", ready: true, conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+			condition(apiextensionsv1.NamesAccepted, apiextensionsv1.ConditionTrue),
+			condition(apiextensionsv1.Established, apiextensionsv1.ConditionTrue),
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var client = crdServer(t, test.conditions)
+			err := client.CRDEstablished(context.Background(), "dynamicnamespaces.platform.cloudnative.space")
+			if (err == nil) != test.ready {
+				t.Errorf("CRDEstablished() = %v, ожидалась готовность %v", err, test.ready)
+			}
+			err = client.WaitForCRD(context.Background(), "dynamicnamespaces.platform.cloudnative.space", 10*time.Millisecond)
+			if (err == nil) != test.ready {
+				t.Errorf("WaitForCRD() = %v, ожидалась готовность %v", err, test.ready)
+			}
+		})
+	}
+}
+
+func TestCRDInstallerCheck(t *testing.T) {
+	var installer = &CRDInstaller{}
+	if err := installer.Check(nil); err != nil {
+		t.Errorf("Check() без CRD = %v, ожидалось без ошибки", err)
+	}
+	installer.client = crdServer(t, nil)
+	installer.names = []string{"dynamicnamespaces.platform.cloudnative.space"}
+	var request = httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	if err := installer.Check(request); err == nil {
+		t.Error("Check() с удаленным CRD без ошибки")
+	}
+}
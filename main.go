@@ -18,7 +18,9 @@ package main
 
 import (
 	"flag"
+	"io/ioutil"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -28,11 +30,21 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	configv1alpha1 "github.com/wbe7/dynamicnamespace/api/config/v1alpha1"
 	platformv1 "github.com/wbe7/dynamicnamespace/api/v1"
 	"github.com/wbe7/dynamicnamespace/controllers"
+	"github.com/wbe7/dynamicnamespace/internal/configfile"
+	"github.com/wbe7/dynamicnamespace/internal/gitwebhook"
+	"github.com/wbe7/dynamicnamespace/internal/health"
+	"github.com/wbe7/dynamicnamespace/internal/httpapi"
+	"github.com/wbe7/dynamicnamespace/internal/lists"
+	"github.com/wbe7/dynamicnamespace/internal/messages"
+	"github.com/wbe7/dynamicnamespace/internal/notifier"
+	"github.com/wbe7/dynamicnamespace/internal/platform"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -45,53 +57,335 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(platformv1.AddToScheme(scheme))
+	utilruntime.Must(configv1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
+	var configFile string
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var gcInterval time.Duration
+	var gcGracePeriod time.Duration
+	var gcDryRun bool
+	var quotaWarningThreshold int
+	var apiAddr string
+	var apiNamespace string
+	var apiKubeconfigServer string
+	var apiTLSCertFile string
+	var apiTLSKeyFile string
+	var apiInsecure bool
+	var webhookAddr string
+	var webhookCertDir string
+	var webhookInsecure bool
+	var notifierConfig string
+	var approvalQuotaThreshold string
+	var approvalRestrictedRoles string
+	var approverGroups string
+	var installCRDs bool
+	var crdTimeout time.Duration
+	var reconcileTimeout time.Duration
+	var shard string
+	var dryRun bool
+	var maxConcurrentReconciles int
+	var rateLimit controllers.RateLimit
+	flag.StringVar(&configFile, "config", "",
+		"The controller will load its initial configuration from this file. "+
+			"Omit this flag to use the default configuration values. "+
+			"Command-line flags override configuration from this file.")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&gcInterval, "gc-interval", 10*time.Minute,
+		"How often to look for managed namespaces whose DynamicNamespace no longer exists. Zero disables the garbage collector.")
+	flag.DurationVar(&gcGracePeriod, "gc-grace-period", time.Hour,
+		"How long a managed namespace must stay orphaned before it is deleted.")
+	flag.BoolVar(&gcDryRun, "gc-dry-run", false,
+		"Only report orphaned namespaces instead of deleting them.")
+	flag.IntVar(&quotaWarningThreshold, "quota-warning-threshold", 90,
+		"Quota utilization percentage at which the QuotaPressure condition is raised.")
+	flag.StringVar(&apiAddr, "api-bind-address", "",
+		"The address the environments HTTP API binds to. Empty disables the API.")
+	flag.StringVar(&apiNamespace, "api-namespace", "",
+		"Namespace in which DynamicNamespaces requested through the HTTP API are created.")
+	flag.StringVar(&apiKubeconfigServer, "api-kubeconfig-server", "",
+		"Kubernetes API server URL written into kubeconfigs returned by the HTTP API. Defaults to the manager's own API server address.")
+	flag.StringVar(&apiTLSCertFile, "api-tls-cert-file", "", "TLS certificate for the HTTP API. Required unless --api-insecure is set.")
+	flag.StringVar(&apiTLSKeyFile, "api-tls-key-file", "", "TLS private key for the HTTP API.")
+	flag.BoolVar(&apiInsecure, "api-insecure", false,
+		"Serve the HTTP API over plain HTTP, e.g. behind an Ingress that terminates TLS. Bearer tokens and kubeconfigs then cross the wire unencrypted.")
+	flag.StringVar(&webhookAddr, "git-webhook-bind-address", "",
+		"The address the GitLab/GitHub webhook receiver binds to. Empty disables the receiver.")
+	flag.StringVar(&webhookCertDir, "git-webhook-cert-dir", "",
+		"Directory with tls.crt and tls.key for the git webhook receiver. Required unless --git-webhook-insecure is set.")
+	flag.BoolVar(&webhookInsecure, "git-webhook-insecure", false,
+		"Serve the git webhook receiver over plain HTTP, e.g. behind an Ingress that terminates TLS. Webhook payloads and GitLab tokens then cross the wire unencrypted.")
+	flag.StringVar(&notifierConfig, "notifier-config", "",
+		"Secret/<namespace>/<name> or ConfigMap/<namespace>/<name> holding config.yaml with lifecycle notification endpoints. Empty disables notifications.")
+	flag.StringVar(&approvalQuotaThreshold, "approval-quota-threshold", "",
+		"Quota above which an environment waits for approval, e.g. requests.cpu=8,requests.memory=32Gi.")
+	flag.StringVar(&approvalRestrictedRoles, "approval-restricted-roles", "cluster-admin",
+		"Comma-separated ClusterRoles that require approval when requested in spec.role.")
+	flag.StringVar(&approverGroups, "approver-groups", "",
+		"Comma-separated groups whose members may approve environments. Empty disables the approval workflow.")
+	flag.BoolVar(&installCRDs, "install-crds", true,
+		"Install and upgrade the operator CRDs on start. Disable when CRDs are managed by GitOps.")
+	flag.DurationVar(&crdTimeout, "crd-timeout", platform.DefaultCRDTimeout,
+		"How long to wait for the CRDs to become Established before giving up.")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", health.DefaultReconcileTimeout,
+		"A reconcile loop running longer than this fails the liveness check.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 0,
+		"Number of DynamicNamespaces (and pools) reconciled in parallel. Zero takes controller.groupKindConcurrency from the config file, or 1.")
+	flag.DurationVar(&rateLimit.BaseDelay, "rate-limit-base-delay", controllers.DefaultRateLimitBaseDelay,
+		"Delay before the first retry of a failed reconcile; doubled on every further failure.")
+	flag.DurationVar(&rateLimit.MaxDelay, "rate-limit-max-delay", controllers.DefaultRateLimitMaxDelay,
+		"Upper bound of the per-object retry delay.")
+	flag.Float64Var(&rateLimit.QPS, "rate-limit-qps", controllers.DefaultRateLimitQPS,
+		"Average rate at which objects are queued for reconcile, shared by all objects of a controller.")
+	flag.IntVar(&rateLimit.Burst, "rate-limit-burst", controllers.DefaultRateLimitBurst,
+		"Burst allowed above --rate-limit-qps.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Only plan changes: DynamicNamespaces get the planned Namespace, ResourceQuota, RoleBinding and NetworkPolicy changes "+
+			"in status.plan and a Planned event, and nothing is applied or deleted. "+
+			"A single object can be planned with the platform.cloudnative.space/dry-run=true annotation.")
+	flag.StringVar(&shard, "shard", "",
+		"Label selector on platform.cloudnative.space/shard: this instance only reconciles matching DynamicNamespaces and pools, "+
+			"e.g. platform.cloudnative.space/shard=blue. Empty reconciles everything.")
 	opts := zap.Options{
-		Development: true,
+		Development: false,
 	}
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	quotaThreshold, err := lists.ResourceList(approvalQuotaThreshold)
+	if err != nil {
+		setupLog.Error(err, "invalid --approval-quota-threshold")
+		os.Exit(1)
+	}
+	var approvalPolicy = controllers.ApprovalPolicy{
+		QuotaThreshold:  quotaThreshold,
+		RestrictedRoles: lists.Split(approvalRestrictedRoles),
+		ApproverGroups:  lists.Split(approverGroups),
+	}
+
+	var options = ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "a716b450.cloudnative.space",
-	})
+	}
+	var operatorConfig configv1alpha1.DynamicNamespaceConfig
+	if configFile != "" {
+		data, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			setupLog.Error(err, "unable to load the config file")
+			os.Exit(1)
+		}
+		loaded, err := configfile.Decode(scheme, data)
+		if err != nil {
+			setupLog.Error(err, "unable to load the config file")
+			os.Exit(1)
+		}
+		operatorConfig = *loaded
+		// Параметры командной строки, заданные явно, важнее файла
+		var set = explicitFlags()
+		options, err = configfile.ManagerOptions(options, &operatorConfig, set)
+		if err != nil {
+			setupLog.Error(err, "invalid manager settings in the config file")
+			os.Exit(1)
+		}
+		var gc = operatorConfig.GarbageCollection
+		if gc.Interval != nil && !set["gc-interval"] {
+			gcInterval = gc.Interval.Duration
+		}
+		if gc.GracePeriod != nil && !set["gc-grace-period"] {
+			gcGracePeriod = gc.GracePeriod.Duration
+		}
+		if gc.DryRun != nil && !set["gc-dry-run"] {
+			gcDryRun = *gc.DryRun
+		}
+		if operatorConfig.Notifier.ConfigRef != "" && !set["notifier-config"] {
+			notifierConfig = operatorConfig.Notifier.ConfigRef
+		}
+		var limiter = operatorConfig.RateLimiter
+		if limiter.BaseDelay != nil && !set["rate-limit-base-delay"] {
+			rateLimit.BaseDelay = limiter.BaseDelay.Duration
+		}
+		if limiter.MaxDelay != nil && !set["rate-limit-max-delay"] {
+			rateLimit.MaxDelay = limiter.MaxDelay.Duration
+		}
+		if limiter.QPS != nil && !set["rate-limit-qps"] {
+			rateLimit.QPS = float64(*limiter.QPS)
+		}
+		if limiter.Burst != nil && !set["rate-limit-burst"] {
+			rateLimit.Burst = int(*limiter.Burst)
+		}
+	}
+	shardSelector, err := controllers.ParseShard(shard)
+	if err != nil {
+		setupLog.Error(err, "invalid --shard")
+		os.Exit(1)
+	}
+	if shardSelector != nil {
+		// Кэш содержит только объекты шарда; у каждого шарда свой лидер
+		options.NewCache = cache.BuilderWithOptions(cache.Options{SelectorsByObject: controllers.ShardCacheSelectors(shardSelector)})
+		options.LeaderElectionID = controllers.ShardLeaderElectionID(options.LeaderElectionID, shardSelector)
+		setupLog.Info("sharding enabled", "shard", shardSelector.String(), "leaderElectionID", options.LeaderElectionID)
+	}
+	settings, err := controllers.NewSettings(&operatorConfig)
+	if err != nil {
+		setupLog.Error(err, "invalid config file")
+		os.Exit(1)
+	}
+	var settingsStore = &controllers.SettingsStore{}
+	settingsStore.Store(settings)
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	var crdInstaller = &platform.CRDInstaller{Install: installCRDs, Timeout: crdTimeout}
+	var watchdog = &health.Watchdog{Timeout: reconcileTimeout}
+
+	var lifecycleNotifier = &notifier.Notifier{
+		ConfigRef: notifierConfig,
+		Language: func() messages.Language {
+			return settingsStore.Load().Language
+		},
+	}
+	lifecycleNotifier.SetConfig(notifier.ConfigFrom(&operatorConfig.Notifier))
+	if err = lifecycleNotifier.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to set up notifier", "runnable", "notifier")
+		os.Exit(1)
+	}
+
+	// Значения по умолчанию, sourceNamespaces и адресаты уведомлений применяются без перезапуска
+	if err = (&configfile.Watcher{
+		Path: configFile,
+		OnChange: func(config *configv1alpha1.DynamicNamespaceConfig) error {
+			settings, err := controllers.NewSettings(config)
+			if err != nil {
+				return err
+			}
+			settingsStore.Store(settings)
+			lifecycleNotifier.SetConfig(notifier.ConfigFrom(&config.Notifier))
+			return nil
+		},
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to set up config watcher", "runnable", "configfile")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.DynamicNamespaceReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		QuotaWarningThreshold:   int32(quotaWarningThreshold),
+		Notifier:                lifecycleNotifier,
+		Approval:                approvalPolicy,
+		CRDs:                    crdInstaller,
+		Watchdog:                watchdog,
+		Settings:                settingsStore,
+		DryRun:                  dryRun,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		RateLimiter:             rateLimit.NewRateLimiter(),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "DynamicNamespace")
 		os.Exit(1)
 	}
+	if err = (&controllers.ApprovalWebhook{
+		Policy:   &approvalPolicy,
+		Settings: settingsStore,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "DynamicNamespaceApproval")
+		os.Exit(1)
+	}
+	if err = (&controllers.DynamicNamespacePoolReconciler{
+		Client:                  mgr.GetClient(),
+		DryRun:                  dryRun,
+		Scheme:                  mgr.GetScheme(),
+		CRDs:                    crdInstaller,
+		Watchdog:                watchdog,
+		Settings:                settingsStore,
+		Approval:                approvalPolicy,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		RateLimiter:             rateLimit.NewRateLimiter(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DynamicNamespacePool")
+		os.Exit(1)
+	}
+	if err = (&controllers.NamespaceGarbageCollector{
+		Client:      mgr.GetClient(),
+		Interval:    gcInterval,
+		GracePeriod: gcGracePeriod,
+		DryRun:      gcDryRun || dryRun,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create garbage collector", "runnable", "NamespaceGarbageCollector")
+		os.Exit(1)
+	}
+	if err = (&httpapi.Server{
+		Client:           mgr.GetClient(),
+		BindAddress:      apiAddr,
+		Namespace:        apiNamespace,
+		KubeconfigServer: apiKubeconfigServer,
+		TLSCertFile:      apiTLSCertFile,
+		TLSKeyFile:       apiTLSKeyFile,
+		Insecure:         apiInsecure,
+		Shard:            shardSelector,
+		Language: func() messages.Language {
+			return settingsStore.Load().Language
+		},
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to set up HTTP API", "runnable", "httpapi")
+		os.Exit(1)
+	}
+	if err = (&gitwebhook.Receiver{
+		Client:      mgr.GetClient(),
+		BindAddress: webhookAddr,
+		CertDir:     webhookCertDir,
+		Insecure:    webhookInsecure,
+		CRDs:        crdInstaller,
+		Language: func() messages.Language {
+			return settingsStore.Load().Language
+		},
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to set up webhook receiver", "runnable", "gitwebhook")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
+	// Liveness: процесс отвечает и циклы обработки не зависли; Kubernetes перезапустит зависший оператор
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+	if err := mgr.AddHealthzCheck("reconcile", watchdog.Check); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	// Readiness: CRD установлены, кэши синхронизированы, API-сервер доступен
+	apiServerCheck, err := health.APIServer(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("crds", crdInstaller.Check); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("cache", health.CacheSynced(mgr.GetCache())); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("apiserver", apiServerCheck); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
@@ -102,3 +396,10 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// explicitFlags возвращает имена параметров, заданных в командной строке
+func explicitFlags() map[string]bool {
+	var set = map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}